@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Returns the render params of the given frame. The variation strength of the second seed and the
+// weight of the second prompt go from 0 to 1 during the animation.
+func (r ReqParamsAnim) frameParams(frame int) ReqParamsRender {
+	p := r.ReqParamsRender
+	var t float32
+	if r.Frames > 1 {
+		t = float32(frame) / float32(r.Frames-1)
+	}
+	if r.SeedWalk {
+		p.Subseed = r.Seed2
+		p.SubseedStrength = t
+	}
+	if r.Prompt2 != "" {
+		// Blending the prompts using the composable diffusion syntax of the WebUI. The extra networks
+		// are added before the weights, as the weight needs to be at the end of a subprompt. The
+		// embeddings go to both subprompts, the LoRAs only once, as the WebUI activates them for the
+		// whole prompt.
+		second := r.ReqParamsRender
+		second.Prompt = r.Prompt2
+		second.LoRAs = nil
+		p.Prompt = fmt.Sprintf("%s :%.2f AND %s :%.2f", r.PromptWithExtraNetworks(), 1-t,
+			second.PromptWithExtraNetworks(), t)
+		p.LoRAs = nil
+		p.Embeddings = nil
+	}
+	return p
+}
+
+// Assembles the frames to an endlessly looping animated GIF.
+func animEncodeGIF(frames [][]byte, fps int) ([]byte, error) {
+	res := &gif.GIF{}
+	delay := max(100/fps, 2) // In 1/100 seconds.
+	for i, f := range frames {
+		img, _, err := image.Decode(bytes.NewReader(f))
+		if err != nil {
+			return nil, fmt.Errorf("frame %d decode error: %w", i, err)
+		}
+		b := img.Bounds()
+		p := image.NewPaletted(b, palette.Plan9)
+		draw.FloydSteinberg.Draw(p, b, img, b.Min)
+		res.Image = append(res.Image, p)
+		res.Delay = append(res.Delay, delay)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, res); err != nil {
+		return nil, fmt.Errorf("gif encode error: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Assembles the frames to an H.264 MP4 video using the given ffmpeg binary. Telegram shows videos
+// without sound as animations.
+func animEncodeMP4(ctx context.Context, ffmpegPath string, frames [][]byte, fps int) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "sd-anim-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	for i, f := range frames {
+		if err = os.WriteFile(filepath.Join(dir, fmt.Sprintf("frame%04d.png", i)), f, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	outFn := filepath.Join(dir, "anim.mp4")
+	cmd := exec.CommandContext(ctx, ffmpegPath, "-y", "-loglevel", "error", "-framerate", strconv.Itoa(fps),
+		"-i", filepath.Join(dir, "frame%04d.png"), "-c:v", "libx264", "-pix_fmt", "yuv420p",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", "-movflags", "+faststart", outFn)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.ReadFile(outFn)
+}
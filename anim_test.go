@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestAnimFrameParams(t *testing.T) {
+	r := ReqParamsAnim{
+		ReqParamsRender: ReqParamsRender{
+			Prompt:     "a cat",
+			Seed:       1,
+			LoRAs:      []ReqParamsLoRA{{Name: "style", Weight: 0.8}},
+			Embeddings: []string{"emb"},
+		},
+		Frames:   3,
+		Seed2:    2,
+		SeedWalk: true,
+		Prompt2:  "a dog",
+	}
+
+	p := r.frameParams(1)
+	if p.Subseed != 2 || p.SubseedStrength != 0.5 {
+		t.Errorf("got subseed %d with strength %v, expected 2 with 0.5", p.Subseed, p.SubseedStrength)
+	}
+	// The weights have to be at the end of the subprompts.
+	expected := "a cat, emb <lora:style:0.8> :0.50 AND a dog, emb :0.50"
+	if prompt := p.PromptWithExtraNetworks(); prompt != expected {
+		t.Errorf("got prompt %q, expected %q", prompt, expected)
+	}
+
+	if p = r.frameParams(2); p.SubseedStrength != 1 {
+		t.Errorf("got subseed strength %v on the last frame, expected 1", p.SubseedStrength)
+	}
+
+	r.Prompt2 = ""
+	if prompt := r.frameParams(0).PromptWithExtraNetworks(); prompt != "a cat, emb <lora:style:0.8>" {
+		t.Errorf("got prompt %q without prompt travel", prompt)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+const aspectRatioSizeStep = 64
+
+var aspectRatioNames = map[string]string{
+	"square":    "1:1",
+	"portrait":  "2:3",
+	"landscape": "3:2",
+	"wide":      "16:9",
+	"tall":      "9:16",
+}
+
+// Parses aspect ratios like "16:9", "1.5" or "portrait". Returns the ratio (width/height) and the
+// ratio in the w:h format.
+func aspectRatioParse(s string) (ratio float64, ratioStr string, err error) {
+	s = strings.ToLower(s)
+	if name, ok := aspectRatioNames[s]; ok {
+		s = name
+	}
+
+	w, h, found := strings.Cut(s, ":")
+	if !found {
+		h = "1"
+	}
+	wf, err := strconv.ParseFloat(w, 64)
+	if err != nil || wf <= 0 {
+		return 0, "", newLocaleError(localeMsgAspectRatioInvalid)
+	}
+	hf, err := strconv.ParseFloat(h, 64)
+	if err != nil || hf <= 0 {
+		return 0, "", newLocaleError(localeMsgAspectRatioInvalid)
+	}
+	ratio = wf / hf
+	if ratio > 8 || ratio < 1.0/8 {
+		return 0, "", newLocaleError(localeMsgAspectRatioOutOfRange)
+	}
+	return ratio, w + ":" + h, nil
+}
+
+func aspectRatioRoundSize(v float64) int {
+	res := int(math.Round(v/aspectRatioSizeStep)) * aspectRatioSizeStep
+	if res < aspectRatioSizeStep {
+		res = aspectRatioSizeStep
+	}
+	return res
+}
+
+// Returns the image size with the given aspect ratio which has about the given number of pixels.
+// The sizes are rounded to multiples of 64.
+func aspectRatioGetSize(ratio float64, pixelBudget int) (width, height int) {
+	width = aspectRatioRoundSize(math.Sqrt(float64(pixelBudget) * ratio))
+	height = aspectRatioRoundSize(math.Sqrt(float64(pixelBudget) / ratio))
+	return
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestAspectRatioParse(t *testing.T) {
+	tests := []struct {
+		s        string
+		ratio    float64
+		ratioStr string
+		err      bool
+	}{
+		{s: "16:9", ratio: 16.0 / 9, ratioStr: "16:9"},
+		{s: "1.5", ratio: 1.5, ratioStr: "1.5:1"},
+		{s: "Portrait", ratio: 2.0 / 3, ratioStr: "2:3"},
+		{s: "wide", ratio: 16.0 / 9, ratioStr: "16:9"},
+		{s: "9:0", err: true},
+		{s: "-1:2", err: true},
+		{s: "x", err: true},
+		{s: "9:1", err: true},
+		{s: "1:9", err: true},
+	}
+	for _, test := range tests {
+		ratio, ratioStr, err := aspectRatioParse(test.s)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.s, err)
+			continue
+		}
+		if ratio != test.ratio || ratioStr != test.ratioStr {
+			t.Errorf("%s: got %v %s, expected %v %s", test.s, ratio, ratioStr, test.ratio, test.ratioStr)
+		}
+	}
+}
+
+func TestAspectRatioGetSize(t *testing.T) {
+	tests := []struct {
+		ratio         float64
+		pixelBudget   int
+		width, height int
+	}{
+		{1, 512 * 512, 512, 512},
+		{16.0 / 9, 512 * 512, 704, 384},
+		{2.0 / 3, 1024 * 1024, 832, 1280},
+		{8, 512 * 512, 1472, 192},
+		{1.0 / 8, 512 * 512, 192, 1472},
+		// Sizes don't go below the size step.
+		{8, 64 * 64, 192, 64},
+	}
+	for _, test := range tests {
+		width, height := aspectRatioGetSize(test.ratio, test.pixelBudget)
+		if width != test.width || height != test.height {
+			t.Errorf("ratio %v, budget %d: got %dx%d, expected %dx%d", test.ratio, test.pixelBudget,
+				width, height, test.width, test.height)
+		}
+	}
+}
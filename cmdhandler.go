@@ -2,27 +2,45 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os/exec"
 	"strings"
 
+	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
+	"golang.org/x/exp/slices"
 )
 
 type cmdHandlerType struct{}
 
-func (c *cmdHandlerType) SD(ctx context.Context, msg *models.Message) {
-	reqParams := ReqParamsRender{
-		origPrompt:  msg.Text,
-		Seed:        rand.Uint32(),
-		Width:       params.DefaultWidth,
-		Height:      params.DefaultHeight,
-		Steps:       35,
-		NumOutputs:  4,
-		CFGScale:    7,
-		SamplerName: params.DefaultSampler,
-		ModelName:   params.DefaultModel,
+// Returned by parseRenderParams if the prompt is blocked by the chat's content policy.
+type contentPolicyViolationError struct {
+	matched string
+}
+
+func (e *contentPolicyViolationError) Error() string {
+	return "the prompt violates the content policy"
+}
+
+// Returns the render params with the defaults of the message's chat.
+func (c *cmdHandlerType) defaultRenderParams(msg *models.Message) ReqParamsRender {
+	defaults := getParams().ChatDefaults(msg.Chat.ID)
+	return ReqParamsRender{
+		origPrompt:      msg.Text,
+		groupAttrs:      getParams().ChatDefaultAttrs(msg.Chat.ID),
+		Seed:            rand.Uint32(),
+		Width:           defaults.DefaultWidth,
+		Height:          defaults.DefaultHeight,
+		Steps:           defaults.DefaultSteps,
+		NumOutputs:      defaults.DefaultNumOutputs,
+		CFGScale:        defaults.DefaultCFGScale,
+		SamplerName:     defaults.DefaultSampler,
+		ModelName:       defaults.DefaultModel,
+		Grid:            *defaults.DefaultGrid,
+		Output:          outputDefaults.Get(msg.From.ID),
+		RefinerSwitchAt: reqParamsRefinerDefaultSwitchAt,
 		Upscale: ReqParamsUpscale{
 			Upscaler: "LDSR",
 		},
@@ -32,39 +50,121 @@ func (c *cmdHandlerType) SD(ctx context.Context, msg *models.Message) {
 			SecondPassSteps:   15,
 		},
 	}
+}
+
+// Lines of the message starting with these labels set the prompts of the highres mode second pass.
+const hrPromptLabel = "hr:"
+const hrNegativePromptLabel = "hr-neg:"
+
+// Returns the rest of the line if it starts with the given label (case insensitive).
+func cutLineLabel(line, label string) (string, bool) {
+	if len(line) < len(label) || !strings.EqualFold(line[:len(label)], label) {
+		return line, false
+	}
+	return line[len(label):], true
+}
 
-	var paramsLine *string
+// Parses the prompt, the negative prompt, the highres mode prompts and the params from the message
+// text to reqParams, which should contain render. The params are at the end of the last line. Applies
+// the model profile's negative prompt and the content policy of the message's chat.
+func (c *cmdHandlerType) parsePrompt(ctx context.Context, msg *models.Message, reqParams ReqParams, render *ReqParamsRender) error {
 	lines := strings.Split(msg.Text, "\n")
-	if len(lines) >= 2 {
-		reqParams.Prompt = lines[0]
-		reqParams.NegativePrompt = strings.Join(lines[1:], " ")
-		paramsLine = &reqParams.NegativePrompt
-	} else {
-		reqParams.Prompt = msg.Text
-		paramsLine = &reqParams.Prompt
+	render.Prompt = lines[0]
+	paramsLine := &render.Prompt
+	var negativeLines []string
+	for _, line := range lines[1:] {
+		if hrPrompt, ok := cutLineLabel(line, hrPromptLabel); ok {
+			render.HR.Prompt = hrPrompt
+			paramsLine = &render.HR.Prompt
+		} else if hrNegativePrompt, ok := cutLineLabel(line, hrNegativePromptLabel); ok {
+			render.HR.NegativePrompt = hrNegativePrompt
+			paramsLine = &render.HR.NegativePrompt
+		} else {
+			negativeLines = append(negativeLines, line)
+			paramsLine = &render.NegativePrompt
+		}
 	}
-	firstCmdCharAt, err := ReqParamsParse(ctx, *paramsLine, &reqParams)
+	render.NegativePrompt = strings.Join(negativeLines, " ")
+
+	firstCmdCharAt, err := ReqParamsParse(ctx, *paramsLine, reqParams)
 	if err != nil {
-		sendReplyToMessage(ctx, msg, errorStr+": can't parse render params: "+err.Error())
-		return
+		return newLocaleError(localeMsgCantParseParams, err)
 	}
 	if firstCmdCharAt >= 0 { // Commands found? Removing them from the line.
 		*paramsLine = (*paramsLine)[:firstCmdCharAt]
 	}
 
-	reqParams.Prompt = strings.Trim(reqParams.Prompt, " ")
-	reqParams.NegativePrompt = strings.Trim(reqParams.NegativePrompt, " ")
+	render.Prompt = strings.Trim(render.Prompt, " ")
+	render.NegativePrompt = strings.Trim(render.NegativePrompt, " ")
+	render.HR.Prompt = strings.Trim(render.HR.Prompt, " ")
+	render.HR.NegativePrompt = strings.Trim(render.HR.NegativePrompt, " ")
+
+	if render.NegativePrompt == "" && render.profile != nil {
+		render.NegativePrompt = render.profile.NegativePrompt
+	}
+
+	policy := getParams().ContentPolicyFor(msg.Chat.ID)
+	for _, prompt := range []string{render.Prompt, render.HR.Prompt} {
+		if matched := policy.Check(prompt); matched != "" {
+			return &contentPolicyViolationError{matched: matched}
+		}
+	}
+	render.NegativePrompt = policy.ApplyNegativePrompt(render.NegativePrompt)
+	if render.HR.NegativePrompt != "" {
+		render.HR.NegativePrompt = policy.ApplyNegativePrompt(render.HR.NegativePrompt)
+	}
+	return nil
+}
+
+// Parses the render params from the message text, and applies the model profile, the content
+// policy and the limits of the message's chat. If singleOutput is true then only one image is rendered.
+func (c *cmdHandlerType) parseRenderParams(ctx context.Context, msg *models.Message, singleOutput bool) (reqParams ReqParamsRender, limitNotices []string, err error) {
+	reqParams = c.defaultRenderParams(msg)
+	if err = c.parsePrompt(ctx, msg, &reqParams, &reqParams); err != nil {
+		return reqParams, nil, err
+	}
 
 	if reqParams.Prompt == "" {
-		fmt.Println("  missing prompt")
-		sendReplyToMessage(ctx, msg, errorStr+": missing prompt")
-		return
+		return reqParams, nil, newLocaleError(localeMsgMissingPrompt)
 	}
 
-	if reqParams.HR.Scale > 0 || reqParams.Upscale.Scale > 0 {
+	if singleOutput || reqParams.HR.enabled() || reqParams.Upscale.resizes() {
 		reqParams.NumOutputs = 1
 	}
 
+	limits := getParams().LimitsFor(msg.Chat.ID, msg.From.ID)
+	limitNotices, err = limits.applyRender(&reqParams)
+	return
+}
+
+// Replies with the error of parsing the render params. Content policy violations are also
+// reported to the admins.
+func (c *cmdHandlerType) sendParseError(ctx context.Context, msg *models.Message, err error) {
+	var policyErr *contentPolicyViolationError
+	if errors.As(err, &policyErr) {
+		c.reportContentPolicyViolation(ctx, msg, policyErr.matched)
+		c.sendError(ctx, msg, localeFor(msg).T(localeMsgContentPolicyViolation))
+		return
+	}
+	logWithMsg(msg).Info("invalid render request", "error", err)
+	c.sendError(ctx, msg, localeFor(msg).Err(err))
+}
+
+func (c *cmdHandlerType) SD(ctx context.Context, msg *models.Message) {
+	if n, ok := gridParsePick(msg.Text); ok && msg.ReplyToMessage != nil {
+		if err := c.Pick(ctx, msg.ReplyToMessage, *msg.From, n); err != nil {
+			c.sendError(ctx, msg, localeFor(msg).Err(err))
+		}
+		return
+	}
+
+	reqParams, notices, err := c.parseRenderParams(ctx, msg, false)
+	if err != nil {
+		c.sendParseError(ctx, msg, err)
+		return
+	}
+	c.sendLimitNotices(ctx, msg, notices)
+
 	req := ReqQueueReq{
 		Type:    ReqTypeRender,
 		Message: msg,
@@ -73,179 +173,405 @@ func (c *cmdHandlerType) SD(ctx context.Context, msg *models.Message) {
 	reqQueue.Add(req)
 }
 
+// Logs the content policy violation and notifies the admins.
+func (c *cmdHandlerType) reportContentPolicyViolation(ctx context.Context, msg *models.Message, matched string) {
+	logWithMsg(msg).Warn("content policy violation", "matched", matched, "text", msg.Text)
+	sendTextToAdmins(ctx, fmt.Sprintf("🚫 Content policy violation by @%s #%d in chat #%d (matched \"%s\"): %s",
+		msg.From.Username, msg.From.ID, msg.Chat.ID, matched, msg.Text))
+}
+
+// Replies to the message with the localized error prefix and the given text.
+func (c *cmdHandlerType) sendError(ctx context.Context, msg *models.Message, s string) {
+	sendReplyToMessage(ctx, msg, localeFor(msg).T(localeMsgError)+": "+s)
+}
+
+func (c *cmdHandlerType) sendLimitNotices(ctx context.Context, msg *models.Message, notices []string) {
+	if len(notices) == 0 {
+		return
+	}
+	logWithMsg(msg).Info("params clamped to limits", "changes", notices)
+	sendReplyToMessage(ctx, msg, localeFor(msg).T(localeMsgLimitsClamped)+": "+strings.Join(notices, ", "))
+}
+
 func (c *cmdHandlerType) SDUpscale(ctx context.Context, msg *models.Message) {
 	reqParams := ReqParamsUpscale{
 		origPrompt: msg.Text,
 		Scale:      4,
 		Upscaler:   "LDSR",
+		Output:     outputDefaults.Get(msg.From.ID),
 	}
 
 	_, err := ReqParamsParse(ctx, msg.Text, &reqParams)
 	if err != nil {
-		sendReplyToMessage(ctx, msg, errorStr+": can't parse render params: "+err.Error())
+		c.sendError(ctx, msg, localeFor(msg).Err(newLocaleError(localeMsgCantParseParams, err)))
+		return
+	}
+
+	limits := getParams().LimitsFor(msg.Chat.ID, msg.From.ID)
+	notices, err := limits.applyUpscale(&reqParams)
+	if err != nil {
+		logWithMsg(msg).Info("upscale params over limits", "error", err)
+		c.sendError(ctx, msg, localeFor(msg).Err(err))
 		return
 	}
+	c.sendLimitNotices(ctx, msg, notices)
 
 	req := ReqQueueReq{
 		Type:    ReqTypeUpscale,
 		Message: msg,
 		Params:  reqParams,
 	}
+	req.ImageFileID, req.ImageFilename = messageImageFile(msg.ReplyToMessage)
 	reqQueue.Add(req)
 }
 
-func (c *cmdHandlerType) SDCancel(ctx context.Context, msg *models.Message) {
-	if err := reqQueue.CancelCurrentEntry(ctx); err != nil {
-		sendReplyToMessage(ctx, msg, errorStr+": "+err.Error())
+func (c *cmdHandlerType) SDInterrogate(ctx context.Context, msg *models.Message) {
+	reqParams := ReqParamsInterrogate{
+		origPrompt: msg.Text,
+		Model:      reqParamsInterrogateModels[0],
+	}
+	if model := strings.ToLower(strings.TrimSpace(msg.Text)); model != "" {
+		if !slices.Contains(reqParamsInterrogateModels, model) {
+			c.sendError(ctx, msg, localeFor(msg).T(localeMsgInterrogateInvalidModel, strings.Join(reqParamsInterrogateModels, ", ")))
+			return
+		}
+		reqParams.Model = model
 	}
+
+	req := ReqQueueReq{
+		Type:    ReqTypeInterrogate,
+		Message: msg,
+		Params:  reqParams,
+	}
+	req.ImageFileID, req.ImageFilename = messageImageFile(msg.ReplyToMessage)
+	reqQueue.Add(req)
 }
 
-func (c *cmdHandlerType) Models(ctx context.Context, msg *models.Message) {
-	models, err := sdAPI.GetModels(ctx)
-	if err != nil {
-		fmt.Println("  error getting models:", err)
-		sendReplyToMessage(ctx, msg, errorStr+": error getting models: "+err.Error())
+func (c *cmdHandlerType) SDOutpaint(ctx context.Context, msg *models.Message) {
+	reqParams := ReqParamsOutpaint{
+		ReqParamsRender:   c.defaultRenderParams(msg),
+		Fill:              reqParamsOutpaintFills[0],
+		DenoisingStrength: 0.9,
+	}
+	if err := c.parsePrompt(ctx, msg, &reqParams, &reqParams.ReqParamsRender); err != nil {
+		c.sendParseError(ctx, msg, err)
 		return
 	}
-	res := strings.Join(models, ", ")
-	var text string
-	if res != "" {
-		text = "🧩 Available models: " + res + ". Default: " + params.DefaultModel
-	} else {
-		text = "No available models."
+	if !reqParams.extends() {
+		c.sendError(ctx, msg, localeFor(msg).T(localeMsgOutpaintMissingExtension))
+		return
 	}
-	sendReplyToMessage(ctx, msg, text)
-}
 
-func (c *cmdHandlerType) Samplers(ctx context.Context, msg *models.Message) {
-	samplers, err := sdAPI.GetSamplers(ctx)
+	// The outpainted image is the only output, post-processing is not supported.
+	reqParams.NumOutputs = 1
+	reqParams.HR = ReqParamsRenderHR{}
+	reqParams.Upscale = ReqParamsUpscale{}
+
+	limits := getParams().LimitsFor(msg.Chat.ID, msg.From.ID)
+	notices, err := limits.applyOutpaint(&reqParams)
 	if err != nil {
-		fmt.Println("  error getting samplers:", err)
-		sendReplyToMessage(ctx, msg, errorStr+": error getting samplers: "+err.Error())
+		logWithMsg(msg).Info("outpaint params over limits", "error", err)
+		c.sendError(ctx, msg, localeFor(msg).Err(err))
 		return
 	}
-	res := strings.Join(samplers, ", ")
-	var text string
-	if res != "" {
-		text = "🔭 Available samplers: " + res + ". Default: " + params.DefaultSampler
-	} else {
-		text = "No available samplers."
+	c.sendLimitNotices(ctx, msg, notices)
+
+	req := ReqQueueReq{
+		Type:    ReqTypeOutpaint,
+		Message: msg,
+		Params:  reqParams,
 	}
-	sendReplyToMessage(ctx, msg, text)
+	req.ImageFileID, req.ImageFilename = messageImageFile(msg.ReplyToMessage)
+	reqQueue.Add(req)
 }
 
-func (c *cmdHandlerType) Embeddings(ctx context.Context, msg *models.Message) {
-	embs, err := sdAPI.GetEmbeddings(ctx)
+func (c *cmdHandlerType) SDAnim(ctx context.Context, msg *models.Message) {
+	reqParams := ReqParamsAnim{
+		ReqParamsRender: c.defaultRenderParams(msg),
+		Frames:          reqParamsAnimDefaultFrames,
+		FPS:             reqParamsAnimDefaultFPS,
+		Seed2:           rand.Uint32(),
+	}
+	err := c.parsePrompt(ctx, msg, &reqParams, &reqParams.ReqParamsRender)
+	if err == nil && reqParams.Prompt == "" {
+		err = newLocaleError(localeMsgMissingPrompt)
+	}
+	if err == nil {
+		policy := getParams().ContentPolicyFor(msg.Chat.ID)
+		if matched := policy.Check(reqParams.Prompt2); matched != "" {
+			err = &contentPolicyViolationError{matched: matched}
+		}
+	}
 	if err != nil {
-		fmt.Println("  error getting embeddings:", err)
-		sendReplyToMessage(ctx, msg, errorStr+": error getting embeddings: "+err.Error())
+		c.sendParseError(ctx, msg, err)
 		return
 	}
-	res := strings.Join(embs, ", ")
-	var text string
-	if res != "" {
-		text = "Available embeddings: " + res
-	} else {
-		text = "No available embeddings."
+
+	// Walking between two seeds if there's no second prompt to blend into.
+	if reqParams.Prompt2 == "" {
+		reqParams.SeedWalk = true
 	}
-	sendReplyToMessage(ctx, msg, text)
-}
+	// Each frame is a single image, highres mode and post-processing are not supported.
+	reqParams.NumOutputs = 1
+	reqParams.HR = ReqParamsRenderHR{}
+	reqParams.Upscale = ReqParamsUpscale{}
 
-func (c *cmdHandlerType) LoRAs(ctx context.Context, msg *models.Message) {
-	loras, err := sdAPI.GetLoRAs(ctx)
+	limits := getParams().LimitsFor(msg.Chat.ID, msg.From.ID)
+	notices, err := limits.applyAnim(&reqParams)
 	if err != nil {
-		fmt.Println("  error getting loras:", err)
-		sendReplyToMessage(ctx, msg, errorStr+": error getting loras: "+err.Error())
+		logWithMsg(msg).Info("anim params over limits", "error", err)
+		c.sendError(ctx, msg, localeFor(msg).Err(err))
 		return
 	}
-	res := strings.Join(loras, ", ")
-	var text string
-	if res != "" {
-		text = "Available LoRAs: " + res
-	} else {
-		text = "No available LoRAs."
+	c.sendLimitNotices(ctx, msg, notices)
+
+	req := ReqQueueReq{
+		Type:    ReqTypeAnim,
+		Message: msg,
+		Params:  reqParams,
 	}
-	sendReplyToMessage(ctx, msg, text)
+	reqQueue.Add(req)
 }
 
-func (c *cmdHandlerType) Upscalers(ctx context.Context, msg *models.Message) {
-	ups, err := sdAPI.GetUpscalers(ctx)
-	if err != nil {
-		fmt.Println("  error getting upscalers:", err)
-		sendReplyToMessage(ctx, msg, errorStr+": error getting upscalers: "+err.Error())
-		return
+// Queues the render of the prompt in the code block of the given interrogation result message.
+// The request message is the result message, with the user who pressed the render button as the sender.
+func (c *cmdHandlerType) RenderInterrogated(ctx context.Context, resultMsg *models.Message, from models.User) error {
+	prompt := messageEntityText(resultMsg, models.MessageEntityTypeCode)
+	if prompt == "" {
+		return newLocaleError(localeMsgInterrogateNoPrompt)
 	}
-	res := strings.Join(ups, ", ")
-	var text string
-	if res != "" {
-		text = "🔎 Available upscalers: " + res
-	} else {
-		text = "🔎 No available upscalers."
+	msg := &models.Message{
+		ID:   resultMsg.ID,
+		From: &from,
+		Chat: resultMsg.Chat,
+		Text: prompt,
+	}
+	if !messageAllowed(msg) {
+		return newLocaleError(localeMsgNotAllowed)
 	}
-	sendReplyToMessage(ctx, msg, text)
+	logWithMsg(msg).Info("rendering interrogated prompt", "prompt", prompt)
+	c.SD(ctx, msg)
+	return nil
 }
 
-func (c *cmdHandlerType) VAEs(ctx context.Context, msg *models.Message) {
-	vaes, err := sdAPI.GetVAEs(ctx)
+// Cancels the request which the message replies to, or the currently processed request.
+func (c *cmdHandlerType) SDCancel(ctx context.Context, msg *models.Message) {
+	var err error
+	if msg.ReplyToMessage != nil {
+		taskID, found := reqQueue.FindEntryByMessage(msg.Chat.ID, msg.ReplyToMessage.ID)
+		if found {
+			err = reqQueue.CancelEntry(ctx, taskID, msg.From.ID)
+		} else {
+			err = newLocaleError(localeMsgRequestNotFound)
+		}
+	} else {
+		err = reqQueue.CancelCurrentEntry(ctx, msg.From.ID)
+	}
 	if err != nil {
-		fmt.Println("  error getting vaes:", err)
-		sendReplyToMessage(ctx, msg, errorStr+": error getting vaes: "+err.Error())
-		return
+		c.sendError(ctx, msg, localeFor(msg).Err(err))
 	}
-	res := strings.Join(vaes, ", ")
-	var text string
-	if res != "" {
-		text = "Available VAEs: " + res
-	} else {
-		text = "No available VAEs."
+}
+
+func (c *cmdHandlerType) Queue(ctx context.Context, msg *models.Message) {
+	sendReplyToMessage(ctx, msg, reqQueue.Status(localeFor(msg)))
+}
+
+func (c *cmdHandlerType) Models(ctx context.Context, msg *models.Message) {
+	c.sendListing(ctx, msg, listingModels, msg.Text)
+}
+
+// Shows the loaded model, loads a model (admins only) or refreshes the model list.
+func (c *cmdHandlerType) Model(ctx context.Context, msg *models.Message) {
+	loc := localeFor(msg)
+	subCmd, arg, _ := strings.Cut(strings.TrimSpace(msg.Text), " ")
+	arg = strings.TrimSpace(arg)
+
+	switch subCmd {
+	case "":
+		model, err := sdAPI.GetLoadedModel(ctx)
+		if err != nil {
+			logWithMsg(msg).Error("error getting loaded model", "error", err)
+			c.sendError(ctx, msg, loc.T(localeMsgErrGetting, "loaded model", err))
+			return
+		}
+		sendReplyToMessage(ctx, msg, loc.T(localeMsgModelLoaded, model))
+	case "set":
+		if !getParams().IsAdmin(msg.From.ID) {
+			c.sendError(ctx, msg, loc.T(localeMsgAdminOnly))
+			return
+		}
+		models, err := sdAPI.GetModels(ctx)
+		if err != nil {
+			logWithMsg(msg).Error("error getting models", "error", err)
+			c.sendError(ctx, msg, loc.T(localeMsgErrGetting, "models", err))
+			return
+		}
+		if !slices.Contains(models, arg) {
+			c.sendError(ctx, msg, loc.T(localeMsgModelInvalid, arg))
+			return
+		}
+
+		logWithMsg(msg).Info("loading model", "model", arg)
+		reply := sendReplyToMessage(ctx, msg, loc.T(localeMsgLoadingModel, arg))
+		if err = sdAPI.SetModel(ctx, arg); err != nil {
+			logWithMsg(msg).Error("can't load model", "model", arg, "error", err)
+			c.sendError(ctx, msg, loc.T(localeMsgModelLoadError, err))
+			return
+		}
+		reqQueue.SetLoadedModel(arg)
+		text := loc.T(localeMsgModelLoaded, arg)
+		if reply == nil {
+			sendReplyToMessage(ctx, msg, text)
+			return
+		}
+		_, _ = telegramBot.EditMessageText(ctx, &bot.EditMessageTextParams{
+			MessageID: reply.ID,
+			ChatID:    reply.Chat.ID,
+			Text:      text,
+		})
+	case "refresh":
+		if err := sdAPI.RefreshModels(ctx); err != nil {
+			logWithMsg(msg).Error("can't refresh models", "error", err)
+			c.sendError(ctx, msg, loc.T(localeMsgModelRefreshError, err))
+			return
+		}
+		c.sendListing(ctx, msg, listingModels, "")
+	default:
+		c.sendError(ctx, msg, loc.T(localeMsgInvalidCommand))
 	}
-	sendReplyToMessage(ctx, msg, text)
+}
+
+func (c *cmdHandlerType) Samplers(ctx context.Context, msg *models.Message) {
+	c.sendListing(ctx, msg, listingSamplers, msg.Text)
+}
+
+func (c *cmdHandlerType) Embeddings(ctx context.Context, msg *models.Message) {
+	c.sendListing(ctx, msg, listingEmbeddings, msg.Text)
+}
+
+func (c *cmdHandlerType) LoRAs(ctx context.Context, msg *models.Message) {
+	c.sendListing(ctx, msg, listingLoRAs, msg.Text)
+}
+
+func (c *cmdHandlerType) Upscalers(ctx context.Context, msg *models.Message) {
+	c.sendListing(ctx, msg, listingUpscalers, msg.Text)
+}
+
+func (c *cmdHandlerType) VAEs(ctx context.Context, msg *models.Message) {
+	c.sendListing(ctx, msg, listingVAEs, msg.Text)
 }
 
 func (c *cmdHandlerType) SMI(ctx context.Context, msg *models.Message) {
 	cmd := exec.Command("nvidia-smi")
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		fmt.Println("  error running nvidia-smi:", err)
-		sendReplyToMessage(ctx, msg, errorStr+": error running nvidia-smi: "+err.Error())
+		logWithMsg(msg).Error("error running nvidia-smi", "error", err)
+		c.sendError(ctx, msg, localeFor(msg).T(localeMsgSMIError, err))
 		return
 	}
 	sendReplyToMessage(ctx, msg, string(out))
 }
 
+func (c *cmdHandlerType) Lang(ctx context.Context, msg *models.Message) {
+	loc := localeFor(msg)
+	args := strings.Fields(msg.Text)
+	if len(args) == 0 {
+		sendReplyToMessage(ctx, msg, loc.T(localeMsgLangCurrent, loc.code, localeList()))
+		return
+	}
+
+	setGroup := args[0] == "group"
+	if setGroup {
+		args = args[1:]
+		if msg.Chat.ID >= 0 {
+			c.sendError(ctx, msg, loc.T(localeMsgLangNotGroup))
+			return
+		}
+		if !getParams().IsAdmin(msg.From.ID) {
+			c.sendError(ctx, msg, loc.T(localeMsgLangGroupAdminOnly))
+			return
+		}
+	}
+	if len(args) != 1 {
+		c.sendError(ctx, msg, loc.T(localeMsgLangUnknown, strings.Join(args, " ")))
+		return
+	}
+
+	var code string
+	if args[0] != "reset" {
+		newLoc := localeFind(args[0])
+		if newLoc == nil {
+			c.sendError(ctx, msg, loc.T(localeMsgLangUnknown, args[0]))
+			return
+		}
+		code = newLoc.code
+	}
+
+	var err error
+	if setGroup {
+		err = localeOverrides.SetGroup(msg.Chat.ID, code)
+	} else {
+		err = localeOverrides.SetUser(msg.From.ID, code)
+	}
+	if err != nil {
+		logWithMsg(msg).Error("can't save language override", "error", err)
+		c.sendError(ctx, msg, localeFor(msg).Err(err))
+		return
+	}
+	logWithMsg(msg).Info("language override set", "group", setGroup, "lang", code)
+
+	// Replying in the new language.
+	loc = localeFor(msg)
+	switch {
+	case setGroup && code == "":
+		sendReplyToMessage(ctx, msg, loc.T(localeMsgLangGroupReset))
+	case setGroup:
+		sendReplyToMessage(ctx, msg, loc.T(localeMsgLangGroupSet, locales[code].name))
+	case code == "":
+		sendReplyToMessage(ctx, msg, loc.T(localeMsgLangReset))
+	default:
+		sendReplyToMessage(ctx, msg, loc.T(localeMsgLangSet, locales[code].name))
+	}
+}
+
+func (c *cmdHandlerType) Format(ctx context.Context, msg *models.Message) {
+	loc := localeFor(msg)
+	text := strings.TrimSpace(msg.Text)
+	if text == "" {
+		output := outputDefaults.Get(msg.From.ID)
+		if output == (ReqParamsOutput{}) {
+			output.Format = reqParamsOutputFormats[0]
+		}
+		sendReplyToMessage(ctx, msg, loc.T(localeMsgFormatCurrent, output.String()))
+		return
+	}
+
+	var output ReqParamsOutput
+	if text != "reset" {
+		firstCmdCharAt, err := ReqParamsParse(ctx, text, &output)
+		if err == nil && firstCmdCharAt != 0 {
+			err = newLocaleError(localeMsgFormatOnlyOutputParams)
+		}
+		if err != nil {
+			c.sendError(ctx, msg, loc.Err(newLocaleError(localeMsgCantParseParams, err)))
+			return
+		}
+	}
+
+	if err := outputDefaults.Set(msg.From.ID, output); err != nil {
+		logWithMsg(msg).Error("can't save output default", "error", err)
+		c.sendError(ctx, msg, localeFor(msg).Err(err))
+		return
+	}
+	logWithMsg(msg).Info("output default set", "output", output.String())
+
+	if output == (ReqParamsOutput{}) {
+		sendReplyToMessage(ctx, msg, loc.T(localeMsgFormatReset))
+	} else {
+		sendReplyToMessage(ctx, msg, loc.T(localeMsgFormatSet, output.String()))
+	}
+}
+
 func (c *cmdHandlerType) Help(ctx context.Context, msg *models.Message, cmdChar string) {
-	sendReplyToMessage(ctx, msg, "🤖 Stable Diffusion Telegram Bot\n\n"+
-		"Available commands:\n\n"+
-		cmdChar+"sd [prompt] - render prompt\n"+
-		cmdChar+"sdupscale - upscale image\n"+
-		cmdChar+"sdcancel - cancel ongoing request\n"+
-		cmdChar+"sdmodels - list available models\n"+
-		cmdChar+"sdsamplers - list available samplers\n"+
-		cmdChar+"sdembeddings - list available embeddings\n"+
-		cmdChar+"sdloras - list available LoRAs\n"+
-		cmdChar+"sdupscalers - list available upscalers\n"+
-		cmdChar+"sdvaes - list available VAEs\n"+
-		cmdChar+"sdsmi - get the output of nvidia-smi\n"+
-		cmdChar+"sdhelp - show this help\n\n"+
-		"Available render parameters at the end of the prompt:\n\n"+
-		"-seed/s - set seed\n"+
-		"-width/w - set output image width\n"+
-		"-height/h - set output image height\n"+
-		"-steps/t - set the number of steps\n"+
-		"-outcnt/o - set count of output images\n"+
-		"-png - upload PNGs instead of JPEGs\n"+
-		"-cfg/c - set CFG scale\n"+
-		"-sampler/r - set sampler, get valid values with /sdsamplers\n"+
-		"-model/m - set model, get valid values with /sdmodels\n"+
-		"-upscale/u - upscale output image with ratio\n"+
-		"-upscaler - set upscaler method, get valid values with /sdupscalers\n"+
-		"-hr - enable highres mode and set upscale ratio\n"+
-		"-hr-denoisestrength/hrd - set highres mode denoise strength\n"+
-		"-hr-upscaler/hru - set highres mode upscaler, get valid values with /sdupscalers\n"+
-		"-hr-steps/hrt - set the number of highres mode second pass steps\n\n"+
-		"Available upscale parameters:\n\n"+
-		"-upscale/u - upscale output image with ratio\n"+
-		"-upscaler - set upscaler method, get valid values with /sdupscalers\n"+
-		"-png - upload PNGs instead of JPEGs\n\n"+
-		"For more information see https://github.com/nonoo/stable-diffusion-telegram-bot")
+	sendReplyToMessage(ctx, msg, localeFor(msg).T(localeMsgHelp, cmdChar))
 }
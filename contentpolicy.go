@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type paramsContentPolicyType struct {
+	// Words which can't be used in prompts, matched case insensitively as whole words.
+	BlockedWords []string `yaml:"blocked_words"`
+	// Regular expressions which can't match prompts.
+	BlockedRegexps []string `yaml:"blocked_regexps"`
+	// Always appended to the negative prompt.
+	NegativePromptSuffix string `yaml:"negative_prompt_suffix"`
+	// If true, then output images are sent as spoiler media.
+	Spoiler bool `yaml:"spoiler"`
+
+	blocked []*regexp.Regexp
+}
+
+// The submatch of blocked word regexps which contains the word without the boundary characters.
+const contentPolicyWordGroup = "blockedword"
+
+func (c *paramsContentPolicyType) compile() error {
+	c.blocked = nil
+	for _, w := range c.BlockedWords {
+		// \b only handles ASCII word characters, so the boundaries are letters and numbers of any script.
+		c.blocked = append(c.blocked, regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}])(?P<`+contentPolicyWordGroup+`>`+
+			regexp.QuoteMeta(w)+`)(?:$|[^\p{L}\p{N}])`))
+	}
+	for _, r := range c.BlockedRegexps {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return fmt.Errorf("invalid content policy regexp %s: %w", r, err)
+		}
+		c.blocked = append(c.blocked, re)
+	}
+	return nil
+}
+
+// The content policy of a chat, with the global and group policies merged.
+type contentPolicy struct {
+	blocked              []*regexp.Regexp
+	NegativePromptSuffix string
+	Spoiler              bool
+}
+
+// Returns the first blocked expression which matches the prompt, or an empty string if there's no match.
+func (c *contentPolicy) Check(prompt string) (matched string) {
+	for _, re := range c.blocked {
+		m := re.FindStringSubmatch(prompt)
+		if m == nil {
+			continue
+		}
+		if i := re.SubexpIndex(contentPolicyWordGroup); i > 0 {
+			return m[i]
+		}
+		if m[0] != "" {
+			return m[0]
+		}
+	}
+	return ""
+}
+
+// Appends the forced negative prompt suffix to the given negative prompt.
+func (c *contentPolicy) ApplyNegativePrompt(negativePrompt string) string {
+	if c.NegativePromptSuffix == "" {
+		return negativePrompt
+	}
+	if negativePrompt == "" {
+		return c.NegativePromptSuffix
+	}
+	return strings.TrimRight(negativePrompt, ", ") + ", " + c.NegativePromptSuffix
+}
+
+// Returns the content policy for the given chat. Blocklists and negative prompt suffixes of the
+// global and the group policy are both applied.
+func (p *paramsType) ContentPolicyFor(chatID int64) contentPolicy {
+	res := contentPolicy{
+		blocked:              p.ContentPolicy.blocked,
+		NegativePromptSuffix: p.ContentPolicy.NegativePromptSuffix,
+		Spoiler:              p.ContentPolicy.Spoiler,
+	}
+
+	g, ok := p.Groups[chatID]
+	if !ok {
+		return res
+	}
+	res.blocked = append(append([]*regexp.Regexp{}, res.blocked...), g.ContentPolicy.blocked...)
+	if g.ContentPolicy.NegativePromptSuffix != "" {
+		if res.NegativePromptSuffix != "" {
+			res.NegativePromptSuffix += ", "
+		}
+		res.NegativePromptSuffix += g.ContentPolicy.NegativePromptSuffix
+	}
+	res.Spoiler = res.Spoiler || g.ContentPolicy.Spoiler
+	return res
+}
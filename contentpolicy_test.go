@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestContentPolicyCheck(t *testing.T) {
+	p := paramsContentPolicyType{
+		BlockedWords:   []string{"nude", "éjjel", "кровь", "c++"},
+		BlockedRegexps: []string{"(?i)blood\\w*"},
+	}
+	if err := p.compile(); err != nil {
+		t.Fatal(err)
+	}
+	policy := contentPolicy{blocked: p.blocked}
+
+	tests := []struct {
+		prompt  string
+		matched string
+	}{
+		{"a cat", ""},
+		{"Nude painting", "Nude"},
+		{"a nude_statue", "nude"},
+		{"nudes", ""},
+		{"denude", ""},
+		{"az éjjel", "éjjel"},
+		{"éjjelente", ""},
+		{"кровь!", "кровь"},
+		{"кровью", ""},
+		{"learning c++ fast", "c++"},
+		{"bloody mary", "bloody"},
+	}
+	for _, test := range tests {
+		if matched := policy.Check(test.prompt); matched != test.matched {
+			t.Errorf("%q: got %q, expected %q", test.prompt, matched, test.matched)
+		}
+	}
+
+	p.BlockedRegexps = []string{"("}
+	if err := p.compile(); err == nil {
+		t.Error("expected an error for an invalid regexp")
+	}
+}
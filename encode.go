@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Default qualities of the lossy output formats.
+var outputDefaultQualities = map[string]int{
+	"jpg":  80,
+	"webp": 80,
+	"avif": 60,
+}
+
+// Encodes a PNG image to the output format.
+type outputEncoderFn func(ctx context.Context, img []byte, o ReqParamsOutput) ([]byte, error)
+
+var outputEncoders = map[string]outputEncoderFn{
+	"jpg":           outputEncodeJPEG,
+	"png":           func(ctx context.Context, img []byte, o ReqParamsOutput) ([]byte, error) { return img, nil },
+	"webp":          outputEncodeWebP,
+	"webp-lossless": outputEncodeWebP,
+	"avif":          outputEncodeAVIF,
+}
+
+// Returns an error if the output can't be encoded with the current config.
+func (o ReqParamsOutput) check() error {
+	if getParams().FFmpegPath != "" {
+		return nil
+	}
+	switch {
+	case o.format() == "webp" || o.format() == "webp-lossless" || o.format() == "avif":
+		return newLocaleError(localeMsgOutputNeedsFFmpeg, o.format())
+	case o.Chroma == "444":
+		return newLocaleError(localeMsgChromaNeedsFFmpeg)
+	}
+	return nil
+}
+
+// Encodes the PNG image to the given output format.
+func outputEncode(ctx context.Context, img []byte, o ReqParamsOutput) ([]byte, error) {
+	encoder, ok := outputEncoders[o.format()]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format: %s", o.format())
+	}
+	return encoder(ctx, img, o)
+}
+
+// The standard library's encoder only supports 4:2:0 chroma subsampling, ffmpeg is used for 4:4:4.
+func outputEncodeJPEG(ctx context.Context, img []byte, o ReqParamsOutput) ([]byte, error) {
+	if o.Chroma == "444" {
+		// The qscale of ffmpeg is between 2 (best) and 31 (worst).
+		qscale := 2 + (100-o.quality())*29/99
+		return outputFFmpegConvert(ctx, img, "jpg", "-c:v", "mjpeg", "-pix_fmt", "yuvj444p", "-q:v", fmt.Sprint(qscale))
+	}
+
+	p, _, err := image.Decode(bytes.NewReader(img))
+	if err != nil {
+		return nil, fmt.Errorf("png decode error: %w", err)
+	}
+	buf := new(bytes.Buffer)
+	if err = jpeg.Encode(buf, p, &jpeg.Options{Quality: o.quality()}); err != nil {
+		return nil, fmt.Errorf("jpg encode error: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func outputEncodeWebP(ctx context.Context, img []byte, o ReqParamsOutput) ([]byte, error) {
+	if o.lossless() {
+		return outputFFmpegConvert(ctx, img, "webp", "-c:v", "libwebp", "-lossless", "1", "-compression_level", "6")
+	}
+	return outputFFmpegConvert(ctx, img, "webp", "-c:v", "libwebp", "-quality", fmt.Sprint(o.quality()), "-compression_level", "4")
+}
+
+func outputEncodeAVIF(ctx context.Context, img []byte, o ReqParamsOutput) ([]byte, error) {
+	// The CRF of libaom is between 0 (best) and 63 (worst).
+	crf := (100 - o.quality()) * 63 / 99
+	pixFmt := "yuv420p"
+	if o.Chroma == "444" {
+		pixFmt = "yuv444p"
+	}
+	return outputFFmpegConvert(ctx, img, "avif", "-c:v", "libaom-av1", "-still-picture", "1", "-cpu-used", "6",
+		"-crf", fmt.Sprint(crf), "-pix_fmt", pixFmt)
+}
+
+// Converts the PNG image to a file with the given extension using ffmpeg with the given codec args.
+func outputFFmpegConvert(ctx context.Context, img []byte, ext string, codecArgs ...string) ([]byte, error) {
+	ffmpegPath := getParams().FFmpegPath
+	if ffmpegPath == "" {
+		return nil, fmt.Errorf("ffmpeg_path is not set")
+	}
+
+	dir, err := os.MkdirTemp("", "sd-output-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	inFn := filepath.Join(dir, "in.png")
+	if err = os.WriteFile(inFn, img, 0600); err != nil {
+		return nil, err
+	}
+	outFn := filepath.Join(dir, "out."+ext)
+	args := append([]string{"-y", "-loglevel", "error", "-i", inFn}, codecArgs...)
+	cmd := exec.CommandContext(ctx, ffmpegPath, append(args, outFn)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.ReadFile(outFn)
+}
+
+// Default output formats set by users with the /sdfmt command. They are saved to a JSON file if a
+// filename is set.
+type outputDefaultsType struct {
+	mutex sync.Mutex
+	fn    string
+
+	Users map[int64]ReqParamsOutput `json:"users"`
+}
+
+var outputDefaults outputDefaultsType
+
+// Loads the defaults from the given file. A missing file is not an error.
+func (o *outputDefaultsType) Load(fn string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.fn = fn
+	o.Users = make(map[int64]ReqParamsOutput)
+	if fn == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("can't read output defaults file: %w", err)
+	}
+	if err = json.Unmarshal(data, o); err != nil {
+		return fmt.Errorf("can't parse output defaults file: %w", err)
+	}
+	return nil
+}
+
+func (o *outputDefaultsType) save() error {
+	if o.fn == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(o.fn, data, 0644); err != nil {
+		return fmt.Errorf("can't write output defaults file: %w", err)
+	}
+	return nil
+}
+
+// Returns the default output of the user, which is the zero value if it's not set.
+func (o *outputDefaultsType) Get(userID int64) ReqParamsOutput {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.Users[userID]
+}
+
+// Sets the default output of a user. The zero value removes the default.
+func (o *outputDefaultsType) Set(userID int64, output ReqParamsOutput) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if output == (ReqParamsOutput{}) {
+		delete(o.Users, userID)
+	} else {
+		o.Users[userID] = output
+	}
+	return o.save()
+}
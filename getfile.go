@@ -29,15 +29,16 @@ func (wc *WriteCounter) Write(p []byte) (int, error) {
 
 	if time.Since(wc.LastProgressPrintAt) > wc.ProgressPrintInterval {
 		progressPercent := int(float64(wc.GotBytes) / float64(wc.TotalBytes) * 100)
-		fmt.Print("    progress: ", progressPercent, "%\n")
-		reqQueue.currentEntry.entry.sendReply(wc.Ctx, downloadingStr+" "+getProgressbar(progressPercent, progressBarLength))
+		reqQueue.currentEntry.entry.log().Debug("download progress", "percent", progressPercent)
+		reqQueue.currentEntry.entry.sendReply(wc.Ctx, reqQueue.currentEntry.entry.loc().T(localeMsgDownloading)+" "+getProgressbar(progressPercent, progressBarLength))
 		wc.LastProgressPrintAt = time.Now()
 	}
 	return n, nil
 }
 
 func (g *GetFile) GetFile(ctx context.Context, fileID string) (d []byte, err error) {
-	fmt.Println("  downloading...")
+	log := reqQueue.currentEntry.entry.log()
+	log.Info("downloading...")
 
 	f, err := telegramBot.GetFile(ctx, &bot.GetFileParams{
 		FileID: fileID,
@@ -45,9 +46,10 @@ func (g *GetFile) GetFile(ctx context.Context, fileID string) (d []byte, err err
 	if err != nil {
 		return nil, err
 	}
-	resp, err := http.Get("https://api.telegram.org/file/bot" + params.BotToken + "/" + f.FilePath)
+	resp, err := http.Get("https://api.telegram.org/file/bot" + getParams().BotToken + "/" + f.FilePath)
 	if err != nil {
-		return nil, err
+		// The error contains the URL which contains the bot token.
+		return nil, fmt.Errorf("%s", redactSecrets(err.Error()))
 	}
 	defer resp.Body.Close()
 
@@ -66,6 +68,6 @@ func (g *GetFile) GetFile(ctx context.Context, fileID string) (d []byte, err err
 		return nil, err
 	}
 
-	fmt.Println("  downloading done")
+	log.Info("downloading done")
 	return d, nil
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"golang.org/x/exp/slices"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// The max. width and height of contact sheets, Telegram downscales bigger photos anyway.
+const gridMaxSize = 2560
+
+// The images of this many contact sheets are kept in memory for picking, for max. gridCacheTTL.
+const gridCacheSize = 20
+const gridCacheTTL = time.Hour
+
+// The callback data of the pick buttons under contact sheets is "sdpick:<image number>".
+const gridPickCallbackPrefix = "sdpick:"
+
+// Composes the images to one contact sheet with the image numbers drawn in the top left corners.
+// Cells have the size of the first image, downscaled if the sheet would be too big.
+func gridCompose(imgs [][]byte) ([]byte, error) {
+	var decoded []image.Image
+	for i := range imgs {
+		img, _, err := image.Decode(bytes.NewReader(imgs[i]))
+		if err != nil {
+			return nil, fmt.Errorf("image decode error: %w", err)
+		}
+		decoded = append(decoded, img)
+	}
+	if len(decoded) == 0 {
+		return nil, fmt.Errorf("no images")
+	}
+
+	cols := int(math.Ceil(math.Sqrt(float64(len(decoded)))))
+	rows := (len(decoded) + cols - 1) / cols
+	cellWidth, cellHeight := decoded[0].Bounds().Dx(), decoded[0].Bounds().Dy()
+	scale := min(1, gridMaxSize/float64(max(cols*cellWidth, rows*cellHeight)))
+	cellWidth = max(int(float64(cellWidth)*scale), 1)
+	cellHeight = max(int(float64(cellHeight)*scale), 1)
+
+	sheet := image.NewRGBA(image.Rect(0, 0, cols*cellWidth, rows*cellHeight))
+	draw.Draw(sheet, sheet.Bounds(), image.Black, image.Point{}, draw.Src)
+	labelScale := max(cellHeight/160, 1)
+	for i, img := range decoded {
+		cell := image.Rect(0, 0, cellWidth, cellHeight).Add(image.Pt(i%cols*cellWidth, i/cols*cellHeight))
+		draw.ApproxBiLinear.Scale(sheet, cell, img, img.Bounds(), draw.Src, nil)
+		gridDrawLabel(sheet, cell.Min, strconv.Itoa(i+1), labelScale)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, sheet, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("jpg encode error: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Draws the text on a dark background to the given position, enlarged with the given scale.
+func gridDrawLabel(dst draw.Image, at image.Point, text string, scale int) {
+	face := basicfont.Face7x13
+	const padding = 3
+	label := image.NewRGBA(image.Rect(0, 0, len(text)*face.Advance+2*padding, face.Metrics().Height.Ceil()+2*padding))
+	draw.Draw(label, label.Bounds(), &image.Uniform{color.RGBA{A: 160}}, image.Point{}, draw.Src)
+	d := font.Drawer{
+		Dst:  label,
+		Src:  image.White,
+		Face: face,
+		Dot:  fixed.P(padding, padding+face.Metrics().Ascent.Ceil()),
+	}
+	d.DrawString(text)
+
+	r := image.Rect(0, 0, label.Bounds().Dx()*scale, label.Bounds().Dy()*scale).Add(at)
+	draw.NearestNeighbor.Scale(dst, r, label, label.Bounds(), draw.Over, nil)
+}
+
+// Returns the image number of a "-pick N" message.
+func gridParsePick(s string) (n int, ok bool) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 || strings.ToLower(fields[0]) != "-pick" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(fields[1])
+	return n, err == nil
+}
+
+type gridCacheEntry struct {
+	chatID int64
+	msgID  int
+
+	imgs      [][]byte
+	originals [][]byte // Nil if the originals are not requested.
+	filenames []string
+	captions  []string
+	addedAt   time.Time
+}
+
+// Full resolution images of the recently sent contact sheets, looked up by the contact sheet's message.
+type gridCacheType struct {
+	mutex   sync.Mutex
+	entries []*gridCacheEntry
+}
+
+var gridCache gridCacheType
+
+func (c *gridCacheType) add(e *gridCacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e.addedAt = time.Now()
+	c.entries = slices.DeleteFunc(c.entries, func(e *gridCacheEntry) bool { return time.Since(e.addedAt) > gridCacheTTL })
+	if len(c.entries) >= gridCacheSize {
+		c.entries = c.entries[len(c.entries)-gridCacheSize+1:]
+	}
+	c.entries = append(c.entries, e)
+}
+
+func (c *gridCacheType) get(chatID int64, msgID int) *gridCacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	i := slices.IndexFunc(c.entries, func(e *gridCacheEntry) bool { return e.chatID == chatID && e.msgID == msgID })
+	if i < 0 || time.Since(c.entries[i].addedAt) > gridCacheTTL {
+		return nil
+	}
+	return c.entries[i]
+}
+
+// Returns the pick buttons for a contact sheet of the given number of images.
+func gridPickMarkup(count int) models.ReplyMarkup {
+	var buttons []models.InlineKeyboardButton
+	for i := 1; i <= count; i++ {
+		buttons = append(buttons, models.InlineKeyboardButton{Text: strconv.Itoa(i), CallbackData: gridPickCallbackPrefix + strconv.Itoa(i)})
+	}
+	// Short rows, so the buttons are usable on small screens too.
+	var rows [][]models.InlineKeyboardButton
+	for len(buttons) > 0 {
+		n := min(len(buttons), 5)
+		rows = append(rows, buttons[:n])
+		buttons = buttons[n:]
+	}
+	return models.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// Sends the full resolution image with the given number (starting from 1) of the contact sheet as a
+// reply to the contact sheet. The sender is the user who requested the image.
+func (c *cmdHandlerType) Pick(ctx context.Context, gridMsg *models.Message, from models.User, n int) error {
+	msg := &models.Message{
+		ID:   gridMsg.ID,
+		From: &from,
+		Chat: gridMsg.Chat,
+	}
+	if !messageAllowed(msg) {
+		return newLocaleError(localeMsgNotAllowed)
+	}
+
+	e := gridCache.get(gridMsg.Chat.ID, gridMsg.ID)
+	if e == nil {
+		return newLocaleError(localeMsgGridExpired)
+	}
+	if n < 1 || n > len(e.imgs) {
+		return newLocaleError(localeMsgGridInvalidPick, len(e.imgs))
+	}
+
+	logWithMsg(msg).Info("sending picked image", "number", n)
+	var originals [][]byte
+	if e.originals != nil {
+		originals = [][]byte{e.originals[n-1]}
+	}
+	entry := &ReqQueueEntry{Message: msg}
+	return entry.uploadImages(ctx, 0, e.captions[n-1], [][]byte{e.imgs[n-1]}, originals, e.filenames[n-1], true)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGridCompose(t *testing.T) {
+	tests := []struct {
+		name          string
+		count         int
+		width, height int
+		sheetWidth    int
+		sheetHeight   int
+	}{
+		{name: "one image", count: 1, width: 64, height: 48, sheetWidth: 64, sheetHeight: 48},
+		{name: "full grid", count: 4, width: 64, height: 48, sheetWidth: 128, sheetHeight: 96},
+		{name: "partial last row", count: 5, width: 64, height: 48, sheetWidth: 192, sheetHeight: 96},
+		{name: "downscaled", count: 4, width: 2048, height: 1024, sheetWidth: gridMaxSize, sheetHeight: gridMaxSize / 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var imgs [][]byte
+			for i := 0; i < test.count; i++ {
+				imgs = append(imgs, testPNG(t, test.width, test.height))
+			}
+			sheet, err := gridCompose(imgs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(sheet))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != "jpeg" || cfg.Width != test.sheetWidth || cfg.Height != test.sheetHeight {
+				t.Errorf("got %s %dx%d, expected jpeg %dx%d", format, cfg.Width, cfg.Height, test.sheetWidth, test.sheetHeight)
+			}
+		})
+	}
+
+	if _, err := gridCompose(nil); err == nil {
+		t.Error("expected an error for no images")
+	}
+	if _, err := gridCompose([][]byte{[]byte("not an image")}); err == nil {
+		t.Error("expected an error for an invalid image")
+	}
+}
+
+func TestGridParsePick(t *testing.T) {
+	tests := []struct {
+		s  string
+		n  int
+		ok bool
+	}{
+		{"-pick 3", 3, true},
+		{" -PICK  12 ", 12, true},
+		{"-pick", 0, false},
+		{"-pick x", 0, false},
+		{"a cat -pick 1", 0, false},
+	}
+	for _, test := range tests {
+		if n, ok := gridParsePick(test.s); n != test.n || ok != test.ok {
+			t.Errorf("%q: got %d %v, expected %d %v", test.s, n, ok, test.n, test.ok)
+		}
+	}
+}
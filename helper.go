@@ -1,8 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"image"
+	"net/http"
 	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/go-telegram/bot/models"
+	_ "golang.org/x/image/webp" // For checking the size of WebP outputs.
 )
 
 func getProgressbar(progressPercent, progressBarLen int) (progressBar string) {
@@ -20,3 +28,92 @@ func getProgressbar(progressPercent, progressBarLen int) (progressBar string) {
 func fileNameWithoutExt(fileName string) string {
 	return fileName[:len(fileName)-len(filepath.Ext(fileName))]
 }
+
+// Telegram's limits for photos, bigger images can only be sent as documents.
+const (
+	telegramPhotoMaxSize         = 10 * 1024 * 1024
+	telegramPhotoMaxDimensionSum = 10000
+	telegramPhotoMaxAspectRatio  = 20
+
+	// Telegram media groups can contain at most this many items.
+	telegramMediaGroupMaxSize = 10
+)
+
+// Returns true if the image can be sent as a Telegram photo.
+func imageFitsPhotoLimits(img []byte) bool {
+	if len(img) > telegramPhotoMaxSize {
+		return false
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(img))
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return false
+	}
+	return cfg.Width+cfg.Height <= telegramPhotoMaxDimensionSum &&
+		max(cfg.Width, cfg.Height) <= telegramPhotoMaxAspectRatio*min(cfg.Width, cfg.Height)
+}
+
+// Returns the file extension matching the image data's format.
+func imageFileExt(img []byte) string {
+	switch {
+	case len(img) >= 12 && string(img[4:12]) == "ftypavif":
+		return "avif"
+	case http.DetectContentType(img) == "image/png":
+		return "png"
+	case http.DetectContentType(img) == "image/webp":
+		return "webp"
+	}
+	return "jpg"
+}
+
+// Splits a comma separated list, omitting empty items.
+func splitNonEmpty(s string) (res []string) {
+	for _, i := range strings.Split(s, ",") {
+		i = strings.TrimSpace(i)
+		if i != "" {
+			res = append(res, i)
+		}
+	}
+	return
+}
+
+// Returns the text of the first entity of the given type in the message. Entity offsets are in
+// UTF-16 code units.
+func messageEntityText(msg *models.Message, entityType models.MessageEntityType) string {
+	text := utf16.Encode([]rune(msg.Text))
+	for _, e := range msg.Entities {
+		if e.Type != entityType || e.Offset < 0 || e.Offset+e.Length > len(text) {
+			continue
+		}
+		return string(utf16.Decode(text[e.Offset : e.Offset+e.Length]))
+	}
+	return ""
+}
+
+// Returns the file size in a human readable format, for ex. "2.1 GB".
+func formatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// Returns the file ID and the filename of the image in the message, or an empty file ID if there's
+// no image in the message.
+func messageImageFile(msg *models.Message) (fileID, filename string) {
+	switch {
+	case msg == nil:
+		return "", ""
+	case msg.Document != nil:
+		return msg.Document.FileID, msg.Document.FileName
+	case len(msg.Photo) > 0:
+		return msg.Photo[len(msg.Photo)-1].FileID, "image.jpg"
+	default:
+		return "", ""
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"golang.org/x/exp/slices"
+)
+
+const inlineResultIDRenderPrefix = "render-"
+
+// The button shown above the results on errors opens the private chat with the bot using this
+// start parameter.
+const inlineErrorStartParameter = "inline-error"
+
+// Returns a message which can be used for parsing the render params of an inline query. Inline
+// queries have no chat, so the querying user's private chat is used for the chat settings.
+func inlineQueryMessage(from *models.User, query string) *models.Message {
+	return &models.Message{
+		From: from,
+		Chat: models.Chat{
+			ID:   from.ID,
+			Type: "private",
+		},
+		Text: query,
+	}
+}
+
+func answerInlineQuery(ctx context.Context, query *models.InlineQuery, results []models.InlineQueryResult, button *models.InlineQueryResultsButton) {
+	_, err := telegramBot.AnswerInlineQuery(ctx, &bot.AnswerInlineQueryParams{
+		InlineQueryID: query.ID,
+		Results:       results,
+		IsPersonal:    true,
+		Button:        button,
+	})
+	if err != nil {
+		logWithMsg(inlineQueryMessage(query.From, query.Query)).Error("inline query answer error", "error", err)
+		metrics.IncTelegramAPIError("answerInlineQuery")
+	}
+}
+
+// Answers the inline query with a render result which queues the render when chosen. Inline queries
+// don't contain the chat where the result will be sent, so the content policy and the limits of the
+// querying user's private chat are used, not the ones of the target chat.
+func handleInlineQuery(ctx context.Context, query *models.InlineQuery) {
+	if query.From == nil {
+		return
+	}
+	query.Query = strings.TrimSpace(query.Query)
+	msg := inlineQueryMessage(query.From, query.Query)
+	loc := localeFor(msg)
+	log := logWithMsg(msg)
+	log.Debug("got inline query", "query", query.Query)
+
+	if !slices.Contains(getParams().AllowedUserIDs, query.From.ID) {
+		log.Info("user not allowed, ignoring inline query")
+		return
+	}
+	if query.Query == "" {
+		return
+	}
+
+	// An inline message can only contain one image, so only one is rendered.
+	reqParams, _, err := cmdHandler.parseRenderParams(ctx, msg, true)
+	if err != nil {
+		// Inline queries are sent while the user is typing, so errors are only logged in debug level.
+		log.Debug("invalid inline query", "error", err)
+		errText := loc.Err(err)
+		var policyErr *contentPolicyViolationError
+		if errors.As(err, &policyErr) {
+			errText = loc.T(localeMsgContentPolicyViolation)
+		}
+		// The error is shown as a button above the empty results, so it can't be sent to the chat.
+		answerInlineQuery(ctx, query, []models.InlineQueryResult{}, &models.InlineQueryResultsButton{
+			Text:           loc.T(localeMsgError) + ": " + errText,
+			StartParameter: inlineErrorStartParameter,
+		})
+		return
+	}
+
+	// The seed is stored in the result ID, so the rendered image will have the same params as shown here.
+	answerInlineQuery(ctx, query, []models.InlineQueryResult{&models.InlineQueryResultArticle{
+		ID:          inlineResultIDRenderPrefix + fmt.Sprint(reqParams.Seed),
+		Title:       loc.T(localeMsgInlineRenderTitle) + ": " + reqParams.Prompt,
+		Description: reqParams.String(),
+		InputMessageContent: models.InputTextMessageContent{
+			MessageText: loc.T(localeMsgInlineQueued) + "\n" + reqParams.OrigPrompt(),
+		},
+		// Telegram only sends the inline message ID of the chosen result if the message has a keyboard.
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{{
+				{Text: loc.T(localeMsgInlineRenderAgain), SwitchInlineQueryCurrentChat: query.Query},
+			}},
+		},
+	}}, nil)
+}
+
+func handleChosenInlineResult(ctx context.Context, result *models.ChosenInlineResult) {
+	msg := inlineQueryMessage(&result.From, strings.TrimSpace(result.Query))
+	log := logWithMsg(msg)
+	log.Info("got chosen inline result", "result_id", result.ResultID, "query", msg.Text)
+
+	if !slices.Contains(getParams().AllowedUserIDs, result.From.ID) {
+		log.Info("user not allowed, ignoring chosen inline result")
+		return
+	}
+
+	reqParams, _, err := cmdHandler.parseRenderParams(ctx, msg, true)
+	if err != nil {
+		// Only render results can be chosen, so the params became invalid since the query, for
+		// example because of a config reload.
+		var policyErr *contentPolicyViolationError
+		if errors.As(err, &policyErr) {
+			cmdHandler.reportContentPolicyViolation(ctx, msg, policyErr.matched)
+		}
+		return
+	}
+
+	seedStr, ok := strings.CutPrefix(result.ResultID, inlineResultIDRenderPrefix)
+	if !ok {
+		return
+	}
+	if result.InlineMessageID == "" {
+		log.Error("chosen inline result has no inline message id")
+		return
+	}
+	seed, err := strconv.ParseUint(seedStr, 10, 32)
+	if err != nil {
+		log.Error("invalid chosen inline result id", "result_id", result.ResultID)
+		return
+	}
+	reqParams.Seed = uint32(seed)
+
+	reqQueue.Add(ReqQueueReq{
+		Type:            ReqTypeRender,
+		Message:         msg,
+		InlineMessageID: result.InlineMessageID,
+		Params:          reqParams,
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestHandleInlineQueryError(t *testing.T) {
+	testSetParams(t, func(p *paramsType) { p.AllowedUserIDs = []int64{1} })
+	testSetSDAPIMock(t, sdAPIMockParams{})
+	tg := testStartTelegram(t)
+
+	handleInlineQuery(context.Background(), &models.InlineQuery{
+		ID:    "q",
+		From:  &models.User{ID: 1},
+		Query: "a cat -w x",
+	})
+
+	calls := tg.callsOf("answerInlineQuery")
+	if len(calls) != 1 {
+		t.Fatalf("got %d answers", len(calls))
+	}
+	// The error can't be chosen, so it can't be sent to the chat.
+	if results := calls[0].Get("results"); results != "[]" {
+		t.Errorf("got results %s", results)
+	}
+	if button := calls[0].Get("button"); !strings.Contains(button, inlineErrorStartParameter) {
+		t.Errorf("got button %s", button)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Limit value for no limit. Group and admin overrides can lift an inherited limit with it.
+const limitUnlimited = -1
+
+// Render parameter limits. Zero values mean no limit, or in group and admin overrides that the
+// limit is inherited.
+type paramsLimitsType struct {
+	MaxSteps           int     `yaml:"max_steps"`
+	MaxNumOutputs      int     `yaml:"max_outcnt"`
+	MaxMegapixels      float64 `yaml:"max_megapixels"`
+	MaxBatchMegapixels float64 `yaml:"max_batch_megapixels"`
+	MaxHRScale         float32 `yaml:"max_hr_scale"`
+	MaxUpscale         float32 `yaml:"max_upscale"`
+	MaxAnimFrames      int     `yaml:"max_anim_frames"`
+
+	// Outpainting limits for the number of inpainting passes and the sum of the side extensions in pixels.
+	MaxOutpaintPasses    int `yaml:"max_outpaint_passes"`
+	MaxOutpaintExtension int `yaml:"max_outpaint_extension"`
+
+	// Big output counts are rendered in multiple batches, a batch contains max. this many images
+	// and megapixels to fit in the VRAM.
+	MaxRenderBatchSize       int     `yaml:"max_batch_size"`
+	MaxRenderBatchMegapixels float64 `yaml:"max_render_batch_megapixels"`
+
+	// If true, then violating params are clamped to the limits instead of rejecting the request.
+	Clamp *bool `yaml:"clamp"`
+}
+
+func (l *paramsLimitsType) validate() error {
+	if !limitValid(l.MaxSteps) || !limitValid(l.MaxNumOutputs) || !limitValid(l.MaxMegapixels) ||
+		!limitValid(l.MaxBatchMegapixels) || !limitValid(l.MaxHRScale) || !limitValid(l.MaxUpscale) ||
+		!limitValid(l.MaxAnimFrames) || !limitValid(l.MaxOutpaintPasses) || !limitValid(l.MaxOutpaintExtension) ||
+		!limitValid(l.MaxRenderBatchSize) || !limitValid(l.MaxRenderBatchMegapixels) {
+		return fmt.Errorf("invalid limits")
+	}
+	return nil
+}
+
+// Limits can be positive, or 0 or limitUnlimited for no limit.
+func limitValid[T int | float32 | float64](v T) bool {
+	return v >= 0 || v == limitUnlimited
+}
+
+// Returns the limits with the non-zero values of o applied, limitUnlimited removes the limit.
+func (l paramsLimitsType) merge(o paramsLimitsType) paramsLimitsType {
+	if o.MaxSteps != 0 {
+		l.MaxSteps = o.MaxSteps
+	}
+	if o.MaxNumOutputs != 0 {
+		l.MaxNumOutputs = o.MaxNumOutputs
+	}
+	if o.MaxMegapixels != 0 {
+		l.MaxMegapixels = o.MaxMegapixels
+	}
+	if o.MaxBatchMegapixels != 0 {
+		l.MaxBatchMegapixels = o.MaxBatchMegapixels
+	}
+	if o.MaxHRScale != 0 {
+		l.MaxHRScale = o.MaxHRScale
+	}
+	if o.MaxUpscale != 0 {
+		l.MaxUpscale = o.MaxUpscale
+	}
+	if o.MaxAnimFrames != 0 {
+		l.MaxAnimFrames = o.MaxAnimFrames
+	}
+	if o.MaxOutpaintPasses != 0 {
+		l.MaxOutpaintPasses = o.MaxOutpaintPasses
+	}
+	if o.MaxOutpaintExtension != 0 {
+		l.MaxOutpaintExtension = o.MaxOutpaintExtension
+	}
+	if o.MaxRenderBatchSize != 0 {
+		l.MaxRenderBatchSize = o.MaxRenderBatchSize
+	}
+	if o.MaxRenderBatchMegapixels != 0 {
+		l.MaxRenderBatchMegapixels = o.MaxRenderBatchMegapixels
+	}
+	if o.Clamp != nil {
+		l.Clamp = o.Clamp
+	}
+	return l
+}
+
+func (l *paramsLimitsType) clamp() bool {
+	return l.Clamp != nil && *l.Clamp
+}
+
+// Returns the megapixel count of one output image of the render.
+func (l *paramsLimitsType) getMegapixels(r *ReqParamsRender) float64 {
+	width, height := r.HR.size(r.Width, r.Height)
+	return float64(width*height) / 1000000
+}
+
+// Shrinks the image size to fit in the given megapixels while keeping the aspect ratio.
+// Returns a notice about the change.
+func (l *paramsLimitsType) shrink(r *ReqParamsRender, maxMegapixels float64) string {
+	ratio := math.Sqrt(maxMegapixels / l.getMegapixels(r))
+	width := int(float64(r.Width)*ratio) / aspectRatioSizeStep * aspectRatioSizeStep
+	height := int(float64(r.Height)*ratio) / aspectRatioSizeStep * aspectRatioSizeStep
+	if width < aspectRatioSizeStep {
+		width = aspectRatioSizeStep
+	}
+	if height < aspectRatioSizeStep {
+		height = aspectRatioSizeStep
+	}
+	notice := fmt.Sprintf("size %dx%d→%dx%d", r.Width, r.Height, width, height)
+	r.Width = width
+	r.Height = height
+	// The highres mode target size is shrunk too, otherwise the output size wouldn't change.
+	if r.HR.Width > 0 {
+		r.HR.Width = max(int(float64(r.HR.Width)*ratio)/aspectRatioSizeStep*aspectRatioSizeStep, width)
+	}
+	if r.HR.Height > 0 {
+		r.HR.Height = max(int(float64(r.HR.Height)*ratio)/aspectRatioSizeStep*aspectRatioSizeStep, height)
+	}
+	return notice
+}
+
+// Checks the render params against the limits. If clamping is enabled, then the params are modified
+// to fit in the limits and notices about the changes are returned.
+func (l *paramsLimitsType) applyRender(r *ReqParamsRender) (notices []string, err error) {
+	if r.Width <= 0 || r.Height <= 0 {
+		return nil, newLocaleError(localeMsgLimitInvalidSize)
+	}
+	if r.Steps <= 0 {
+		return nil, newLocaleError(localeMsgLimitInvalidSteps)
+	}
+	if r.NumOutputs <= 0 {
+		return nil, newLocaleError(localeMsgLimitInvalidOutputCount)
+	}
+
+	if l.MaxSteps > 0 && r.Steps > l.MaxSteps {
+		if !l.clamp() {
+			return nil, newLocaleError(localeMsgLimitSteps, l.MaxSteps)
+		}
+		notices = append(notices, fmt.Sprintf("steps %d→%d", r.Steps, l.MaxSteps))
+		r.Steps = l.MaxSteps
+	}
+	if l.MaxSteps > 0 && r.HR.enabled() && r.HR.SecondPassSteps > l.MaxSteps {
+		if !l.clamp() {
+			return nil, newLocaleError(localeMsgLimitHRSteps, l.MaxSteps)
+		}
+		notices = append(notices, fmt.Sprintf("hr steps %d→%d", r.HR.SecondPassSteps, l.MaxSteps))
+		r.HR.SecondPassSteps = l.MaxSteps
+	}
+
+	if l.MaxNumOutputs > 0 && r.NumOutputs > l.MaxNumOutputs {
+		if !l.clamp() {
+			return nil, newLocaleError(localeMsgLimitOutputCount, l.MaxNumOutputs)
+		}
+		notices = append(notices, fmt.Sprintf("output count %d→%d", r.NumOutputs, l.MaxNumOutputs))
+		r.NumOutputs = l.MaxNumOutputs
+	}
+
+	if hrScale := r.HR.scale(r.Width, r.Height); l.MaxHRScale > 0 && r.HR.enabled() && hrScale > l.MaxHRScale {
+		if !l.clamp() {
+			return nil, newLocaleError(localeMsgLimitHRScale, l.MaxHRScale)
+		}
+		if r.HR.Width > 0 || r.HR.Height > 0 {
+			hrWidth, hrHeight := r.HR.size(r.Width, r.Height)
+			ratio := l.MaxHRScale / hrScale
+			if r.HR.Width > 0 {
+				r.HR.Width = max(int(float32(r.HR.Width)*ratio)/aspectRatioSizeStep*aspectRatioSizeStep, r.Width)
+			}
+			if r.HR.Height > 0 {
+				r.HR.Height = max(int(float32(r.HR.Height)*ratio)/aspectRatioSizeStep*aspectRatioSizeStep, r.Height)
+			}
+			newWidth, newHeight := r.HR.size(r.Width, r.Height)
+			notices = append(notices, fmt.Sprintf("hr size %dx%d→%dx%d", hrWidth, hrHeight, newWidth, newHeight))
+		} else {
+			notices = append(notices, fmt.Sprintf("hr scale %v→%v", r.HR.Scale, l.MaxHRScale))
+			r.HR.Scale = l.MaxHRScale
+		}
+	}
+
+	if l.MaxUpscale > 0 && r.Upscale.Scale > l.MaxUpscale {
+		if !l.clamp() {
+			return nil, newLocaleError(localeMsgLimitUpscale, l.MaxUpscale)
+		}
+		notices = append(notices, fmt.Sprintf("upscale ratio %v→%v", r.Upscale.Scale, l.MaxUpscale))
+		r.Upscale.Scale = l.MaxUpscale
+	}
+
+	if notices, err = l.applyUpscaleSize(&r.Upscale, notices); err != nil {
+		return nil, err
+	}
+
+	if l.MaxMegapixels > 0 && l.getMegapixels(r) > l.MaxMegapixels {
+		if !l.clamp() {
+			return nil, newLocaleError(localeMsgLimitMegapixels, l.MaxMegapixels)
+		}
+		notices = append(notices, l.shrink(r, l.MaxMegapixels))
+	}
+
+	if l.MaxBatchMegapixels > 0 && l.getMegapixels(r)*float64(r.NumOutputs) > l.MaxBatchMegapixels {
+		if !l.clamp() {
+			return nil, newLocaleError(localeMsgLimitTotalMegapixels, l.MaxBatchMegapixels)
+		}
+		numOutputs := max(int(l.MaxBatchMegapixels/l.getMegapixels(r)), 1)
+		if numOutputs != r.NumOutputs {
+			notices = append(notices, fmt.Sprintf("output count %d→%d", r.NumOutputs, numOutputs))
+			r.NumOutputs = numOutputs
+		}
+		if l.getMegapixels(r) > l.MaxBatchMegapixels {
+			notices = append(notices, l.shrink(r, l.MaxBatchMegapixels))
+		}
+	}
+
+	// Big output counts are rendered in multiple batches, so the batch size only depends on what
+	// fits in the VRAM.
+	r.BatchSize = r.NumOutputs
+	if l.MaxRenderBatchSize > 0 {
+		r.BatchSize = min(r.BatchSize, l.MaxRenderBatchSize)
+	}
+	if l.MaxRenderBatchMegapixels > 0 {
+		if l.getMegapixels(r) > l.MaxRenderBatchMegapixels {
+			if !l.clamp() {
+				return nil, newLocaleError(localeMsgLimitMegapixels, l.MaxRenderBatchMegapixels)
+			}
+			notices = append(notices, l.shrink(r, l.MaxRenderBatchMegapixels))
+		}
+		r.BatchSize = max(min(r.BatchSize, int(l.MaxRenderBatchMegapixels/l.getMegapixels(r))), 1)
+	}
+	return
+}
+
+// Checks the upscale params against the limits. If clamping is enabled, then the params are modified
+// to fit in the limits and notices about the changes are returned.
+func (l *paramsLimitsType) applyUpscale(r *ReqParamsUpscale) (notices []string, err error) {
+	if l.MaxUpscale > 0 && r.Scale > l.MaxUpscale {
+		if !l.clamp() {
+			return nil, newLocaleError(localeMsgLimitUpscale, l.MaxUpscale)
+		}
+		notices = append(notices, fmt.Sprintf("upscale ratio %v→%v", r.Scale, l.MaxUpscale))
+		r.Scale = l.MaxUpscale
+	}
+	return l.applyUpscaleSize(r, notices)
+}
+
+// Checks the exact upscale size against the biggest image which can be produced by upscaling an
+// image of the max. megapixels with the max. upscale ratio.
+func (l *paramsLimitsType) applyUpscaleSize(r *ReqParamsUpscale, notices []string) ([]string, error) {
+	if l.MaxMegapixels <= 0 || l.MaxUpscale <= 0 || r.Width <= 0 || r.Height <= 0 {
+		return notices, nil
+	}
+	maxMegapixels := l.MaxMegapixels * float64(l.MaxUpscale*l.MaxUpscale)
+	mp := float64(r.Width*r.Height) / 1000000
+	if mp <= maxMegapixels {
+		return notices, nil
+	}
+	if !l.clamp() {
+		return nil, newLocaleError(localeMsgLimitUpscaleMegapixels, maxMegapixels)
+	}
+	ratio := math.Sqrt(maxMegapixels / mp)
+	width := max(int(float64(r.Width)*ratio), 1)
+	height := max(int(float64(r.Height)*ratio), 1)
+	notices = append(notices, fmt.Sprintf("upscale size %dx%d→%dx%d", r.Width, r.Height, width, height))
+	r.Width = width
+	r.Height = height
+	return notices, nil
+}
+
+// Checks the outpaint params against the limits. The size of the outpainted image can only be
+// checked with checkOutpaintSize when the image is available.
+func (l *paramsLimitsType) applyOutpaint(r *ReqParamsOutpaint) (notices []string, err error) {
+	if r.Steps <= 0 {
+		return nil, newLocaleError(localeMsgLimitInvalidSteps)
+	}
+	if l.MaxSteps > 0 && r.Steps > l.MaxSteps {
+		if !l.clamp() {
+			return nil, newLocaleError(localeMsgLimitSteps, l.MaxSteps)
+		}
+		notices = append(notices, fmt.Sprintf("steps %d→%d", r.Steps, l.MaxSteps))
+		r.Steps = l.MaxSteps
+	}
+
+	if extension := r.Left + r.Right + r.Top + r.Bottom; l.MaxOutpaintExtension > 0 && extension > l.MaxOutpaintExtension {
+		if !l.clamp() {
+			return nil, newLocaleError(localeMsgLimitOutpaintExtension, l.MaxOutpaintExtension)
+		}
+		// The sides are shrunk proportionally, keeping them multiples of 8.
+		ratio := float64(l.MaxOutpaintExtension) / float64(extension)
+		shrink := func(v int) int {
+			return int(float64(v)*ratio) / outpaintSizeStep * outpaintSizeStep
+		}
+		r.Left, r.Right, r.Top, r.Bottom = shrink(r.Left), shrink(r.Right), shrink(r.Top), shrink(r.Bottom)
+		notices = append(notices, fmt.Sprintf("extension %d→%d", extension, r.Left+r.Right+r.Top+r.Bottom))
+	}
+
+	// Also checked if the pass count is not set, as big extensions are split to many passes.
+	if passes := r.passCount(); l.MaxOutpaintPasses > 0 && passes > l.MaxOutpaintPasses {
+		if !l.clamp() {
+			return nil, newLocaleError(localeMsgLimitOutpaintPasses, l.MaxOutpaintPasses)
+		}
+		notices = append(notices, fmt.Sprintf("passes %d→%d", passes, l.MaxOutpaintPasses))
+		r.Passes = l.MaxOutpaintPasses
+	}
+	return
+}
+
+// Checks the size of the outpainted image. It's not clamped, as the extension is set explicitly.
+func (l *paramsLimitsType) checkOutpaintSize(width, height int) error {
+	if l.MaxMegapixels > 0 && float64(width*height)/1000000 > l.MaxMegapixels {
+		return newLocaleError(localeMsgLimitOutpaintMegapixels, l.MaxMegapixels)
+	}
+	return nil
+}
+
+// Checks the animation params against the limits. Frames are checked with the render limits.
+func (l *paramsLimitsType) applyAnim(r *ReqParamsAnim) (notices []string, err error) {
+	if l.MaxAnimFrames > 0 && r.Frames > l.MaxAnimFrames {
+		if !l.clamp() {
+			return nil, newLocaleError(localeMsgLimitFrames, l.MaxAnimFrames)
+		}
+		notices = append(notices, fmt.Sprintf("frame count %d→%d", r.Frames, l.MaxAnimFrames))
+		r.Frames = l.MaxAnimFrames
+	}
+	renderNotices, err := l.applyRender(&r.ReqParamsRender)
+	if err != nil {
+		return nil, err
+	}
+	return append(notices, renderNotices...), nil
+}
+
+// Returns the limits for the given user in the given chat. Group limits override the global limits,
+// admin limits override both.
+func (p *paramsType) LimitsFor(chatID, userID int64) paramsLimitsType {
+	l := p.Limits
+	if g, ok := p.Groups[chatID]; ok {
+		l = l.merge(g.Limits)
+	}
+	if p.IsAdmin(userID) {
+		l = l.merge(p.AdminLimits)
+	}
+	return l
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testLimits = paramsLimitsType{
+	MaxSteps:           150,
+	MaxNumOutputs:      10,
+	MaxMegapixels:      4.2,
+	MaxBatchMegapixels: 10.5,
+	MaxHRScale:         4,
+	MaxUpscale:         4,
+}
+
+func TestLimitsApplyRender(t *testing.T) {
+	clamp := true
+	clampingLimits := testLimits
+	clampingLimits.Clamp = &clamp
+
+	render := func(modify func(r *ReqParamsRender)) ReqParamsRender {
+		r := ReqParamsRender{Width: 512, Height: 512, Steps: 20, NumOutputs: 1}
+		if modify != nil {
+			modify(&r)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		limits   paramsLimitsType
+		params   ReqParamsRender
+		err      bool
+		notices  []string
+		expected ReqParamsRender
+	}{
+		{
+			name:     "within the limits",
+			limits:   testLimits,
+			params:   render(func(r *ReqParamsRender) { r.NumOutputs = 4 }),
+			expected: render(func(r *ReqParamsRender) { r.NumOutputs = 4; r.BatchSize = 4 }),
+		},
+		{
+			name:     "no limits",
+			params:   render(func(r *ReqParamsRender) { r.Steps = 1000; r.NumOutputs = 100 }),
+			expected: render(func(r *ReqParamsRender) { r.Steps = 1000; r.NumOutputs = 100; r.BatchSize = 100 }),
+		},
+		{
+			name:   "invalid size",
+			limits: testLimits,
+			params: render(func(r *ReqParamsRender) { r.Width = 0 }),
+			err:    true,
+		},
+		{
+			name:   "too many steps",
+			limits: testLimits,
+			params: render(func(r *ReqParamsRender) { r.Steps = 151 }),
+			err:    true,
+		},
+		{
+			name:     "steps clamped",
+			limits:   clampingLimits,
+			params:   render(func(r *ReqParamsRender) { r.Steps = 200 }),
+			notices:  []string{"steps 200→150"},
+			expected: render(func(r *ReqParamsRender) { r.Steps = 150; r.BatchSize = 1 }),
+		},
+		{
+			name:   "too many hr steps",
+			limits: testLimits,
+			params: render(func(r *ReqParamsRender) { r.HR = ReqParamsRenderHR{Scale: 2, SecondPassSteps: 151} }),
+			err:    true,
+		},
+		{
+			name:    "hr steps clamped",
+			limits:  clampingLimits,
+			params:  render(func(r *ReqParamsRender) { r.HR = ReqParamsRenderHR{Scale: 2, SecondPassSteps: 200} }),
+			notices: []string{"hr steps 200→150"},
+			expected: render(func(r *ReqParamsRender) {
+				r.HR = ReqParamsRenderHR{Scale: 2, SecondPassSteps: 150}
+				r.BatchSize = 1
+			}),
+		},
+		{
+			name:   "hr scale over the limit",
+			limits: testLimits,
+			params: render(func(r *ReqParamsRender) { r.HR.Scale = 5 }),
+			err:    true,
+		},
+		{
+			name:     "hr size clamped",
+			limits:   clampingLimits,
+			params:   render(func(r *ReqParamsRender) { r.HR.Width = 4096 }),
+			notices:  []string{"hr size 4096x4096→2048x2048"},
+			expected: render(func(r *ReqParamsRender) { r.HR.Width = 2048; r.BatchSize = 1 }),
+		},
+		{
+			name:   "image too big",
+			limits: testLimits,
+			params: render(func(r *ReqParamsRender) { r.Width = 4096; r.Height = 4096 }),
+			err:    true,
+		},
+		{
+			name:     "image shrunk",
+			limits:   clampingLimits,
+			params:   render(func(r *ReqParamsRender) { r.Width = 4096; r.Height = 4096 }),
+			notices:  []string{"size 4096x4096→2048x2048"},
+			expected: render(func(r *ReqParamsRender) { r.Width = 2048; r.Height = 2048; r.BatchSize = 1 }),
+		},
+		{
+			name:   "total megapixels over the limit",
+			limits: testLimits,
+			params: render(func(r *ReqParamsRender) { r.Width = 1280; r.Height = 1024; r.NumOutputs = 10 }),
+			err:    true,
+		},
+		{
+			name:     "output count clamped to the total megapixels",
+			limits:   clampingLimits,
+			params:   render(func(r *ReqParamsRender) { r.Width = 1280; r.Height = 1024; r.NumOutputs = 10 }),
+			notices:  []string{"output count 10→8"},
+			expected: render(func(r *ReqParamsRender) { r.Width = 1280; r.Height = 1024; r.NumOutputs = 8; r.BatchSize = 8 }),
+		},
+		{
+			name: "split to render batches",
+			limits: paramsLimitsType{
+				MaxNumOutputs:            40,
+				MaxBatchMegapixels:       40,
+				MaxRenderBatchSize:       10,
+				MaxRenderBatchMegapixels: 5,
+			},
+			params:   render(func(r *ReqParamsRender) { r.Width = 1024; r.Height = 1024; r.NumOutputs = 16 }),
+			expected: render(func(r *ReqParamsRender) { r.Width = 1024; r.Height = 1024; r.NumOutputs = 16; r.BatchSize = 4 }),
+		},
+		{
+			name:     "lifted limit",
+			limits:   testLimits.merge(paramsLimitsType{MaxSteps: limitUnlimited}),
+			params:   render(func(r *ReqParamsRender) { r.Steps = 1000 }),
+			expected: render(func(r *ReqParamsRender) { r.Steps = 1000; r.BatchSize = 1 }),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := test.params
+			notices, err := test.limits.applyRender(&r)
+			if test.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(notices, test.notices) {
+				t.Errorf("got notices %q, expected %q", notices, test.notices)
+			}
+			if !reflect.DeepEqual(r, test.expected) {
+				t.Errorf("got %+v, expected %+v", r, test.expected)
+			}
+		})
+	}
+}
+
+func TestLimitsMerge(t *testing.T) {
+	base := paramsLimitsType{MaxSteps: 100, MaxNumOutputs: 4, MaxHRScale: 2}
+	res := base.merge(paramsLimitsType{MaxSteps: 200, MaxNumOutputs: limitUnlimited})
+	expected := paramsLimitsType{MaxSteps: 200, MaxNumOutputs: limitUnlimited, MaxHRScale: 2}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("got %+v, expected %+v", res, expected)
+	}
+
+	if err := (&paramsLimitsType{MaxSteps: limitUnlimited}).validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (&paramsLimitsType{MaxUpscale: -2}).validate(); err == nil {
+		t.Error("expected an error for a negative limit")
+	}
+}
+
+func TestLimitsApplyOutpaint(t *testing.T) {
+	clamp := true
+	limits := paramsLimitsType{MaxOutpaintPasses: 4, MaxOutpaintExtension: 1024}
+	clampingLimits := limits
+	clampingLimits.Clamp = &clamp
+
+	outpaint := func(modify func(r *ReqParamsOutpaint)) ReqParamsOutpaint {
+		r := ReqParamsOutpaint{ReqParamsRender: ReqParamsRender{Steps: 20}, Left: 256}
+		if modify != nil {
+			modify(&r)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		limits   paramsLimitsType
+		params   ReqParamsOutpaint
+		err      bool
+		notices  []string
+		expected ReqParamsOutpaint
+	}{
+		{
+			name:     "within the limits",
+			limits:   limits,
+			params:   outpaint(nil),
+			expected: outpaint(nil),
+		},
+		{
+			name:   "too big extension",
+			limits: limits,
+			params: outpaint(func(r *ReqParamsOutpaint) { r.Left = 100000 }),
+			err:    true,
+		},
+		{
+			name:   "too many passes",
+			limits: limits,
+			params: outpaint(func(r *ReqParamsOutpaint) { r.Passes = 10 }),
+			err:    true,
+		},
+		{
+			name:   "too many passes for the extension",
+			limits: paramsLimitsType{MaxOutpaintPasses: 2},
+			params: outpaint(func(r *ReqParamsOutpaint) { r.Left = 1024 }),
+			err:    true,
+		},
+		{
+			name:     "clamped",
+			limits:   clampingLimits,
+			params:   outpaint(func(r *ReqParamsOutpaint) { r.Left = 100000; r.Right = 100000; r.Passes = 10 }),
+			notices:  []string{"extension 200000→1024", "passes 10→4"},
+			expected: outpaint(func(r *ReqParamsOutpaint) { r.Left = 512; r.Right = 512; r.Passes = 4 }),
+		},
+		{
+			name:     "clamped derived pass count",
+			limits:   paramsLimitsType{MaxOutpaintPasses: 2, Clamp: &clamp},
+			params:   outpaint(func(r *ReqParamsOutpaint) { r.Left = 1024 }),
+			notices:  []string{"passes 4→2"},
+			expected: outpaint(func(r *ReqParamsOutpaint) { r.Left = 1024; r.Passes = 2 }),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := test.params
+			notices, err := test.limits.applyOutpaint(&r)
+			if test.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(notices, test.notices) {
+				t.Errorf("got notices %q, expected %q", notices, test.notices)
+			}
+			if !reflect.DeepEqual(r, test.expected) {
+				t.Errorf("got %+v, expected %+v", r, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"golang.org/x/exp/slices"
+)
+
+const listingPageSize = 25
+
+// Long lines are cut, so a page always fits in a Telegram message.
+const listingMaxLineLength = 150
+
+// The callback data of the page buttons is "sdlist:<listing id>:<page>:<filter>", or
+// "sdlist:<listing id>:<page>/<filter key>:" if the filter is too long for the callback data.
+const listingCallbackPrefix = "sdlist:"
+
+// Telegram's limit for the callback data of buttons, in bytes.
+const listingMaxCallbackDataLength = 64
+
+// Filters which don't fit in the callback data are kept in memory for max. this many listings,
+// for max. listingFilterCacheTTL.
+const listingFilterCacheSize = 100
+const listingFilterCacheTTL = 24 * time.Hour
+
+type listingItem struct {
+	name    string
+	details []string // Extra columns, for ex. the model hash and file size.
+}
+
+// Returns true if the name or the details contain the given lowercase string.
+func (i listingItem) matches(filter string) bool {
+	if strings.Contains(strings.ToLower(i.name), filter) {
+		return true
+	}
+	for _, d := range i.details {
+		if strings.Contains(strings.ToLower(d), filter) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i listingItem) String() string {
+	res := "• " + i.name
+	if len(i.details) > 0 {
+		res += " - " + strings.Join(i.details, " · ")
+	}
+	if utf8.RuneCountInString(res) > listingMaxLineLength {
+		res = string([]rune(res)[:listingMaxLineLength-1]) + "…"
+	}
+	return res
+}
+
+// A resource listing used by the /sdmodels, /sdsamplers etc. commands.
+type listingType struct {
+	id         string // Used in the callback data of the page buttons.
+	titleMsg   localeMsgID
+	noItemsMsg localeMsgID
+	fetch      func(ctx context.Context, loc *localeType) ([]listingItem, error)
+	// Returns the default item of the chat which is shown under the list. Optional.
+	defaultItem func(chatID int64) string
+}
+
+// Returns the size of the given file, or an empty string if the file is not available. The file
+// paths are reported by the WebUI, so sizes are only shown if the bot runs on the same host.
+func listingFileSize(fn string) string {
+	if fn == "" {
+		return ""
+	}
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return ""
+	}
+	return formatFileSize(fi.Size())
+}
+
+// Converts a list of names to listing items without details.
+func listingNames(names []string, err error) (items []listingItem, _ error) {
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range names {
+		items = append(items, listingItem{name: n})
+	}
+	return items, nil
+}
+
+var listingModels = &listingType{
+	id:         "models",
+	titleMsg:   localeMsgModels,
+	noItemsMsg: localeMsgNoModels,
+	fetch: func(ctx context.Context, loc *localeType) (items []listingItem, err error) {
+		infos, err := sdAPI.GetModelInfos(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range infos {
+			item := listingItem{name: m.Name}
+			if m.Hash != "" {
+				item.details = append(item.details, "#"+m.Hash)
+			}
+			if size := listingFileSize(m.Filename); size != "" {
+				item.details = append(item.details, size)
+			}
+			items = append(items, item)
+		}
+		return
+	},
+	defaultItem: func(chatID int64) string { return getParams().ChatDefaults(chatID).DefaultModel },
+}
+
+var listingSamplers = &listingType{
+	id:         "samplers",
+	titleMsg:   localeMsgSamplers,
+	noItemsMsg: localeMsgNoSamplers,
+	fetch: func(ctx context.Context, loc *localeType) ([]listingItem, error) {
+		return listingNames(sdAPI.GetSamplers(ctx))
+	},
+	defaultItem: func(chatID int64) string { return getParams().ChatDefaults(chatID).DefaultSampler },
+}
+
+var listingEmbeddings = &listingType{
+	id:         "embeddings",
+	titleMsg:   localeMsgEmbeddings,
+	noItemsMsg: localeMsgNoEmbeddings,
+	fetch: func(ctx context.Context, loc *localeType) ([]listingItem, error) {
+		return listingNames(sdAPI.GetEmbeddings(ctx))
+	},
+}
+
+var listingLoRAs = &listingType{
+	id:         "loras",
+	titleMsg:   localeMsgLoRAs,
+	noItemsMsg: localeMsgNoLoRAs,
+	fetch: func(ctx context.Context, loc *localeType) (items []listingItem, err error) {
+		loras, err := sdAPI.GetLoRAs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range loras {
+			item := listingItem{name: l.Name}
+			if l.Alias != "" {
+				item.details = append(item.details, "🏷"+l.Alias)
+			}
+			if len(l.TriggerWords) > 0 {
+				item.details = append(item.details, loc.T(localeMsgLoRATriggerWords, strings.Join(l.TriggerWords, ", ")))
+			}
+			if size := listingFileSize(l.Path); size != "" {
+				item.details = append(item.details, size)
+			}
+			items = append(items, item)
+		}
+		return
+	},
+}
+
+var listingUpscalers = &listingType{
+	id:         "upscalers",
+	titleMsg:   localeMsgUpscalers,
+	noItemsMsg: localeMsgNoUpscalers,
+	fetch: func(ctx context.Context, loc *localeType) ([]listingItem, error) {
+		return listingNames(sdAPI.GetUpscalers(ctx))
+	},
+}
+
+var listingVAEs = &listingType{
+	id:         "vaes",
+	titleMsg:   localeMsgVAEs,
+	noItemsMsg: localeMsgNoVAEs,
+	fetch: func(ctx context.Context, loc *localeType) ([]listingItem, error) {
+		return listingNames(sdAPI.GetVAEs(ctx))
+	},
+}
+
+var listingTypes = map[string]*listingType{
+	listingModels.id:     listingModels,
+	listingSamplers.id:   listingSamplers,
+	listingEmbeddings.id: listingEmbeddings,
+	listingLoRAs.id:      listingLoRAs,
+	listingUpscalers.id:  listingUpscalers,
+	listingVAEs.id:       listingVAEs,
+}
+
+type listingFilterCacheEntry struct {
+	key     uint64
+	filter  string
+	addedAt time.Time
+}
+
+// Filters of the recently sent listings which are too long for the callback data of the page buttons.
+type listingFilterCacheType struct {
+	mutex   sync.Mutex
+	lastKey uint64
+	entries []*listingFilterCacheEntry
+}
+
+var listingFilterCache listingFilterCacheType
+
+// Stores the filter and returns its key. An already stored filter keeps its key, so all page
+// buttons of a listing use the same entry.
+func (c *listingFilterCacheType) add(filter string) uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = slices.DeleteFunc(c.entries, func(e *listingFilterCacheEntry) bool { return time.Since(e.addedAt) > listingFilterCacheTTL })
+	if i := slices.IndexFunc(c.entries, func(e *listingFilterCacheEntry) bool { return e.filter == filter }); i >= 0 {
+		c.entries[i].addedAt = time.Now()
+		return c.entries[i].key
+	}
+	if len(c.entries) >= listingFilterCacheSize {
+		c.entries = c.entries[len(c.entries)-listingFilterCacheSize+1:]
+	}
+	c.lastKey++
+	c.entries = append(c.entries, &listingFilterCacheEntry{key: c.lastKey, filter: filter, addedAt: time.Now()})
+	return c.lastKey
+}
+
+func (c *listingFilterCacheType) get(key uint64) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	i := slices.IndexFunc(c.entries, func(e *listingFilterCacheEntry) bool { return e.key == key })
+	if i < 0 || time.Since(c.entries[i].addedAt) > listingFilterCacheTTL {
+		return "", false
+	}
+	return c.entries[i].filter, true
+}
+
+// Returns the callback data of a page button. If the data would be too long for Telegram, then
+// the filter is stored in listingFilterCache and only its key is sent.
+func (l *listingType) callbackData(page int, filter string) string {
+	res := listingCallbackPrefix + l.id + ":" + strconv.Itoa(page) + ":" + filter
+	if len(res) <= listingMaxCallbackDataLength {
+		return res
+	}
+	return listingCallbackPrefix + l.id + ":" + strconv.Itoa(page) + "/" + strconv.FormatUint(listingFilterCache.add(filter), 10) + ":"
+}
+
+// Returns the text and the page buttons of the given page of the listing. Items are sorted by name
+// and only the ones matching the filter are shown.
+func (l *listingType) render(ctx context.Context, loc *localeType, chatID int64, filter string, page int) (text string, markup models.ReplyMarkup, err error) {
+	allItems, err := l.fetch(ctx, loc)
+	if err != nil {
+		return "", nil, err
+	}
+
+	filter = strings.ToLower(strings.TrimSpace(filter))
+	var items []listingItem
+	for _, i := range allItems {
+		if filter == "" || i.matches(filter) {
+			items = append(items, i)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return strings.ToLower(items[i].name) < strings.ToLower(items[j].name)
+	})
+
+	var lines []string
+	if len(items) == 0 {
+		lines = append(lines, loc.T(l.noItemsMsg))
+		if filter != "" {
+			lines = append(lines, loc.T(localeMsgListFilter, filter))
+		}
+		return strings.Join(lines, "\n"), nil, nil
+	}
+
+	pageCount := (len(items) + listingPageSize - 1) / listingPageSize
+	page = min(max(page, 0), pageCount-1)
+
+	lines = append(lines, loc.T(l.titleMsg, len(items)))
+	if filter != "" {
+		lines = append(lines, loc.T(localeMsgListFilter, filter))
+	}
+	for _, i := range items[page*listingPageSize : min((page+1)*listingPageSize, len(items))] {
+		lines = append(lines, i.String())
+	}
+	if l.defaultItem != nil {
+		if d := l.defaultItem(chatID); d != "" {
+			lines = append(lines, loc.T(localeMsgListDefault, d))
+		}
+	}
+
+	if pageCount > 1 {
+		lines = append(lines, loc.T(localeMsgListPage, page+1, pageCount))
+
+		var buttons []models.InlineKeyboardButton
+		if page > 0 {
+			buttons = append(buttons, models.InlineKeyboardButton{Text: "◀", CallbackData: l.callbackData(page-1, filter)})
+		}
+		if page < pageCount-1 {
+			buttons = append(buttons, models.InlineKeyboardButton{Text: "▶", CallbackData: l.callbackData(page+1, filter)})
+		}
+		markup = models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{buttons}}
+	}
+	return strings.Join(lines, "\n"), markup, nil
+}
+
+// Replies with the first page of the listing, showing only the items matching the filter.
+func (c *cmdHandlerType) sendListing(ctx context.Context, msg *models.Message, l *listingType, filter string) {
+	text, markup, err := l.render(ctx, localeFor(msg), msg.Chat.ID, filter, 0)
+	if err != nil {
+		logWithMsg(msg).Error("error getting "+l.id, "error", err)
+		c.sendError(ctx, msg, localeFor(msg).T(localeMsgErrGetting, l.id, err))
+		return
+	}
+	sendReplyToMessageWithMarkup(ctx, msg, text, markup)
+}
+
+// Handles the page buttons of listings by editing the listing message to show the requested page.
+func (c *cmdHandlerType) ListingPage(ctx context.Context, listingMsg *models.Message, from models.User, data string) error {
+	id, rest, _ := strings.Cut(data, ":")
+	pageStr, filter, _ := strings.Cut(rest, ":")
+	pageStr, filterKeyStr, gotFilterKey := strings.Cut(pageStr, "/")
+	l, ok := listingTypes[id]
+	page, err := strconv.Atoi(pageStr)
+	if !ok || err != nil {
+		return fmt.Errorf("invalid listing callback data")
+	}
+	if gotFilterKey {
+		filterKey, err := strconv.ParseUint(filterKeyStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid listing callback data")
+		}
+		if filter, ok = listingFilterCache.get(filterKey); !ok {
+			return newLocaleError(localeMsgListExpired)
+		}
+	}
+
+	msg := &models.Message{
+		ID:   listingMsg.ID,
+		From: &from,
+		Chat: listingMsg.Chat,
+	}
+	if !messageAllowed(msg) {
+		return newLocaleError(localeMsgNotAllowed)
+	}
+
+	text, markup, err := l.render(ctx, localeFor(msg), msg.Chat.ID, filter, page)
+	if err != nil {
+		logWithMsg(msg).Error("error getting "+l.id, "error", err)
+		return newLocaleError(localeMsgErrGetting, l.id, err)
+	}
+	if text == listingMsg.Text {
+		return nil
+	}
+	_, err = telegramBot.EditMessageText(ctx, &bot.EditMessageTextParams{
+		MessageID:   listingMsg.ID,
+		ChatID:      listingMsg.Chat.ID,
+		Text:        text,
+		ReplyMarkup: markup,
+	})
+	if err != nil {
+		logWithMsg(msg).Error("listing edit error", "error", err)
+		metrics.IncTelegramAPIError("editMessageText")
+		return fmt.Errorf("listing edit error: %w", err)
+	}
+	return nil
+}
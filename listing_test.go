@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func TestListingPageLongFilter(t *testing.T) {
+	testSetParams(t, func(p *paramsType) { p.AllowedUserIDs = []int64{1} })
+	testSetSDAPIMock(t, sdAPIMockParams{})
+	tg := testStartTelegram(t)
+
+	filter := "mock-style-lora-v2" + strings.Repeat(" placeholder art", 5)
+	data := listingLoRAs.callbackData(1, filter)
+	if len(data) > listingMaxCallbackDataLength {
+		t.Fatalf("callback data is %d bytes long", len(data))
+	}
+
+	// The page gets rendered with the whole filter, not a truncated one.
+	from := models.User{ID: 1}
+	listingMsg := &models.Message{ID: 10, Chat: models.Chat{ID: 1}, Text: "old"}
+	err := cmdHandler.ListingPage(context.Background(), listingMsg, from, strings.TrimPrefix(data, listingCallbackPrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tg.gotText(localeEN.T(localeMsgListFilter, filter)) {
+		t.Error("the listing is not rendered with the whole filter")
+	}
+
+	err = cmdHandler.ListingPage(context.Background(), listingMsg, from, listingLoRAs.id+":1/12345:")
+	if err == nil {
+		t.Error("expected an error for an unknown filter key")
+	}
+}
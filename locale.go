@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-telegram/bot/models"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+type localeMsgID int
+
+const (
+	localeMsgImageReq localeMsgID = iota
+	localeMsgProcessStart
+	localeMsgProcess
+	localeMsgETA
+	localeMsgDownloading
+	localeMsgDownloadDone
+	localeMsgUploading
+	localeMsgError
+	localeMsgCanceled
+	localeMsgRestart
+	localeMsgRestartFailed
+	localeMsgLimitsClamped
+	localeMsgQueuePosition
+	localeMsgCantGetFile
+	localeMsgContentPolicyViolation
+	localeMsgInvalidCommand
+	localeMsgWelcome
+	localeMsgHelp
+	localeMsgModels
+	localeMsgNoModels
+	localeMsgSamplers
+	localeMsgNoSamplers
+	localeMsgEmbeddings
+	localeMsgNoEmbeddings
+	localeMsgLoRAs
+	localeMsgNoLoRAs
+	localeMsgUpscalers
+	localeMsgNoUpscalers
+	localeMsgVAEs
+	localeMsgNoVAEs
+	localeMsgInlineRenderTitle
+	localeMsgInlineQueued
+	localeMsgInlineRenderAgain
+	localeMsgLangCurrent
+	localeMsgLangSet
+	localeMsgLangGroupSet
+	localeMsgLangReset
+	localeMsgLangGroupReset
+	localeMsgLangUnknown
+	localeMsgLangNotGroup
+	localeMsgLangGroupAdminOnly
+	localeMsgCancelButton
+	localeMsgNoActiveRequest
+	localeMsgRequestNotFound
+	localeMsgCancelNotAllowed
+	localeMsgQueueEmpty
+	localeMsgQueueHeader
+	localeMsgQueueRunning
+	localeMsgQueueWaiting
+	localeMsgInterrogateResult
+	localeMsgInterrogateRender
+	localeMsgInterrogateNoPrompt
+	localeMsgInterrogateInvalidModel
+	localeMsgNotAllowed
+	localeMsgLoadingModel
+	localeMsgModelLoaded
+	localeMsgModelInvalid
+	localeMsgAdminOnly
+	localeMsgLoRATriggerWords
+	localeMsgListFilter
+	localeMsgListDefault
+	localeMsgListPage
+	localeMsgListExpired
+	localeMsgGridExpired
+	localeMsgGridInvalidPick
+	localeMsgFormatCurrent
+	localeMsgFormatSet
+	localeMsgFormatReset
+
+	// Error messages.
+	localeMsgCantParseParams
+	localeMsgMissingPrompt
+	localeMsgParamsAfterPrompt
+	localeMsgParamMissingValue
+	localeMsgParamInvalid
+	localeMsgParamInvalidRange
+	localeMsgParamInvalidChoice
+	localeMsgParamUnknownValue
+	localeMsgParamMax
+	localeMsgParamNotOutput
+	localeMsgParamAspectRatioWithSize
+	localeMsgParamHRSizeTooSmall
+	localeMsgParamSettingModel
+	localeMsgParamSettingNotAllowed
+	localeMsgParamSettingFormat
+	localeMsgAspectRatioInvalid
+	localeMsgAspectRatioOutOfRange
+	localeMsgOutputNeedsFFmpeg
+	localeMsgChromaNeedsFFmpeg
+	localeMsgLimitInvalidSize
+	localeMsgLimitInvalidSteps
+	localeMsgLimitInvalidOutputCount
+	localeMsgLimitSteps
+	localeMsgLimitHRSteps
+	localeMsgLimitOutputCount
+	localeMsgLimitHRScale
+	localeMsgLimitUpscale
+	localeMsgLimitMegapixels
+	localeMsgLimitTotalMegapixels
+	localeMsgLimitUpscaleMegapixels
+	localeMsgLimitOutpaintMegapixels
+	localeMsgLimitOutpaintExtension
+	localeMsgLimitOutpaintPasses
+	localeMsgLimitFrames
+	localeMsgOutpaintMissingExtension
+	localeMsgOutpaintImageTooSmall
+	localeMsgErrGetting
+	localeMsgModelLoadError
+	localeMsgModelRefreshError
+	localeMsgSMIError
+	localeMsgFormatOnlyOutputParams
+)
+
+type localeType struct {
+	code string
+	name string // The name of the language in the language itself.
+	msgs map[localeMsgID]string
+}
+
+const localeDefaultCode = "en"
+
+var locales = map[string]*localeType{
+	localeEN.code: &localeEN,
+	localeHU.code: &localeHU,
+}
+
+// Returns the translated message. If args are given, then the message is used as a format string.
+// Messages missing from the locale are returned in English.
+func (l *localeType) T(id localeMsgID, args ...any) string {
+	s, ok := l.msgs[id]
+	if !ok {
+		s = localeEN.msgs[id]
+	}
+	if len(args) == 0 {
+		return s
+	}
+	return fmt.Sprintf(s, args...)
+}
+
+// Returns the translated error message if err is a localeError, otherwise the error's message.
+// Errors in the args of a localeError are also translated.
+func (l *localeType) Err(err error) string {
+	var le *localeError
+	if !errors.As(err, &le) {
+		return err.Error()
+	}
+	args := make([]any, len(le.args))
+	for i, a := range le.args {
+		if e, ok := a.(error); ok {
+			a = l.Err(e)
+		}
+		args[i] = a
+	}
+	return l.T(le.id, args...)
+}
+
+// An error with a message which can be translated with localeType.Err. An error can be given as
+// an arg for wrapping it.
+type localeError struct {
+	id   localeMsgID
+	args []any
+}
+
+func newLocaleError(id localeMsgID, args ...any) error {
+	return &localeError{id: id, args: args}
+}
+
+func (e *localeError) Error() string {
+	return localeEN.Err(e)
+}
+
+// Returns the first error in the args.
+func (e *localeError) Unwrap() error {
+	for _, a := range e.args {
+		if err, ok := a.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the locale for the given language code (for ex. "en" or "pt-br"), or nil if it's not available.
+func localeFind(code string) *localeType {
+	code = strings.ToLower(code)
+	if l, ok := locales[code]; ok {
+		return l
+	}
+	if lang, _, found := strings.Cut(code, "-"); found {
+		return locales[lang]
+	}
+	return nil
+}
+
+// Returns the available language codes with their names, sorted by the code.
+func localeList() string {
+	codes := maps.Keys(locales)
+	slices.Sort(codes)
+	var res []string
+	for _, c := range codes {
+		res = append(res, c+" ("+locales[c].name+")")
+	}
+	return strings.Join(res, ", ")
+}
+
+// Selects the locale in the following order: the user's override, the group's override, the
+// group's language set in the config file, the Telegram user's language, the default language.
+func localeGet(chatID, userID int64, languageCode string) *localeType {
+	userCode, groupCode := localeOverrides.get(chatID, userID)
+	candidates := []string{userCode, groupCode}
+	if g, ok := getParams().Groups[chatID]; ok {
+		candidates = append(candidates, g.Language)
+	}
+	candidates = append(candidates, languageCode, getParams().DefaultLanguage)
+	for _, c := range candidates {
+		if c == "" {
+			continue
+		}
+		if l := localeFind(c); l != nil {
+			return l
+		}
+	}
+	return locales[localeDefaultCode]
+}
+
+// Returns the locale which should be used for replying to the given message.
+func localeFor(msg *models.Message) *localeType {
+	if msg == nil {
+		return localeGet(0, 0, "")
+	}
+	if msg.From == nil {
+		return localeGet(msg.Chat.ID, 0, "")
+	}
+	return localeGet(msg.Chat.ID, msg.From.ID, msg.From.LanguageCode)
+}
+
+// Language overrides set by users with the /sdlang command. They are saved to a JSON file if
+// a filename is set.
+type localeOverridesType struct {
+	mutex sync.Mutex
+	fn    string
+
+	Users  map[int64]string `json:"users"`
+	Groups map[int64]string `json:"groups"`
+}
+
+var localeOverrides localeOverridesType
+
+// Loads the overrides from the given file. A missing file is not an error.
+func (o *localeOverridesType) Load(fn string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.fn = fn
+	o.Users = make(map[int64]string)
+	o.Groups = make(map[int64]string)
+	if fn == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("can't read language overrides file: %w", err)
+	}
+	if err = json.Unmarshal(data, o); err != nil {
+		return fmt.Errorf("can't parse language overrides file: %w", err)
+	}
+	return nil
+}
+
+func (o *localeOverridesType) save() error {
+	if o.fn == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(o.fn, data, 0644); err != nil {
+		return fmt.Errorf("can't write language overrides file: %w", err)
+	}
+	return nil
+}
+
+func (o *localeOverridesType) get(chatID, userID int64) (userCode, groupCode string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.Users[userID], o.Groups[chatID]
+}
+
+// Sets the language override of a user. An empty code removes the override.
+func (o *localeOverridesType) SetUser(userID int64, code string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.set(o.Users, userID, code)
+	return o.save()
+}
+
+// Sets the language override of a group. An empty code removes the override.
+func (o *localeOverridesType) SetGroup(chatID int64, code string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.set(o.Groups, chatID, code)
+	return o.save()
+}
+
+func (o *localeOverridesType) set(m map[int64]string, id int64, code string) {
+	if code == "" {
+		delete(m, id)
+	} else {
+		m[id] = code
+	}
+}
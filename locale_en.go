@@ -0,0 +1,207 @@
+package main
+
+var localeEN = localeType{
+	code: "en",
+	name: "English",
+	msgs: map[localeMsgID]string{
+		localeMsgImageReq:               "🩻 Please post the image file to process.",
+		localeMsgProcessStart:           "🛎 Starting render...",
+		localeMsgProcess:                "🔨 Processing",
+		localeMsgETA:                    "ETA",
+		localeMsgDownloading:            "⬇ Downloading...",
+		localeMsgDownloadDone:           "✅ Done downloading",
+		localeMsgUploading:              "☁ ️ Uploading...",
+		localeMsgError:                  "❌ Error",
+		localeMsgCanceled:               "❌ Canceled",
+		localeMsgRestart:                "⚠️ Stable Diffusion is not running, starting, please wait...",
+		localeMsgRestartFailed:          "☠️ Stable Diffusion start failed, please restart the bot",
+		localeMsgLimitsClamped:          "⚠️ Params changed to fit the limits",
+		localeMsgQueuePosition:          "👨‍👦‍👦 Request queued at position #%d",
+		localeMsgCantGetFile:            "can't get file",
+		localeMsgContentPolicyViolation: "the prompt violates the content policy",
+		localeMsgInvalidCommand:         "invalid command",
+		localeMsgWelcome: "🤖 Welcome! This is a Telegram Bot frontend for rendering images with Stable Diffusion.\n\n" +
+			"More info: https://github.com/nonoo/stable-diffusion-telegram-bot",
+		localeMsgHelp: "🤖 Stable Diffusion Telegram Bot\n\n" +
+			"Available commands:\n\n" +
+			"%[1]ssd [prompt] - render prompt\n" +
+			"%[1]ssdupscale - upscale image\n" +
+			"%[1]ssdinterrogate [clip|deepdanbooru] - get a prompt for an image\n" +
+			"%[1]ssdoutpaint [prompt] - extend the canvas of an image\n" +
+			"%[1]ssdanim [prompt] - render an animation\n" +
+			"%[1]ssdcancel - cancel ongoing request, or the request it replies to\n" +
+			"%[1]ssdqueue - list queued requests\n" +
+			"%[1]ssdmodels [filter] - list available models\n" +
+			"%[1]ssdmodel - show the loaded model\n" +
+			"%[1]ssdmodel set [name] - load a model (admins only)\n" +
+			"%[1]ssdmodel refresh - rescan the model directory\n" +
+			"%[1]ssdsamplers [filter] - list available samplers\n" +
+			"%[1]ssdembeddings [filter] - list available embeddings\n" +
+			"%[1]ssdloras [filter] - list available LoRAs\n" +
+			"%[1]ssdupscalers [filter] - list available upscalers\n" +
+			"%[1]ssdvaes [filter] - list available VAEs\n" +
+			"%[1]ssdsmi - get the output of nvidia-smi\n" +
+			"%[1]ssdfmt [params|reset] - show or set your default output format, for ex. %[1]ssdfmt -fmt webp -q 90\n" +
+			"%[1]ssdlang [code|reset] - show or set your language\n" +
+			"%[1]ssdlang group [code|reset] - set the language of the group (admins only)\n" +
+			"%[1]ssdhelp - show this help\n\n" +
+			"Available render parameters at the end of the prompt:\n\n" +
+			"-seed/s - set seed\n" +
+			"-width/w - set output image width\n" +
+			"-height/h - set output image height\n" +
+			"-ar - set aspect ratio (for ex. 16:9, 2:3, portrait, landscape, wide, tall)\n" +
+			"-steps/t - set the number of steps\n" +
+			"-outcnt/o - set count of output images\n" +
+			"-png - upload PNGs instead of JPEGs, the lossless originals are also sent as files\n" +
+			"-file - also send the lossless originals as files\n" +
+			"-fmt - set output format, valid values: jpg, png, webp, webp-lossless, avif\n" +
+			"-q - set output quality (1-100)\n" +
+			"-chroma - set chroma subsampling of JPEG and AVIF outputs, valid values: 420, 444\n" +
+			"-grid, -nogrid - send multiple output images as one contact sheet or separately\n" +
+			"-cfg/c - set CFG scale\n" +
+			"-sampler/r - set sampler, get valid values with %[1]ssdsamplers\n" +
+			"-model/m - set model, get valid values with %[1]ssdmodels\n" +
+			"-refiner - set SDXL refiner model, use none to disable the default refiner of the model\n" +
+			"-refiner-at - set the fraction of the steps (0-1) where the refiner takes over\n" +
+			"-vae - set VAE, get valid values with %[1]ssdvaes\n" +
+			"-clipskip - set clip skip (1-12)\n" +
+			"-eta - set the eta of ancestral samplers (0-1)\n" +
+			"-ensd - set the eta noise seed delta\n" +
+			"-scheduler - set scheduler, valid values: Automatic, karras, exponential, polyexponential\n" +
+			"-set - override a WebUI setting (for ex. eta_ancestral=0.5), can be used multiple times\n" +
+			"-lora - add LoRA with optional weight (for ex. name:0.8), can be used multiple times, get valid values with %[1]ssdloras\n" +
+			"-emb - add embedding, can be used multiple times, get valid values with %[1]ssdembeddings\n" +
+			"-upscale/u - upscale output image with ratio\n" +
+			"-upscaler - set upscaler method, get valid values with %[1]ssdupscalers\n" +
+			"-hr - enable highres mode and set upscale ratio\n" +
+			"-hr-denoisestrength/hrd - set highres mode denoise strength\n" +
+			"-hr-upscaler/hru - set highres mode upscaler, get valid values with %[1]ssdupscalers\n" +
+			"-hr-steps/hrt - set the number of highres mode second pass steps\n" +
+			"-hr-w/hrw, -hr-h/hrh - set the highres mode output size instead of the ratio\n" +
+			"-hr-sampler/hrr - set highres mode second pass sampler, get valid values with %[1]ssdsamplers\n" +
+			"-hr-cfg/hrc - set highres mode second pass CFG scale\n" +
+			"-gfpgan, -codeformer, -upscaler2/u2, -size, -crop - post-process output images, see the upscale parameters\n\n" +
+			"Available upscale parameters:\n\n" +
+			"-upscale/u - upscale output image with ratio, use 1 for face restoration only\n" +
+			"-upscaler - set upscaler method, get valid values with %[1]ssdupscalers\n" +
+			"-upscaler2/u2 - set secondary upscaler with optional visibility (for ex. \"R-ESRGAN 4x+:0.3\")\n" +
+			"-size - resize to the given size (for ex. 2048x2048) instead of using the ratio\n" +
+			"-crop - crop the image to fit the size set with -size\n" +
+			"-gfpgan - restore faces with GFPGAN using the given visibility (0-1)\n" +
+			"-codeformer - restore faces with CodeFormer using the given visibility and optional weight (for ex. 0.5:0.7)\n" +
+			"-png - upload PNGs instead of JPEGs, the lossless originals are also sent as files\n" +
+			"-fmt - set output format, valid values: jpg, png, webp, webp-lossless, avif\n" +
+			"-q - set output quality (1-100)\n" +
+			"-chroma - set chroma subsampling of JPEG and AVIF outputs, valid values: 420, 444\n" +
+			"-file - also send the lossless originals as files\n\n" +
+			"Available outpaint parameters:\n\n" +
+			"-left, -right, -top, -bottom - extend the side of the image with the given pixels\n" +
+			"-fill - fill mode of the new area before painting, valid values: edge, noise\n" +
+			"-passes - paint the extension in the given number of passes, by default one pass extends a side with max. 256 pixels\n" +
+			"-denoise - set the denoising strength (0-1)\n" +
+			"-seed/s, -steps/t, -cfg/c, -sampler/r, -model/m, -lora, -emb, -png, -fmt, -q, -chroma, -file - see the render parameters\n\n" +
+			"Available animation parameters:\n\n" +
+			"-frames - set the number of frames\n" +
+			"-fps - set the frame rate\n" +
+			"-seed2 - walk from the seed to this seed during the animation, used with a random seed if -to is not set\n" +
+			"-to - blend the prompt into this prompt during the animation (for ex. -to \"a dog\")\n" +
+			"The render parameters can also be used, except the highres mode and the post-processing ones.\n\n" +
+			"Lines of the message starting with hr: or hr-neg: set the prompt or the negative prompt of the highres mode second pass.\n" +
+			"Reply %[1]ssd -pick N to a contact sheet to get its Nth image in full resolution.\n" +
+			"Reply with %[1]ssdupscale, %[1]ssdinterrogate or %[1]ssdoutpaint to an image to process it without uploading it again.\n\n" +
+			"For more information see https://github.com/nonoo/stable-diffusion-telegram-bot",
+		localeMsgModels:                  "🧩 Available models (%d):",
+		localeMsgNoModels:                "No available models.",
+		localeMsgSamplers:                "🔭 Available samplers (%d):",
+		localeMsgNoSamplers:              "No available samplers.",
+		localeMsgEmbeddings:              "Available embeddings (%d):",
+		localeMsgNoEmbeddings:            "No available embeddings.",
+		localeMsgLoRAs:                   "Available LoRAs (%d):",
+		localeMsgNoLoRAs:                 "No available LoRAs.",
+		localeMsgUpscalers:               "🔎 Available upscalers (%d):",
+		localeMsgNoUpscalers:             "🔎 No available upscalers.",
+		localeMsgVAEs:                    "Available VAEs (%d):",
+		localeMsgNoVAEs:                  "No available VAEs.",
+		localeMsgInlineRenderTitle:       "🎨 Render",
+		localeMsgInlineQueued:            "🛎 Render queued...",
+		localeMsgInlineRenderAgain:       "🔄 Render again",
+		localeMsgLangCurrent:             "🌐 Current language: %s\nAvailable languages: %s",
+		localeMsgLangSet:                 "🌐 Your language is set to %s",
+		localeMsgLangGroupSet:            "🌐 The language of the group is set to %s",
+		localeMsgLangReset:               "🌐 Your language override is removed",
+		localeMsgLangGroupReset:          "🌐 The language override of the group is removed",
+		localeMsgLangUnknown:             "unknown language: %s",
+		localeMsgLangNotGroup:            "this is not a group",
+		localeMsgLangGroupAdminOnly:      "only admins can set the language of the group",
+		localeMsgCancelButton:            "❌ Cancel",
+		localeMsgNoActiveRequest:         "no active request to cancel",
+		localeMsgRequestNotFound:         "the request is not in the queue",
+		localeMsgCancelNotAllowed:        "only the requester or an admin can cancel this request",
+		localeMsgQueueEmpty:              "👨‍👦‍👦 The queue is empty.",
+		localeMsgQueueHeader:             "👨‍👦‍👦 Queue:",
+		localeMsgQueueRunning:            "▶️ %s, %s: %s - running, ETA: ~%s",
+		localeMsgQueueWaiting:            "#%d %s, %s: %s - starts in ~%s",
+		localeMsgInterrogateResult:       "🔍 Interrogated prompt (%s):",
+		localeMsgInterrogateRender:       "🎨 Render this",
+		localeMsgInterrogateNoPrompt:     "no prompt found in the message",
+		localeMsgInterrogateInvalidModel: "invalid interrogation model, valid values: %s",
+		localeMsgNotAllowed:              "you are not allowed to use the bot here",
+		localeMsgLoadingModel:            "⏳ Loading model %s…",
+		localeMsgModelLoaded:             "🧩 Loaded model: %s",
+		localeMsgModelInvalid:            "invalid model: %s",
+		localeMsgAdminOnly:               "only admins can use this command",
+		localeMsgLoRATriggerWords:        "trigger words: %s",
+		localeMsgListFilter:              "🔍 Filter: %s",
+		localeMsgListDefault:             "Default: %s",
+		localeMsgListPage:                "Page %d/%d",
+		localeMsgListExpired:             "this listing has expired, please send the command again",
+		localeMsgGridExpired:             "the images of this grid are not available anymore",
+		localeMsgGridInvalidPick:         "invalid image number, valid values: 1-%d",
+		localeMsgFormatCurrent:           "🖼 Your default output format: %s",
+		localeMsgFormatSet:               "🖼 Your default output format is set to %s",
+		localeMsgFormatReset:             "🖼 Your default output format is removed",
+
+		// Error messages.
+		localeMsgCantParseParams:          "can't parse params: %v",
+		localeMsgMissingPrompt:            "missing prompt",
+		localeMsgParamsAfterPrompt:        "params need to be after the prompt",
+		localeMsgParamMissingValue:        "%s is missing value",
+		localeMsgParamInvalid:             "invalid %s value",
+		localeMsgParamInvalidRange:        "invalid %s value, it should be between %v and %v",
+		localeMsgParamInvalidChoice:       "invalid %s value, valid values: %s",
+		localeMsgParamUnknownValue:        "unknown %s value: %s",
+		localeMsgParamMax:                 "%s can't be more than %v",
+		localeMsgParamNotOutput:           "%s is not an output param",
+		localeMsgParamAspectRatioWithSize: "aspect ratio can't be used with width or height",
+		localeMsgParamHRSizeTooSmall:      "hr size can't be smaller than the image size %dx%d",
+		localeMsgParamSettingModel:        "use -model for setting the model",
+		localeMsgParamSettingNotAllowed:   "setting %s is not allowed",
+		localeMsgParamSettingFormat:       "invalid setting, use key=value",
+		localeMsgAspectRatioInvalid:       "invalid aspect ratio",
+		localeMsgAspectRatioOutOfRange:    "aspect ratio out of range",
+		localeMsgOutputNeedsFFmpeg:        "%s output is not available, ffmpeg_path is not set",
+		localeMsgChromaNeedsFFmpeg:        "4:4:4 chroma subsampling is not available, ffmpeg_path is not set",
+		localeMsgLimitInvalidSize:         "invalid image size",
+		localeMsgLimitInvalidSteps:        "invalid steps",
+		localeMsgLimitInvalidOutputCount:  "invalid output count",
+		localeMsgLimitSteps:               "steps can't be more than %d",
+		localeMsgLimitHRSteps:             "hr steps can't be more than %d",
+		localeMsgLimitOutputCount:         "output count can't be more than %d",
+		localeMsgLimitHRScale:             "hr scale can't be more than %v",
+		localeMsgLimitUpscale:             "upscale ratio can't be more than %v",
+		localeMsgLimitMegapixels:          "output image size can't be more than %.1f megapixels",
+		localeMsgLimitTotalMegapixels:     "total size of output images can't be more than %.1f megapixels",
+		localeMsgLimitUpscaleMegapixels:   "upscaled image size can't be more than %.1f megapixels",
+		localeMsgLimitOutpaintMegapixels:  "outpainted image size can't be more than %.1f megapixels",
+		localeMsgLimitOutpaintExtension:   "total outpaint extension can't be more than %d pixels",
+		localeMsgLimitOutpaintPasses:      "outpaint pass count can't be more than %d",
+		localeMsgLimitFrames:              "frame count can't be more than %d",
+		localeMsgOutpaintMissingExtension: "missing extension, set at least one of -left, -right, -top or -bottom",
+		localeMsgOutpaintImageTooSmall:    "image is too small",
+		localeMsgErrGetting:               "error getting %s: %v",
+		localeMsgModelLoadError:           "can't load model: %v",
+		localeMsgModelRefreshError:        "can't refresh models: %v",
+		localeMsgSMIError:                 "error running nvidia-smi: %v",
+		localeMsgFormatOnlyOutputParams:   "only output params can be given",
+	},
+}
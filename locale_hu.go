@@ -0,0 +1,207 @@
+package main
+
+var localeHU = localeType{
+	code: "hu",
+	name: "Magyar",
+	msgs: map[localeMsgID]string{
+		localeMsgImageReq:               "🩻 Küldd el a feldolgozandó képfájlt.",
+		localeMsgProcessStart:           "🛎 Renderelés indítása...",
+		localeMsgProcess:                "🔨 Feldolgozás",
+		localeMsgETA:                    "Hátralévő idő",
+		localeMsgDownloading:            "⬇ Letöltés...",
+		localeMsgDownloadDone:           "✅ Letöltés kész",
+		localeMsgUploading:              "☁ ️ Feltöltés...",
+		localeMsgError:                  "❌ Hiba",
+		localeMsgCanceled:               "❌ Megszakítva",
+		localeMsgRestart:                "⚠️ A Stable Diffusion nem fut, indítás folyamatban, kérlek várj...",
+		localeMsgRestartFailed:          "☠️ A Stable Diffusion indítása sikertelen, kérlek indítsd újra a botot",
+		localeMsgLimitsClamped:          "⚠️ A paraméterek a korlátokhoz lettek igazítva",
+		localeMsgQueuePosition:          "👨‍👦‍👦 A kérés várakozik, pozíció: #%d",
+		localeMsgCantGetFile:            "nem sikerült letölteni a fájlt",
+		localeMsgContentPolicyViolation: "a prompt sérti a tartalmi szabályzatot",
+		localeMsgInvalidCommand:         "ismeretlen parancs",
+		localeMsgWelcome: "🤖 Üdv! Ez egy Telegram bot, amivel Stable Diffusionnel renderelhetsz képeket.\n\n" +
+			"További információ: https://github.com/nonoo/stable-diffusion-telegram-bot",
+		localeMsgHelp: "🤖 Stable Diffusion Telegram Bot\n\n" +
+			"Elérhető parancsok:\n\n" +
+			"%[1]ssd [prompt] - prompt renderelése\n" +
+			"%[1]ssdupscale - kép felskálázása\n" +
+			"%[1]ssdinterrogate [clip|deepdanbooru] - prompt felismerése egy képből\n" +
+			"%[1]ssdoutpaint [prompt] - kép vásznának kiterjesztése\n" +
+			"%[1]ssdanim [prompt] - animáció renderelése\n" +
+			"%[1]ssdcancel - folyamatban lévő kérés, vagy a megválaszolt kérés megszakítása\n" +
+			"%[1]ssdqueue - várólistán lévő kérések listája\n" +
+			"%[1]ssdmodels [szűrő] - elérhető modellek listája\n" +
+			"%[1]ssdmodel - a betöltött modell lekérdezése\n" +
+			"%[1]ssdmodel set [név] - modell betöltése (csak adminoknak)\n" +
+			"%[1]ssdmodel refresh - a modellkönyvtár újraolvasása\n" +
+			"%[1]ssdsamplers [szűrő] - elérhető samplerek listája\n" +
+			"%[1]ssdembeddings [szűrő] - elérhető embeddingek listája\n" +
+			"%[1]ssdloras [szűrő] - elérhető LoRA-k listája\n" +
+			"%[1]ssdupscalers [szűrő] - elérhető upscalerek listája\n" +
+			"%[1]ssdvaes [szűrő] - elérhető VAE-k listája\n" +
+			"%[1]ssdsmi - az nvidia-smi kimenete\n" +
+			"%[1]ssdfmt [paraméterek|reset] - az alapértelmezett kimeneti formátumod lekérdezése vagy beállítása, pl. %[1]ssdfmt -fmt webp -q 90\n" +
+			"%[1]ssdlang [kód|reset] - a nyelved lekérdezése vagy beállítása\n" +
+			"%[1]ssdlang group [kód|reset] - a csoport nyelvének beállítása (csak adminoknak)\n" +
+			"%[1]ssdhelp - ez a súgó\n\n" +
+			"A prompt végén megadható render paraméterek:\n\n" +
+			"-seed/s - seed beállítása\n" +
+			"-width/w - kimeneti kép szélessége\n" +
+			"-height/h - kimeneti kép magassága\n" +
+			"-ar - képarány (pl. 16:9, 2:3, portrait, landscape, wide, tall)\n" +
+			"-steps/t - lépések száma\n" +
+			"-outcnt/o - kimeneti képek száma\n" +
+			"-png - PNG feltöltése JPEG helyett, a veszteségmentes eredetik fájlként is el lesznek küldve\n" +
+			"-file - a veszteségmentes eredetik elküldése fájlként is\n" +
+			"-fmt - kimeneti formátum, lehetséges értékek: jpg, png, webp, webp-lossless, avif\n" +
+			"-q - kimeneti minőség (1-100)\n" +
+			"-chroma - JPEG és AVIF kimenet színmintavételezése, lehetséges értékek: 420, 444\n" +
+			"-grid, -nogrid - több kimeneti kép küldése egy áttekintő képen vagy külön\n" +
+			"-cfg/c - CFG scale beállítása\n" +
+			"-sampler/r - sampler beállítása, lehetséges értékek: %[1]ssdsamplers\n" +
+			"-model/m - modell beállítása, lehetséges értékek: %[1]ssdmodels\n" +
+			"-refiner - SDXL refiner modell beállítása, a none kikapcsolja a modell alapértelmezett refinerét\n" +
+			"-refiner-at - a lépések aránya (0-1), aminél a refiner átveszi a renderelést\n" +
+			"-vae - VAE beállítása, lehetséges értékek: %[1]ssdvaes\n" +
+			"-clipskip - clip skip beállítása (1-12)\n" +
+			"-eta - az ancestral samplerek eta értéke (0-1)\n" +
+			"-ensd - eta noise seed delta beállítása\n" +
+			"-scheduler - scheduler beállítása, lehetséges értékek: Automatic, karras, exponential, polyexponential\n" +
+			"-set - egy WebUI beállítás felülírása (pl. eta_ancestral=0.5), többször is megadható\n" +
+			"-lora - LoRA hozzáadása opcionális súllyal (pl. név:0.8), többször is megadható, lehetséges értékek: %[1]ssdloras\n" +
+			"-emb - embedding hozzáadása, többször is megadható, lehetséges értékek: %[1]ssdembeddings\n" +
+			"-upscale/u - kimeneti kép felskálázása a megadott aránnyal\n" +
+			"-upscaler - felskálázási módszer, lehetséges értékek: %[1]ssdupscalers\n" +
+			"-hr - highres mód bekapcsolása a megadott felskálázási aránnyal\n" +
+			"-hr-denoisestrength/hrd - highres mód denoise erőssége\n" +
+			"-hr-upscaler/hru - highres mód upscalere, lehetséges értékek: %[1]ssdupscalers\n" +
+			"-hr-steps/hrt - highres mód második menetének lépésszáma\n" +
+			"-hr-w/hrw, -hr-h/hrh - highres mód kimeneti mérete az arány helyett\n" +
+			"-hr-sampler/hrr - highres mód második menetének samplere, lehetséges értékek: %[1]ssdsamplers\n" +
+			"-hr-cfg/hrc - highres mód második menetének CFG skálája\n" +
+			"-gfpgan, -codeformer, -upscaler2/u2, -size, -crop - kimeneti képek utófeldolgozása, lásd a felskálázási paramétereket\n\n" +
+			"Felskálázási paraméterek:\n\n" +
+			"-upscale/u - kép felskálázása a megadott aránnyal, 1 esetén csak arcjavítás\n" +
+			"-upscaler - felskálázási módszer, lehetséges értékek: %[1]ssdupscalers\n" +
+			"-upscaler2/u2 - második upscaler opcionális láthatósággal (pl. \"R-ESRGAN 4x+:0.3\")\n" +
+			"-size - átméretezés a megadott méretre (pl. 2048x2048) az arány helyett\n" +
+			"-crop - a kép levágása a -size által megadott méretre\n" +
+			"-gfpgan - arcjavítás GFPGAN-nel a megadott láthatósággal (0-1)\n" +
+			"-codeformer - arcjavítás CodeFormerrel a megadott láthatósággal és opcionális súllyal (pl. 0.5:0.7)\n" +
+			"-png - PNG feltöltése JPEG helyett, a veszteségmentes eredetik fájlként is el lesznek küldve\n" +
+			"-fmt - kimeneti formátum, lehetséges értékek: jpg, png, webp, webp-lossless, avif\n" +
+			"-q - kimeneti minőség (1-100)\n" +
+			"-chroma - JPEG és AVIF kimenet színmintavételezése, lehetséges értékek: 420, 444\n" +
+			"-file - a veszteségmentes eredetik elküldése fájlként is\n\n" +
+			"Kiterjesztési (outpaint) paraméterek:\n\n" +
+			"-left, -right, -top, -bottom - a kép adott oldalának kiterjesztése a megadott pixelszámmal\n" +
+			"-fill - az új terület kitöltése festés előtt, lehetséges értékek: edge, noise\n" +
+			"-passes - a kiterjesztés megfestése a megadott számú menetben, alapból egy menet legfeljebb 256 pixellel bővít egy oldalt\n" +
+			"-denoise - denoise erősség beállítása (0-1)\n" +
+			"-seed/s, -steps/t, -cfg/c, -sampler/r, -model/m, -lora, -emb, -png, -fmt, -q, -chroma, -file - lásd a render paramétereket\n\n" +
+			"Animációs paraméterek:\n\n" +
+			"-frames - képkockák száma\n" +
+			"-fps - képkocka sebesség\n" +
+			"-seed2 - átmenet a seedből ebbe a seedbe az animáció alatt, véletlen seeddel használva, ha nincs megadva -to\n" +
+			"-to - a prompt átúsztatása ebbe a promptba az animáció alatt (pl. -to \"egy kutya\")\n" +
+			"A render paraméterek is használhatók, kivéve a highres módot és az utófeldolgozást.\n\n" +
+			"Az üzenet hr: vagy hr-neg: kezdetű sorai a highres mód második menetének promptját vagy negatív promptját adják meg.\n" +
+			"Egy áttekintő képre %[1]ssd -pick N paranccsal válaszolva megkapod az N. képet teljes felbontásban.\n" +
+			"Ha egy képre válaszolva küldöd a %[1]ssdupscale, %[1]ssdinterrogate vagy %[1]ssdoutpaint parancsot, akkor nem kell újra feltöltened a képet.\n\n" +
+			"További információ: https://github.com/nonoo/stable-diffusion-telegram-bot",
+		localeMsgModels:                  "🧩 Elérhető modellek (%d):",
+		localeMsgNoModels:                "Nincs elérhető modell.",
+		localeMsgSamplers:                "🔭 Elérhető samplerek (%d):",
+		localeMsgNoSamplers:              "Nincs elérhető sampler.",
+		localeMsgEmbeddings:              "Elérhető embeddingek (%d):",
+		localeMsgNoEmbeddings:            "Nincs elérhető embedding.",
+		localeMsgLoRAs:                   "Elérhető LoRA-k (%d):",
+		localeMsgNoLoRAs:                 "Nincs elérhető LoRA.",
+		localeMsgUpscalers:               "🔎 Elérhető upscalerek (%d):",
+		localeMsgNoUpscalers:             "🔎 Nincs elérhető upscaler.",
+		localeMsgVAEs:                    "Elérhető VAE-k (%d):",
+		localeMsgNoVAEs:                  "Nincs elérhető VAE.",
+		localeMsgInlineRenderTitle:       "🎨 Renderelés",
+		localeMsgInlineQueued:            "🛎 Renderelés sorba állítva...",
+		localeMsgInlineRenderAgain:       "🔄 Újrarenderelés",
+		localeMsgLangCurrent:             "🌐 Jelenlegi nyelv: %s\nElérhető nyelvek: %s",
+		localeMsgLangSet:                 "🌐 A nyelved beállítva: %s",
+		localeMsgLangGroupSet:            "🌐 A csoport nyelve beállítva: %s",
+		localeMsgLangReset:               "🌐 A nyelvi beállításod törölve",
+		localeMsgLangGroupReset:          "🌐 A csoport nyelvi beállítása törölve",
+		localeMsgLangUnknown:             "ismeretlen nyelv: %s",
+		localeMsgLangNotGroup:            "ez nem egy csoport",
+		localeMsgLangGroupAdminOnly:      "csak adminok állíthatják be a csoport nyelvét",
+		localeMsgCancelButton:            "❌ Megszakítás",
+		localeMsgNoActiveRequest:         "nincs megszakítható kérés",
+		localeMsgRequestNotFound:         "a kérés nincs a várólistán",
+		localeMsgCancelNotAllowed:        "ezt a kérést csak a kérő vagy egy admin szakíthatja meg",
+		localeMsgQueueEmpty:              "👨‍👦‍👦 A várólista üres.",
+		localeMsgQueueHeader:             "👨‍👦‍👦 Várólista:",
+		localeMsgQueueRunning:            "▶️ %s, %s: %s - folyamatban, hátralévő idő: ~%s",
+		localeMsgQueueWaiting:            "#%d %s, %s: %s - kezdés ~%s múlva",
+		localeMsgInterrogateResult:       "🔍 Felismert prompt (%s):",
+		localeMsgInterrogateRender:       "🎨 Renderelés",
+		localeMsgInterrogateNoPrompt:     "nem található prompt az üzenetben",
+		localeMsgInterrogateInvalidModel: "ismeretlen felismerési modell, lehetséges értékek: %s",
+		localeMsgNotAllowed:              "itt nem használhatod a botot",
+		localeMsgLoadingModel:            "⏳ %s modell betöltése…",
+		localeMsgModelLoaded:             "🧩 Betöltött modell: %s",
+		localeMsgModelInvalid:            "ismeretlen modell: %s",
+		localeMsgAdminOnly:               "ezt a parancsot csak adminok használhatják",
+		localeMsgLoRATriggerWords:        "aktiváló szavak: %s",
+		localeMsgListFilter:              "🔍 Szűrő: %s",
+		localeMsgListDefault:             "Alapértelmezett: %s",
+		localeMsgListPage:                "%d/%d. oldal",
+		localeMsgListExpired:             "ez a lista lejárt, kérlek küldd el újra a parancsot",
+		localeMsgGridExpired:             "a rács képei már nem érhetők el",
+		localeMsgGridInvalidPick:         "érvénytelen képszám, lehetséges értékek: 1-%d",
+		localeMsgFormatCurrent:           "🖼 Az alapértelmezett kimeneti formátumod: %s",
+		localeMsgFormatSet:               "🖼 Az alapértelmezett kimeneti formátumod beállítva: %s",
+		localeMsgFormatReset:             "🖼 Az alapértelmezett kimeneti formátumod törölve",
+
+		// Error messages.
+		localeMsgCantParseParams:          "nem sikerült értelmezni a paramétereket: %v",
+		localeMsgMissingPrompt:            "hiányzik a prompt",
+		localeMsgParamsAfterPrompt:        "a paramétereknek a prompt után kell lenniük",
+		localeMsgParamMissingValue:        "hiányzik a(z) %s értéke",
+		localeMsgParamInvalid:             "érvénytelen %s érték",
+		localeMsgParamInvalidRange:        "érvénytelen %s érték, %v és %v között kell lennie",
+		localeMsgParamInvalidChoice:       "érvénytelen %s érték, lehetséges értékek: %s",
+		localeMsgParamUnknownValue:        "ismeretlen %s érték: %s",
+		localeMsgParamMax:                 "a(z) %s értéke nem lehet több, mint %v",
+		localeMsgParamNotOutput:           "a(z) %s nem kimeneti paraméter",
+		localeMsgParamAspectRatioWithSize: "a képarány nem használható a szélességgel vagy a magassággal együtt",
+		localeMsgParamHRSizeTooSmall:      "a highres méret nem lehet kisebb a kép méreténél (%dx%d)",
+		localeMsgParamSettingModel:        "a modell beállításához használd a -model paramétert",
+		localeMsgParamSettingNotAllowed:   "a(z) %s beállítás nem engedélyezett",
+		localeMsgParamSettingFormat:       "érvénytelen beállítás, használd a kulcs=érték formát",
+		localeMsgAspectRatioInvalid:       "érvénytelen képarány",
+		localeMsgAspectRatioOutOfRange:    "a képarány a megengedett tartományon kívül esik",
+		localeMsgOutputNeedsFFmpeg:        "a(z) %s kimenet nem érhető el, az ffmpeg_path nincs beállítva",
+		localeMsgChromaNeedsFFmpeg:        "a 4:4:4 színalmintavételezés nem érhető el, az ffmpeg_path nincs beállítva",
+		localeMsgLimitInvalidSize:         "érvénytelen képméret",
+		localeMsgLimitInvalidSteps:        "érvénytelen lépésszám",
+		localeMsgLimitInvalidOutputCount:  "érvénytelen kimeneti képszám",
+		localeMsgLimitSteps:               "a lépések száma nem lehet több, mint %d",
+		localeMsgLimitHRSteps:             "a highres lépések száma nem lehet több, mint %d",
+		localeMsgLimitOutputCount:         "a kimeneti képek száma nem lehet több, mint %d",
+		localeMsgLimitHRScale:             "a highres nagyítás nem lehet több, mint %v",
+		localeMsgLimitUpscale:             "a nagyítás aránya nem lehet több, mint %v",
+		localeMsgLimitMegapixels:          "a kimeneti kép mérete nem lehet több, mint %.1f megapixel",
+		localeMsgLimitTotalMegapixels:     "a kimeneti képek összmérete nem lehet több, mint %.1f megapixel",
+		localeMsgLimitUpscaleMegapixels:   "a nagyított kép mérete nem lehet több, mint %.1f megapixel",
+		localeMsgLimitOutpaintMegapixels:  "a kibővített kép mérete nem lehet több, mint %.1f megapixel",
+		localeMsgLimitOutpaintExtension:   "a kibővítés összesen nem lehet több, mint %d pixel",
+		localeMsgLimitOutpaintPasses:      "a kibővítési menetek száma nem lehet több, mint %d",
+		localeMsgLimitFrames:              "a képkockák száma nem lehet több, mint %d",
+		localeMsgOutpaintMissingExtension: "hiányzik a bővítés, állítsd be a -left, -right, -top vagy -bottom paraméterek legalább egyikét",
+		localeMsgOutpaintImageTooSmall:    "a kép túl kicsi",
+		localeMsgErrGetting:               "hiba a(z) %s lekérésekor: %v",
+		localeMsgModelLoadError:           "nem sikerült betölteni a modellt: %v",
+		localeMsgModelRefreshError:        "nem sikerült frissíteni a modelleket: %v",
+		localeMsgSMIError:                 "hiba az nvidia-smi futtatásakor: %v",
+		localeMsgFormatOnlyOutputParams:   "csak kimeneti paraméterek adhatók meg",
+	},
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestLocaleMessages(t *testing.T) {
+	for id := range localeEN.msgs {
+		if _, ok := localeHU.msgs[id]; !ok {
+			t.Errorf("message %d is missing from the Hungarian locale", id)
+		}
+	}
+}
+
+func TestLocaleErr(t *testing.T) {
+	testSetParams(t, nil)
+	testSetSDAPIMock(t, sdAPIMockParams{})
+
+	var r ReqParamsRender
+	_, err := ReqParamsParse(context.Background(), "a cat -w", &r)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	err = newLocaleError(localeMsgCantParseParams, err)
+
+	if s := localeHU.Err(err); s != "nem sikerült értelmezni a paramétereket: hiányzik a(z) w értéke" {
+		t.Errorf("got %q in Hungarian", s)
+	}
+	if s := err.Error(); s != "can't parse params: w is missing value" {
+		t.Errorf("got %q in English", s)
+	}
+
+	wrapped := newLocaleError(localeMsgErrGetting, "models", context.Canceled)
+	if !errors.Is(wrapped, context.Canceled) {
+		t.Error("the wrapped error is not found")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-telegram/bot/models"
+)
+
+const logRedactedStr = "[REDACTED]"
+
+var logSecretsMutex sync.RWMutex
+var logSecrets []string
+
+// Registers a string (like the bot token) which will be replaced in all log output.
+func logAddSecret(s string) {
+	if s == "" {
+		return
+	}
+	logSecretsMutex.Lock()
+	logSecrets = append(logSecrets, s)
+	logSecretsMutex.Unlock()
+}
+
+// Replaces all registered secrets in the given string.
+func redactSecrets(s string) string {
+	logSecretsMutex.RLock()
+	defer logSecretsMutex.RUnlock()
+	for _, secret := range logSecrets {
+		s = strings.ReplaceAll(s, secret, logRedactedStr)
+	}
+	return s
+}
+
+func logReplaceAttr(groups []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(redactSecrets(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.StringValue(redactSecrets(err.Error()))
+		}
+	}
+	return a
+}
+
+func logParseLevel(s string) (level slog.Level, err error) {
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err = level.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("invalid log level: %s", s)
+	}
+	return
+}
+
+// The level of the default logger, it can be changed runtime.
+var logLevel slog.LevelVar
+
+// Sets up the default slog logger. Log lines are written to stderr as text or JSON.
+func logInit(level slog.Level, json bool) {
+	logLevel.Set(level)
+	opts := &slog.HandlerOptions{
+		Level:       &logLevel,
+		ReplaceAttr: logReplaceAttr,
+	}
+
+	var handler slog.Handler
+	if json {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// Returns a logger with the user and chat ID attributes of the given message.
+func logWithMsg(msg *models.Message) *slog.Logger {
+	if msg == nil {
+		return slog.Default()
+	}
+	l := slog.With("chat_id", msg.Chat.ID)
+	if msg.From != nil {
+		l = l.With("user_id", msg.From.ID, "username", msg.From.Username)
+	}
+	return l
+}
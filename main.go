@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,24 +17,30 @@ import (
 
 var telegramBot *bot.Bot
 var cmdHandler cmdHandlerType
-var sdAPI sdAPIType
+var sdAPI sdAPIInterface
 var reqQueue ReqQueue
 
 func sendReplyToMessage(ctx context.Context, replyToMsg *models.Message, s string) (msg *models.Message) {
+	return sendReplyToMessageWithMarkup(ctx, replyToMsg, s, nil)
+}
+
+func sendReplyToMessageWithMarkup(ctx context.Context, replyToMsg *models.Message, s string, markup models.ReplyMarkup) (msg *models.Message) {
 	var err error
 	msg, err = telegramBot.SendMessage(ctx, &bot.SendMessageParams{
 		ReplyToMessageID: replyToMsg.ID,
 		ChatID:           replyToMsg.Chat.ID,
 		Text:             s,
+		ReplyMarkup:      markup,
 	})
 	if err != nil {
-		fmt.Println("  reply send error:", err)
+		logWithMsg(replyToMsg).Error("reply send error", "error", err)
+		metrics.IncTelegramAPIError("sendMessage")
 	}
 	return
 }
 
 func sendTextToAdmins(ctx context.Context, s string) {
-	for _, chatID := range params.AdminUserIDs {
+	for _, chatID := range getParams().AdminUserIDs {
 		_, _ = telegramBot.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: chatID,
 			Text:   s,
@@ -54,10 +62,12 @@ func handleImage(ctx context.Context, update *models.Update, fileID, filename st
 	var g GetFile
 	d, err := g.GetFile(ctx, fileID)
 	if err != nil {
-		reqQueue.currentEntry.entry.sendReply(ctx, errorStr+": can't get file: "+err.Error())
+		reqQueue.currentEntry.entry.log().Error("can't get file", "error", err)
+		loc := reqQueue.currentEntry.entry.loc()
+		reqQueue.currentEntry.entry.sendReply(ctx, loc.T(localeMsgError)+": "+loc.T(localeMsgCantGetFile)+": "+redactSecrets(err.Error()))
 		return
 	}
-	reqQueue.currentEntry.entry.sendReply(ctx, doneStr+" downloading\n"+reqQueue.currentEntry.entry.Params.String())
+	reqQueue.currentEntry.entry.sendReply(ctx, reqQueue.currentEntry.entry.loc().T(localeMsgDownloadDone)+"\n"+reqQueue.currentEntry.entry.Params.String())
 	// Updating the message to reply to this document.
 	reqQueue.currentEntry.entry.Message = update.Message
 	reqQueue.currentEntry.entry.ReplyMessage = nil
@@ -68,92 +78,161 @@ func handleImage(ctx context.Context, update *models.Update, fileID, filename st
 	}
 }
 
-func handleMessage(ctx context.Context, update *models.Update) {
-	if update.Message.Text == "" {
-		return
+func handleCallbackQuery(ctx context.Context, query *models.CallbackQuery) {
+	var text string
+	var isError bool
+	loc := localeGet(0, query.Sender.ID, query.Sender.LanguageCode)
+	if query.Message != nil {
+		loc = localeGet(query.Message.Chat.ID, query.Sender.ID, query.Sender.LanguageCode)
 	}
 
-	fmt.Print("msg from ", update.Message.From.Username, "#", update.Message.From.ID, ": ", update.Message.Text, "\n")
+	if taskIDStr, ok := strings.CutPrefix(query.Data, reqQueueCancelCallbackPrefix); ok {
+		slog.Info("got cancel button press", "user_id", query.Sender.ID, "username", query.Sender.Username, "task_id", taskIDStr)
+		taskID, err := strconv.ParseUint(taskIDStr, 10, 64)
+		if err == nil {
+			err = reqQueue.CancelEntry(ctx, taskID, query.Sender.ID)
+		}
+		if err != nil {
+			text = loc.Err(err)
+			isError = true
+		} else {
+			text = loc.T(localeMsgCanceled)
+		}
+	} else if data, ok := strings.CutPrefix(query.Data, listingCallbackPrefix); ok && query.Message != nil {
+		if err := cmdHandler.ListingPage(ctx, query.Message, query.Sender, data); err != nil {
+			text = loc.Err(err)
+			isError = true
+		}
+	} else if nStr, ok := strings.CutPrefix(query.Data, gridPickCallbackPrefix); ok && query.Message != nil {
+		slog.Info("got pick button press", "user_id", query.Sender.ID, "username", query.Sender.Username, "number", nStr)
+		n, err := strconv.Atoi(nStr)
+		if err == nil {
+			err = cmdHandler.Pick(ctx, query.Message, query.Sender, n)
+		}
+		if err != nil {
+			text = loc.Err(err)
+			isError = true
+		}
+	} else if query.Data == reqQueueRenderCallbackData && query.Message != nil {
+		slog.Info("got render button press", "user_id", query.Sender.ID, "username", query.Sender.Username)
+		if err := cmdHandler.RenderInterrogated(ctx, query.Message, query.Sender); err != nil {
+			text = loc.Err(err)
+			isError = true
+		}
+	}
 
-	if update.Message.Chat.ID >= 0 { // From user?
-		if !slices.Contains(params.AllowedUserIDs, update.Message.From.ID) {
-			fmt.Println("  user not allowed, ignoring")
-			return
+	_, err := telegramBot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		CallbackQueryID: query.ID,
+		Text:            text,
+		ShowAlert:       isError,
+	})
+	if err != nil {
+		slog.Error("callback query answer error", "error", err)
+		metrics.IncTelegramAPIError("answerCallbackQuery")
+	}
+}
+
+// Returns true if the sender of the message is allowed to use the bot in the message's chat.
+func messageAllowed(msg *models.Message) bool {
+	if msg.Chat.ID >= 0 { // From user?
+		if !slices.Contains(getParams().AllowedUserIDs, msg.From.ID) {
+			logWithMsg(msg).Info("user not allowed, ignoring")
+			return false
 		}
 	} else { // From group ?
-		fmt.Print("  msg from group #", update.Message.Chat.ID)
-		if !slices.Contains(params.AllowedGroupIDs, update.Message.Chat.ID) {
-			fmt.Println(", group not allowed, ignoring")
-			return
+		if !slices.Contains(getParams().AllowedGroupIDs, msg.Chat.ID) {
+			logWithMsg(msg).Info("group not allowed, ignoring")
+			return false
 		}
-		fmt.Println()
+	}
+	return true
+}
+
+func handleMessage(ctx context.Context, update *models.Update) {
+	if update.Message.Text == "" {
+		return
+	}
+
+	log := logWithMsg(update.Message)
+	log.Info("got message", "text", update.Message.Text)
+
+	if !messageAllowed(update.Message) {
+		return
 	}
 
 	// Check if message is a command.
 	if update.Message.Text[0] == '/' || update.Message.Text[0] == '!' {
-		cmd := strings.Split(update.Message.Text, " ")[0]
-		if strings.Contains(cmd, "@") {
-			cmd = strings.Split(cmd, "@")[0]
-		}
-		update.Message.Text = strings.TrimPrefix(update.Message.Text, cmd+" ")
+		cmd, args, _ := strings.Cut(update.Message.Text, " ")
+		cmd, _, _ = strings.Cut(cmd, "@")
+		update.Message.Text = args
 		cmdChar := string(cmd[0])
 		cmd = cmd[1:] // Cutting the command character.
+		log.Debug("interpreting as command", "cmd", cmd)
 		switch cmd {
 		case "sd":
-			fmt.Println("  interpreting as cmd sd")
 			cmdHandler.SD(ctx, update.Message)
 			return
 		case "sdupscale":
-			fmt.Println("  interpreting as cmd sdupscale")
 			cmdHandler.SDUpscale(ctx, update.Message)
 			return
+		case "sdinterrogate":
+			cmdHandler.SDInterrogate(ctx, update.Message)
+			return
+		case "sdoutpaint":
+			cmdHandler.SDOutpaint(ctx, update.Message)
+			return
+		case "sdanim":
+			cmdHandler.SDAnim(ctx, update.Message)
+			return
 		case "sdcancel":
-			fmt.Println("  interpreting as cmd sdcancel")
 			cmdHandler.SDCancel(ctx, update.Message)
 			return
+		case "sdqueue":
+			cmdHandler.Queue(ctx, update.Message)
+			return
 		case "sdmodels":
-			fmt.Println("  interpreting as cmd sdmodels")
 			cmdHandler.Models(ctx, update.Message)
 			return
+		case "sdmodel":
+			cmdHandler.Model(ctx, update.Message)
+			return
 		case "sdsamplers":
-			fmt.Println("  interpreting as cmd sdsamplers")
 			cmdHandler.Samplers(ctx, update.Message)
 			return
 		case "sdembeddings":
-			fmt.Println("  interpreting as cmd sdembeddings")
 			cmdHandler.Embeddings(ctx, update.Message)
 			return
 		case "sdloras":
-			fmt.Println("  interpreting as cmd sdloras")
 			cmdHandler.LoRAs(ctx, update.Message)
 			return
 		case "sdupscalers":
-			fmt.Println("  interpreting as cmd sdupscalers")
 			cmdHandler.Upscalers(ctx, update.Message)
 			return
 		case "sdvaes":
-			fmt.Println("  interpreting as cmd sdvaes")
 			cmdHandler.VAEs(ctx, update.Message)
 			return
 		case "sdsmi":
-			fmt.Println("  interpreting as cmd sdsmi")
 			cmdHandler.SMI(ctx, update.Message)
 			return
+		case "sdlang":
+			cmdHandler.Lang(ctx, update.Message)
+			return
+		case "sdfmt":
+			cmdHandler.Format(ctx, update.Message)
+			return
 		case "sdhelp":
-			fmt.Println("  interpreting as cmd sdhelp")
 			cmdHandler.Help(ctx, update.Message, cmdChar)
 			return
 		case "start":
-			fmt.Println("  interpreting as cmd start")
 			if update.Message.Chat.ID >= 0 { // From user?
-				sendReplyToMessage(ctx, update.Message, "🤖 Welcome! This is a Telegram Bot frontend "+
-					"for rendering images with Stable Diffusion.\n\nMore info: https://github.com/nonoo/stable-diffusion-telegram-bot")
+				sendReplyToMessage(ctx, update.Message, localeFor(update.Message).T(localeMsgWelcome))
 			}
 			return
 		default:
-			fmt.Println("  invalid cmd")
+			log.Info("invalid command", "cmd", cmd)
 			if update.Message.Chat.ID >= 0 {
-				sendReplyToMessage(ctx, update.Message, errorStr+": invalid command")
+				loc := localeFor(update.Message)
+				sendReplyToMessage(ctx, update.Message, loc.T(localeMsgError)+": "+loc.T(localeMsgInvalidCommand))
 			}
 			return
 		}
@@ -165,37 +244,68 @@ func handleMessage(ctx context.Context, update *models.Update) {
 }
 
 func telegramBotUpdateHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if update.InlineQuery != nil {
+		handleInlineQuery(ctx, update.InlineQuery)
+		return
+	}
+	if update.ChosenInlineResult != nil {
+		handleChosenInlineResult(ctx, update.ChosenInlineResult)
+		return
+	}
+	if update.CallbackQuery != nil {
+		handleCallbackQuery(ctx, update.CallbackQuery)
+		return
+	}
 	if update.Message == nil {
 		return
 	}
 
-	if update.Message.Document != nil {
-		handleImage(ctx, update, update.Message.Document.FileID, update.Message.Document.FileName)
-	} else if update.Message.Photo != nil && len(update.Message.Photo) > 0 {
-		handleImage(ctx, update, update.Message.Photo[len(update.Message.Photo)-1].FileID, "image.jpg")
+	if fileID, filename := messageImageFile(update.Message); fileID != "" {
+		handleImage(ctx, update, fileID, filename)
 	} else if update.Message.Text != "" {
 		handleMessage(ctx, update)
 	}
 }
 
 func main() {
-	fmt.Println("stable-diffusion-telegram-bot starting...")
+	if err := paramsInit(); err != nil {
+		slog.Error("can't init params", "error", err)
+		os.Exit(1)
+	}
+	params := getParams()
+	logInit(params.LogLevel, params.LogJSON)
+	logAddSecret(params.BotToken)
 
-	if err := params.Init(); err != nil {
-		fmt.Println("error:", err)
+	if err := localeOverrides.Load(params.LangOverridesFile); err != nil {
+		slog.Error("can't load language overrides", "error", err)
 		os.Exit(1)
 	}
+	if err := outputDefaults.Load(params.OutputDefaultsFile); err != nil {
+		slog.Error("can't load output defaults", "error", err)
+		os.Exit(1)
+	}
+
+	slog.Info("stable-diffusion-telegram-bot starting...")
 
 	var cancel context.CancelFunc
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
+	if params.SDMock {
+		slog.Info("using mock stable diffusion backend")
+		sdAPI = newSDAPIMock(params.SDMockParams)
+	} else {
+		sdAPI = &sdAPIType{}
+	}
+
 	if params.SDStart && !params.DelayedSDStart {
 		if err := startStableDiffusionIfNeeded(ctx); err != nil {
 			panic(err.Error())
 		}
 	}
 
+	metrics.StartServer(params.MetricsAddr)
+
 	reqQueue.Init(ctx)
 
 	opts := []bot.Option{
@@ -208,17 +318,23 @@ func main() {
 		panic(fmt.Sprint("can't init telegram bot: ", err))
 	}
 
-	verStr, _ := versionCheckGetStr(ctx)
-	sendTextToAdmins(ctx, "🤖 Bot started, "+verStr)
+	go paramsWatch(ctx)
 
-	go func() {
-		for {
-			time.Sleep(24 * time.Hour)
-			if s, updateNeededOrError := versionCheckGetStr(ctx); updateNeededOrError {
-				sendTextToAdmins(ctx, s)
+	if params.SDMock {
+		sendTextToAdmins(ctx, "🤖 Bot started, using mock Stable Diffusion backend")
+	} else {
+		verStr, _ := versionCheckGetStr(ctx)
+		sendTextToAdmins(ctx, "🤖 Bot started, "+verStr)
+
+		go func() {
+			for {
+				time.Sleep(24 * time.Hour)
+				if s, updateNeededOrError := versionCheckGetStr(ctx); updateNeededOrError {
+					sendTextToAdmins(ctx, s)
+				}
 			}
-		}
-	}()
+		}()
+	}
 
 	telegramBot.Start(ctx)
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+// Sets the params to the defaults of the settings for the duration of the test. The modify
+// function can change them before they are stored.
+func testSetParams(t *testing.T, modify func(p *paramsType)) *paramsType {
+	t.Helper()
+	p := &paramsType{}
+	for _, s := range paramsSettings {
+		if s.def == "" {
+			continue
+		}
+		if err := s.set(p, s.def); err != nil {
+			t.Fatalf("can't set default of %s: %v", s.name, err)
+		}
+	}
+	if modify != nil {
+		modify(p)
+	}
+
+	prev := paramsValue.Load()
+	paramsValue.Store(p)
+	t.Cleanup(func() { paramsValue.Store(prev) })
+	return p
+}
+
+// Sets the mock Stable Diffusion backend for the duration of the test.
+func testSetSDAPIMock(t *testing.T, p sdAPIMockParams) *sdAPIMockType {
+	t.Helper()
+	mock := newSDAPIMock(p)
+	prev := sdAPI
+	sdAPI = mock
+	t.Cleanup(func() { sdAPI = prev })
+	return mock
+}
+
+type testTelegramCall struct {
+	method string
+	values url.Values
+}
+
+// A fake Telegram Bot API server which records the calls and answers them with successful results.
+type testTelegramServer struct {
+	mutex     sync.Mutex
+	calls     []testTelegramCall
+	lastMsgID int
+}
+
+// Starts a fake Telegram server and sets the bot to use it for the duration of the test.
+func testStartTelegram(t *testing.T) *testTelegramServer {
+	t.Helper()
+	s := &testTelegramServer{lastMsgID: 1000}
+	srv := httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(srv.Close)
+
+	b, err := bot.New("test:token", bot.WithServerURL(srv.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := telegramBot
+	telegramBot = b
+	t.Cleanup(func() { telegramBot = prev })
+	return s
+}
+
+func (s *testTelegramServer) handle(w http.ResponseWriter, r *http.Request) {
+	method := path.Base(r.URL.Path)
+	_ = r.ParseMultipartForm(64 << 20)
+	values := url.Values{}
+	if r.MultipartForm != nil {
+		for k, v := range r.MultipartForm.Value {
+			values[k] = v
+		}
+	}
+
+	s.mutex.Lock()
+	s.calls = append(s.calls, testTelegramCall{method: method, values: values})
+	s.lastMsgID++
+	msgID := s.lastMsgID
+	s.mutex.Unlock()
+
+	msg := map[string]interface{}{
+		"message_id": msgID,
+		"date":       time.Now().Unix(),
+		"chat":       map[string]interface{}{"id": 1},
+		"text":       values.Get("text"),
+	}
+	var result interface{} = msg
+	switch method {
+	case "deleteMessage", "answerCallbackQuery":
+		result = true
+	case "sendMediaGroup":
+		result = []interface{}{msg}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+// Returns the form values of the calls of the given method.
+func (s *testTelegramServer) callsOf(method string) (res []url.Values) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range s.calls {
+		if c.method == method {
+			res = append(res, c.values)
+		}
+	}
+	return
+}
+
+// Returns true if a message has been sent or edited with a text containing the given string.
+func (s *testTelegramServer) gotText(substr string) bool {
+	for _, method := range []string{"sendMessage", "editMessageText"} {
+		for _, v := range s.callsOf(method) {
+			if strings.Contains(v.Get("text"), substr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Waits until the condition gets true, fails the test on timeout.
+func testWaitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(fmt.Sprint("timeout waiting for ", what))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Returns a context which is canceled at the end of the test.
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return ctx
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "sdbot"
+
+// Request outcomes used as the outcome label of the request counter.
+const (
+	metricsOutcomeDone     = "done"
+	metricsOutcomeError    = "error"
+	metricsOutcomeCanceled = "canceled"
+	metricsOutcomeTimeout  = "timeout"
+)
+
+var metricsDurationBuckets = []float64{1, 2, 5, 10, 20, 30, 45, 60, 90, 120, 180, 300, 600}
+
+type metricsType struct {
+	queueLength prometheus.Gauge
+
+	renderDuration  *prometheus.HistogramVec
+	upscaleDuration *prometheus.HistogramVec
+
+	requests     *prometheus.CounterVec
+	userRequests *prometheus.CounterVec
+
+	telegramAPIErrors      *prometheus.CounterVec
+	telegramRateLimitWaits prometheus.Counter
+	telegramRateLimitWait  prometheus.Counter
+
+	sdStartAttempts prometheus.Counter
+	sdStartFailures prometheus.Counter
+}
+
+var metrics = metricsType{
+	queueLength: promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_length",
+		Help:      "Number of requests in the queue, including the one currently processed.",
+	}),
+	renderDuration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "render_duration_seconds",
+		Help:      "Duration of renders.",
+		Buckets:   metricsDurationBuckets,
+	}, []string{"model", "sampler", "resolution"}),
+	upscaleDuration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "upscale_duration_seconds",
+		Help:      "Duration of upscales.",
+		Buckets:   metricsDurationBuckets,
+	}, []string{"upscaler", "scale"}),
+	requests: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_total",
+		Help:      "Number of processed requests by type and outcome.",
+	}, []string{"type", "outcome"}),
+	userRequests: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "user_requests_total",
+		Help:      "Number of queued requests by user ID.",
+	}, []string{"user_id"}),
+	telegramAPIErrors: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "telegram_api_errors_total",
+		Help:      "Number of failed Telegram API calls by method.",
+	}, []string{"method"}),
+	telegramRateLimitWaits: promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "telegram_rate_limit_waits_total",
+		Help:      "Number of times a Telegram API call returned retry_after (HTTP 429).",
+	}),
+	telegramRateLimitWait: promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "telegram_rate_limit_wait_seconds_total",
+		Help:      "Total time spent waiting because of Telegram API rate limits.",
+	}),
+	sdStartAttempts: promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sd_start_attempts_total",
+		Help:      "Number of Stable Diffusion WebUI start attempts.",
+	}),
+	sdStartFailures: promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sd_start_failures_total",
+		Help:      "Number of failed Stable Diffusion WebUI start attempts.",
+	}),
+}
+
+func (m *metricsType) SetQueueLength(l int) {
+	m.queueLength.Set(float64(l))
+}
+
+func (m *metricsType) ObserveRender(p ReqParamsRender, d time.Duration) {
+	m.renderDuration.WithLabelValues(p.ModelName, p.SamplerName, fmt.Sprintf("%dx%d", p.Width, p.Height)).Observe(d.Seconds())
+}
+
+func (m *metricsType) ObserveUpscale(p ReqParamsUpscale, d time.Duration) {
+	m.upscaleDuration.WithLabelValues(p.Upscaler, fmt.Sprint(p.Scale)).Observe(d.Seconds())
+}
+
+func (m *metricsType) IncRequest(t ReqType, outcome string) {
+	m.requests.WithLabelValues(t.String(), outcome).Inc()
+}
+
+func (m *metricsType) IncUserRequest(userID int64) {
+	m.userRequests.WithLabelValues(strconv.FormatInt(userID, 10)).Inc()
+}
+
+func (m *metricsType) IncTelegramAPIError(method string) {
+	m.telegramAPIErrors.WithLabelValues(method).Inc()
+}
+
+func (m *metricsType) AddTelegramRateLimitWait(d time.Duration) {
+	m.telegramRateLimitWaits.Inc()
+	m.telegramRateLimitWait.Add(d.Seconds())
+}
+
+func (m *metricsType) IncSDStartAttempt() {
+	m.sdStartAttempts.Inc()
+}
+
+func (m *metricsType) IncSDStartFailure() {
+	m.sdStartFailures.Inc()
+}
+
+// Starts the HTTP server which serves /metrics. Does nothing if addr is empty.
+func (m *metricsType) StartServer(addr string) {
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		slog.Info("metrics server listening", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("metrics server error", "error", err)
+		}
+	}()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// Render defaults for models matching the given name or glob pattern (for ex. "*turbo*").
+type paramsModelProfileType struct {
+	Match string `yaml:"match"`
+
+	Width          int     `yaml:"width"`
+	Height         int     `yaml:"height"`
+	Sampler        string  `yaml:"sampler"`
+	Steps          int     `yaml:"steps"`
+	CFGScale       float32 `yaml:"cfg"`
+	ClipSkip       int     `yaml:"clip_skip"`
+	VAE            string  `yaml:"vae"`
+	NegativePrompt string  `yaml:"negative_prompt"`
+
+	Refiner         string  `yaml:"refiner"`
+	RefinerSwitchAt float32 `yaml:"refiner_switch_at"`
+
+	HRScale             float32 `yaml:"hr_scale"`
+	HRDenoisingStrength float32 `yaml:"hr_denoisestrength"`
+	HRUpscaler          string  `yaml:"hr_upscaler"`
+	HRSecondPassSteps   int     `yaml:"hr_steps"`
+}
+
+func (m *paramsModelProfileType) matches(modelName string) bool {
+	pattern := strings.ToLower(m.Match)
+	modelName = strings.ToLower(modelName)
+	if pattern == modelName {
+		return true
+	}
+	matched, _ := path.Match(pattern, modelName)
+	return matched
+}
+
+func (m *paramsModelProfileType) validate() error {
+	if m.Match == "" {
+		return fmt.Errorf("model profile has no match pattern")
+	}
+	if _, err := path.Match(m.Match, ""); err != nil {
+		return fmt.Errorf("model profile %s has invalid match pattern", m.Match)
+	}
+	if m.Width < 0 || m.Height < 0 || m.Steps < 0 || m.CFGScale < 0 || m.ClipSkip < 0 ||
+		m.HRScale < 0 || m.HRDenoisingStrength < 0 || m.HRSecondPassSteps < 0 || m.RefinerSwitchAt < 0 || m.RefinerSwitchAt > 1 {
+		return fmt.Errorf("model profile %s has invalid settings", m.Match)
+	}
+	return nil
+}
+
+// Sets the render params which are set in the profile and were not set explicitly by the user.
+func (m *paramsModelProfileType) apply(r *ReqParamsRender, gotAttrs map[string]bool) {
+	if m.Width > 0 && !gotAttrs["width"] {
+		r.Width = m.Width
+	}
+	if m.Height > 0 && !gotAttrs["height"] {
+		r.Height = m.Height
+	}
+	if m.Sampler != "" && !gotAttrs["sampler"] {
+		r.SamplerName = m.Sampler
+	}
+	if m.Steps > 0 && !gotAttrs["steps"] {
+		r.Steps = m.Steps
+	}
+	if m.CFGScale > 0 && !gotAttrs["cfg"] {
+		r.CFGScale = m.CFGScale
+	}
+	// Settings set with -set or the setting shortcuts override the profile.
+	if _, ok := r.Settings[reqParamsSettingShortcuts["clipskip"]]; m.ClipSkip > 0 && !ok {
+		r.setSetting(reqParamsSettingShortcuts["clipskip"], m.ClipSkip)
+	}
+	if _, ok := r.Settings[reqParamsSettingShortcuts["vae"]]; m.VAE != "" && !ok {
+		r.setSetting(reqParamsSettingShortcuts["vae"], m.VAE)
+	}
+	if m.Refiner != "" && !gotAttrs["refiner"] {
+		r.Refiner = m.Refiner
+	}
+	if m.RefinerSwitchAt > 0 && !gotAttrs["refiner-at"] {
+		r.RefinerSwitchAt = m.RefinerSwitchAt
+	}
+	if m.HRScale > 0 && !gotAttrs["hr"] {
+		r.HR.Scale = m.HRScale
+	}
+	if m.HRDenoisingStrength > 0 && !gotAttrs["hr-denoisestrength"] {
+		r.HR.DenoisingStrength = m.HRDenoisingStrength
+	}
+	if m.HRUpscaler != "" && !gotAttrs["hr-upscaler"] {
+		r.HR.Upscaler = m.HRUpscaler
+	}
+	if m.HRSecondPassSteps > 0 && !gotAttrs["hr-steps"] {
+		r.HR.SecondPassSteps = m.HRSecondPassSteps
+	}
+}
+
+// Returns the name of the model the render will use. Without a model set, the render uses the
+// model which is loaded in the WebUI. Returns an empty string if that can't be queried, for
+// example when the WebUI is not started yet.
+func modelProfileRenderModel(ctx context.Context, modelName string) string {
+	if modelName != "" {
+		return modelName
+	}
+	if modelName = reqQueue.LoadedModel(); modelName != "" {
+		return modelName
+	}
+	modelName, err := sdAPI.GetLoadedModel(ctx)
+	if err != nil {
+		slog.Warn("can't get loaded model for the model profile", "error", err)
+		return ""
+	}
+	return modelName
+}
+
+// Returns the first model profile matching the given model name, or nil if there's no matching profile.
+func (p *paramsType) ModelProfile(modelName string) *paramsModelProfileType {
+	for i := range p.ModelProfiles {
+		if p.ModelProfiles[i].matches(modelName) {
+			return &p.ModelProfiles[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestModelProfileApply(t *testing.T) {
+	testSetParams(t, func(p *paramsType) {
+		p.DefaultModel = ""
+		p.ModelProfiles = []paramsModelProfileType{
+			{Match: "*sdxl*", Width: 1024, Height: 1024, Steps: 30, NegativePrompt: "blurry"},
+		}
+	})
+	testSetSDAPIMock(t, sdAPIMockParams{Models: []string{"mock-sdxl", "mock-sd15"}})
+
+	tests := []struct {
+		name       string
+		s          string
+		modelName  string
+		groupAttrs map[string]bool
+		steps      int
+		width      int
+		profile    bool
+	}{
+		// Without a model set the profile of the loaded model is used.
+		{name: "loaded model", s: "a cat", steps: 30, width: 1024, profile: true},
+		{name: "other model", s: "a cat", modelName: "mock-sd15", steps: 20, width: 512},
+		{name: "user param", s: "a cat -t 10", steps: 10, width: 1024, profile: true},
+		{name: "group default", s: "a cat", groupAttrs: map[string]bool{"steps": true}, steps: 20, width: 1024, profile: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := testDefaultRenderParams()
+			r.ModelName = test.modelName
+			r.groupAttrs = test.groupAttrs
+			if _, err := ReqParamsParse(context.Background(), test.s, &r); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if r.Steps != test.steps || r.Width != test.width {
+				t.Errorf("got steps %d and width %d, expected %d and %d", r.Steps, r.Width, test.steps, test.width)
+			}
+			if (r.profile != nil) != test.profile {
+				t.Errorf("got profile %v", r.profile)
+			}
+		})
+	}
+}
+
+func TestModelProfileApplySettings(t *testing.T) {
+	testSetParams(t, func(p *paramsType) {
+		p.ModelProfiles = []paramsModelProfileType{{Match: "mock-sd15", ClipSkip: 2, VAE: "mock-vae"}}
+	})
+	testSetSDAPIMock(t, sdAPIMockParams{})
+
+	r := testDefaultRenderParams()
+	if _, err := ReqParamsParse(context.Background(), "a cat -clipskip 3", &r); err != nil {
+		t.Fatal(err)
+	}
+	// The setting shortcut overrides the profile, the profile's VAE is kept.
+	if r.Settings["CLIP_stop_at_last_layers"] != 3 || r.Settings["sd_vae"] != "mock-vae" {
+		t.Errorf("got settings %v", r.Settings)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/png"
+	"math/rand"
+)
+
+// Stable Diffusion renders images with sizes which are multiples of 8.
+const outpaintSizeStep = 8
+
+// If the pass count is not set, then a pass extends a side with at most this many pixels.
+const outpaintMaxPassExtension = 256
+
+// The mask reaches into the original image with this many pixels, so the seam gets repainted.
+const outpaintMaskOverlap = 16
+
+// The max. difference of the noise fill from the edge colour, per colour channel.
+const outpaintNoiseAmplitude = 48
+
+type outpaintExtension struct {
+	left, right, top, bottom int
+}
+
+// Returns the number of inpainting passes. Large extensions are split to multiple passes, so each
+// pass has enough context from the image.
+func (r ReqParamsOutpaint) passCount() int {
+	if r.Passes > 0 {
+		return r.Passes
+	}
+	maxExtension := max(r.Left, r.Right, r.Top, r.Bottom)
+	return max((maxExtension+outpaintMaxPassExtension-1)/outpaintMaxPassExtension, 1)
+}
+
+// Splits the extension evenly to the passes. Each pass extends the sides with multiples of 8 pixels,
+// passes which wouldn't extend anything are omitted.
+func (r ReqParamsOutpaint) passExtensions() (res []outpaintExtension) {
+	n := r.passCount()
+	split := func(total, pass int) int {
+		// Extension done until the given pass, rounded up to the size step.
+		done := func(pass int) int {
+			return min((total*pass/n+outpaintSizeStep-1)/outpaintSizeStep*outpaintSizeStep, total)
+		}
+		return done(pass+1) - done(pass)
+	}
+	for i := 0; i < n; i++ {
+		e := outpaintExtension{
+			left:   split(r.Left, i),
+			right:  split(r.Right, i),
+			top:    split(r.Top, i),
+			bottom: split(r.Bottom, i),
+		}
+		if e != (outpaintExtension{}) {
+			res = append(res, e)
+		}
+	}
+	return
+}
+
+// Returns the enlarged canvas with the new area filled using the given fill mode, and the inpainting
+// mask which is white where the canvas should be painted. If the size of the image is not a multiple
+// of 8, then it gets cropped at the right and the bottom.
+func outpaintPrepare(src image.Image, e outpaintExtension, fill string, seed int64) (canvas *image.RGBA, mask *image.Gray, err error) {
+	b := src.Bounds()
+	srcWidth := b.Dx() / outpaintSizeStep * outpaintSizeStep
+	srcHeight := b.Dy() / outpaintSizeStep * outpaintSizeStep
+	if srcWidth == 0 || srcHeight == 0 {
+		return nil, nil, newLocaleError(localeMsgOutpaintImageTooSmall)
+	}
+
+	orig := image.NewRGBA(image.Rect(0, 0, srcWidth, srcHeight))
+	draw.Draw(orig, orig.Bounds(), src, b.Min, draw.Src)
+
+	width := srcWidth + e.left + e.right
+	height := srcHeight + e.top + e.bottom
+	origRect := image.Rect(e.left, e.top, e.left+srcWidth, e.top+srcHeight)
+
+	// The new area gets the colour of the nearest edge pixel, so the inpainting has a good start.
+	rnd := rand.New(rand.NewSource(seed))
+	canvas = image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy := min(max(y-e.top, 0), srcHeight-1)
+		for x := 0; x < width; x++ {
+			sx := min(max(x-e.left, 0), srcWidth-1)
+			c := orig.RGBAAt(sx, sy)
+			if fill == "noise" && !(image.Point{x, y}).In(origRect) {
+				c.R = outpaintAddNoise(c.R, rnd)
+				c.G = outpaintAddNoise(c.G, rnd)
+				c.B = outpaintAddNoise(c.B, rnd)
+			}
+			canvas.SetRGBA(x, y, c)
+		}
+	}
+
+	// The kept area is the original image without the overlap at the extended sides.
+	overlap := min(outpaintMaskOverlap, srcWidth/4, srcHeight/4)
+	keepRect := origRect
+	if e.left > 0 {
+		keepRect.Min.X += overlap
+	}
+	if e.right > 0 {
+		keepRect.Max.X -= overlap
+	}
+	if e.top > 0 {
+		keepRect.Min.Y += overlap
+	}
+	if e.bottom > 0 {
+		keepRect.Max.Y -= overlap
+	}
+	mask = image.NewGray(canvas.Bounds())
+	draw.Draw(mask, mask.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(mask, keepRect, image.Black, image.Point{}, draw.Src)
+	return canvas, mask, nil
+}
+
+func outpaintAddNoise(v uint8, rnd *rand.Rand) uint8 {
+	return uint8(min(max(int(v)+rnd.Intn(2*outpaintNoiseAmplitude+1)-outpaintNoiseAmplitude, 0), 255))
+}
+
+func outpaintEncodePNG(img image.Image) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestOutpaintPassExtensions(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   ReqParamsOutpaint
+		expected []outpaintExtension
+	}{
+		{
+			name:     "one pass",
+			params:   ReqParamsOutpaint{Left: 128, Bottom: 256},
+			expected: []outpaintExtension{{left: 128, bottom: 256}},
+		},
+		{
+			name:     "split to passes by the max. extension",
+			params:   ReqParamsOutpaint{Left: 600},
+			expected: []outpaintExtension{{left: 200}, {left: 200}, {left: 200}},
+		},
+		{
+			name:     "rounded to the size step",
+			params:   ReqParamsOutpaint{Left: 100, Right: 300},
+			expected: []outpaintExtension{{left: 56, right: 152}, {left: 44, right: 148}},
+		},
+		{
+			name:     "empty passes are omitted",
+			params:   ReqParamsOutpaint{Top: 8, Passes: 4},
+			expected: []outpaintExtension{{top: 8}},
+		},
+		{
+			name:   "no extension",
+			params: ReqParamsOutpaint{},
+		},
+	}
+	for _, test := range tests {
+		if res := test.params.passExtensions(); !reflect.DeepEqual(res, test.expected) {
+			t.Errorf("%s: got %+v, expected %+v", test.name, res, test.expected)
+		}
+	}
+}
+
+func TestOutpaintPrepare(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 70, 64)) // Width gets cropped to 64.
+	canvas, mask, err := outpaintPrepare(src, outpaintExtension{left: 32, bottom: 16}, "noise", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canvas.Bounds() != image.Rect(0, 0, 96, 80) || mask.Bounds() != canvas.Bounds() {
+		t.Fatalf("unexpected canvas size %v, mask size %v", canvas.Bounds(), mask.Bounds())
+	}
+	// The extension and the overlap are painted, the rest of the image is kept.
+	if mask.GrayAt(0, 0).Y != 255 || mask.GrayAt(32+outpaintMaskOverlap-1, 0).Y != 255 {
+		t.Error("the extended area should be painted")
+	}
+	if mask.GrayAt(95, 0).Y != 0 || mask.GrayAt(32+outpaintMaskOverlap, 64-outpaintMaskOverlap-1).Y != 0 {
+		t.Error("the original area should be kept")
+	}
+
+	if _, _, err = outpaintPrepare(image.NewRGBA(image.Rect(0, 0, 4, 4)), outpaintExtension{left: 8}, "edge", 1); err == nil {
+		t.Error("expected an error for a too small image")
+	}
+}
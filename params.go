@@ -1,182 +1,525 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
 )
 
+// Settings which can be overridden for a Telegram group in the config file.
+type paramsGroupType struct {
+	DefaultModel      string  `yaml:"default_model"`
+	DefaultSampler    string  `yaml:"default_sampler"`
+	DefaultWidth      int     `yaml:"default_width"`
+	DefaultHeight     int     `yaml:"default_height"`
+	DefaultSteps      int     `yaml:"default_steps"`
+	DefaultNumOutputs int     `yaml:"default_outcnt"`
+	DefaultCFGScale   float32 `yaml:"default_cfg"`
+	// If set, then multiple output images are sent composed to one contact sheet.
+	DefaultGrid *bool `yaml:"default_grid"`
+
+	// Language of the bot's replies in the group, if not overridden with the /sdlang command.
+	Language string `yaml:"language"`
+
+	Limits        paramsLimitsType        `yaml:"limits"`
+	ContentPolicy paramsContentPolicyType `yaml:"content_policy"`
+}
+
 type paramsType struct {
-	BotToken                 string
-	StableDiffusionPath      string
-	StableDiffusionWebUIPath string
-
-	AllowedUserIDs  []int64
-	AdminUserIDs    []int64
-	AllowedGroupIDs []int64
-
-	SDStart           bool
-	DelayedSDStart    bool
-	DefaultModel      string
-	DefaultSampler    string
-	DefaultWidth      int
-	DefaultHeight     int
-	DefaultWidthSDXL  int
-	DefaultHeightSDXL int
-}
-
-var params paramsType
-
-func (p *paramsType) Init() error {
-	flag.StringVar(&p.BotToken, "bot-token", "", "telegram bot token")
-	flag.StringVar(&p.StableDiffusionPath, "sd-path", "", "path of the stable diffusion directory")
-	flag.StringVar(&p.StableDiffusionWebUIPath, "sd-webui-path", "", "path of the stable diffusion webui start script")
-	var allowedUserIDs string
-	flag.StringVar(&allowedUserIDs, "allowed-user-ids", "", "allowed telegram user ids")
-	var adminUserIDs string
-	flag.StringVar(&adminUserIDs, "admin-user-ids", "", "admin telegram user ids")
-	var allowedGroupIDs string
-	flag.StringVar(&allowedGroupIDs, "allowed-group-ids", "", "allowed telegram group ids")
-	flag.BoolVar(&p.SDStart, "sd-start", true, "start stable diffusion if needed")
-	flag.BoolVar(&p.DelayedSDStart, "delayed-sd-start", false, "start stable diffusion only when the first prompt arrives")
-	flag.StringVar(&p.DefaultModel, "default-model", "", "default model name")
-	flag.StringVar(&p.DefaultSampler, "default-sampler", "", "default sampler name")
-	flag.IntVar(&p.DefaultWidth, "default-width", 512, "default image width")
-	flag.IntVar(&p.DefaultHeight, "default-height", 512, "default image height")
-	flag.IntVar(&p.DefaultWidthSDXL, "default-width-sdxl", 1024, "default image width for SDXL models")
-	flag.IntVar(&p.DefaultHeightSDXL, "default-height-sdxl", 1024, "default image height for SDXL models")
-	flag.Parse()
+	BotToken                 string `yaml:"bot_token"`
+	StableDiffusionPath      string `yaml:"sd_path"`
+	StableDiffusionWebUIPath string `yaml:"sd_webui_path"`
 
-	if p.BotToken == "" {
-		p.BotToken = os.Getenv("BOT_TOKEN")
-	}
-	if p.BotToken == "" {
-		return fmt.Errorf("bot token not set")
-	}
+	AllowedUserIDs  []int64 `yaml:"allowed_user_ids"`
+	AdminUserIDs    []int64 `yaml:"admin_user_ids"`
+	AllowedGroupIDs []int64 `yaml:"allowed_group_ids"`
 
-	if p.StableDiffusionPath == "" {
-		p.StableDiffusionPath = os.Getenv("STABLE_DIFFUSION_PATH")
-	}
-	if p.StableDiffusionPath == "" {
-		return fmt.Errorf("stable diffusion path not set")
-	}
-	if p.StableDiffusionWebUIPath == "" {
-		p.StableDiffusionWebUIPath = os.Getenv("STABLE_DIFFUSION_WEBUI_PATH")
-	}
-	if p.StableDiffusionWebUIPath == "" {
-		return fmt.Errorf("stable diffusion webui path not set")
-	}
+	SDStart           bool    `yaml:"sd_start"`
+	DelayedSDStart    bool    `yaml:"delayed_sd_start"`
+	DefaultModel      string  `yaml:"default_model"`
+	DefaultSampler    string  `yaml:"default_sampler"`
+	DefaultWidth      int     `yaml:"default_width"`
+	DefaultHeight     int     `yaml:"default_height"`
+	DefaultWidthSDXL  int     `yaml:"default_width_sdxl"`
+	DefaultHeightSDXL int     `yaml:"default_height_sdxl"`
+	DefaultSteps      int     `yaml:"default_steps"`
+	DefaultNumOutputs int     `yaml:"default_outcnt"`
+	DefaultCFGScale   float32 `yaml:"default_cfg"`
+	DefaultGrid       bool    `yaml:"default_grid"`
+
+	// Used if the Telegram user's language is not available.
+	DefaultLanguage string `yaml:"default_lang"`
+	// Language overrides set with the /sdlang command are saved to this file.
+	LangOverridesFile string `yaml:"lang_overrides_file"`
+
+	MetricsAddr string `yaml:"metrics_addr"`
+	// If true, then requests are also counted per user ID.
+	MetricsPerUser bool `yaml:"metrics_per_user"`
+
+	// Waiting renders using the loaded model are processed first, but a request can only be
+	// skipped this many times. Zero disables model batching.
+	ModelBatchingMaxSkips int `yaml:"model_batching_max_skips"`
 
-	if allowedUserIDs == "" {
-		allowedUserIDs = os.Getenv("ALLOWED_USERIDS")
+	// Animations are encoded to MP4 and images to WebP and AVIF with this ffmpeg binary. If empty,
+	// then GIFs are sent and only the JPEG and PNG output formats are available.
+	FFmpegPath string `yaml:"ffmpeg_path"`
+
+	// Default output formats set by users are stored in this file.
+	OutputDefaultsFile string `yaml:"output_defaults_file"`
+
+	// WebUI settings which users can override with render params.
+	OverrideSettingsAllowlist []string `yaml:"override_settings_allowlist"`
+
+	// Images rendered for inline queries are uploaded to this chat first. If zero then the
+	// querying user's private chat with the bot is used.
+	InlineUploadChatID int64 `yaml:"inline_upload_chat_id"`
+
+	LogLevel slog.Level `yaml:"log_level"`
+	LogJSON  bool       `yaml:"log_json"`
+
+	SDMock       bool            `yaml:"sd_mock"`
+	SDMockParams sdAPIMockParams `yaml:",inline"`
+
+	// Per group settings, the key is the group's chat ID.
+	Groups map[int64]paramsGroupType `yaml:"groups"`
+
+	// Per model render defaults, the first matching profile is used.
+	ModelProfiles []paramsModelProfileType `yaml:"model_profiles"`
+
+	Limits      paramsLimitsType `yaml:"limits"`
+	AdminLimits paramsLimitsType `yaml:"admin_limits"`
+
+	ContentPolicy paramsContentPolicyType `yaml:"content_policy"`
+}
+
+// A setting which can be set from the command line, an environment variable and the config file.
+type paramsSetting struct {
+	name   string // Command line argument name.
+	env    string // Environment variable name.
+	def    string // Default value.
+	usage  string
+	isBool bool
+	set    func(p *paramsType, v string) error
+}
+
+func paramsParseBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "true", "1", "yes":
+		return true, nil
+	case "false", "0", "no":
+		return false, nil
 	}
-	sa := strings.Split(allowedUserIDs, ",")
-	for _, idStr := range sa {
-		if idStr == "" {
-			continue
-		}
+	return false, fmt.Errorf("invalid bool value: %s", v)
+}
+
+func paramsParseIDs(v string) (ids []int64, err error) {
+	for _, idStr := range splitNonEmpty(v) {
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			return fmt.Errorf("allowed user ids contains invalid user ID: " + idStr)
+			return nil, fmt.Errorf("invalid ID: " + idStr)
 		}
-		p.AllowedUserIDs = append(p.AllowedUserIDs, id)
+		ids = append(ids, id)
 	}
+	return
+}
 
-	if adminUserIDs == "" {
-		adminUserIDs = os.Getenv("ADMIN_USERIDS")
-	}
-	sa = strings.Split(adminUserIDs, ",")
-	for _, idStr := range sa {
-		if idStr == "" {
-			continue
+func paramsStringSetting(name, env, def, usage string, dst func(p *paramsType) *string) paramsSetting {
+	return paramsSetting{name: name, env: env, def: def, usage: usage, set: func(p *paramsType, v string) error {
+		*dst(p) = v
+		return nil
+	}}
+}
+
+func paramsBoolSetting(name, env, def, usage string, dst func(p *paramsType) *bool) paramsSetting {
+	return paramsSetting{name: name, env: env, def: def, usage: usage, isBool: true, set: func(p *paramsType, v string) error {
+		val, err := paramsParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", usage, err)
 		}
-		id, err := strconv.ParseInt(idStr, 10, 64)
+		*dst(p) = val
+		return nil
+	}}
+}
+
+func paramsIntSetting(name, env, def, usage string, dst func(p *paramsType) *int) paramsSetting {
+	return paramsSetting{name: name, env: env, def: def, usage: usage, set: func(p *paramsType, v string) error {
+		val, err := strconv.Atoi(v)
 		if err != nil {
-			return fmt.Errorf("admin ids contains invalid user ID: " + idStr)
+			return fmt.Errorf("invalid %s", usage)
 		}
-		p.AdminUserIDs = append(p.AdminUserIDs, id)
-		if !slices.Contains(p.AllowedUserIDs, id) {
-			p.AllowedUserIDs = append(p.AllowedUserIDs, id)
+		*dst(p) = val
+		return nil
+	}}
+}
+
+func paramsFloatSetting(name, env, def, usage string, dst func(p *paramsType) *float32) paramsSetting {
+	return paramsSetting{name: name, env: env, def: def, usage: usage, set: func(p *paramsType, v string) error {
+		val, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return fmt.Errorf("invalid %s", usage)
 		}
+		*dst(p) = float32(val)
+		return nil
+	}}
+}
+
+func paramsIDsSetting(name, env, usage string, dst func(p *paramsType) *[]int64) paramsSetting {
+	return paramsSetting{name: name, env: env, usage: usage, set: func(p *paramsType, v string) error {
+		ids, err := paramsParseIDs(v)
+		if err != nil {
+			return fmt.Errorf("%s contains %w", usage, err)
+		}
+		*dst(p) = ids
+		return nil
+	}}
+}
+
+func paramsStringListSetting(name, env, def, usage string, dst func(p *paramsType) *[]string) paramsSetting {
+	return paramsSetting{name: name, env: env, def: def, usage: usage, set: func(p *paramsType, v string) error {
+		*dst(p) = splitNonEmpty(v)
+		return nil
+	}}
+}
+
+var paramsSettings = []paramsSetting{
+	paramsStringSetting("bot-token", "BOT_TOKEN", "", "telegram bot token", func(p *paramsType) *string { return &p.BotToken }),
+	paramsStringSetting("sd-path", "STABLE_DIFFUSION_PATH", "", "path of the stable diffusion directory",
+		func(p *paramsType) *string { return &p.StableDiffusionPath }),
+	paramsStringSetting("sd-webui-path", "STABLE_DIFFUSION_WEBUI_PATH", "", "path of the stable diffusion webui start script",
+		func(p *paramsType) *string { return &p.StableDiffusionWebUIPath }),
+	paramsIDsSetting("allowed-user-ids", "ALLOWED_USERIDS", "allowed telegram user ids", func(p *paramsType) *[]int64 { return &p.AllowedUserIDs }),
+	paramsIDsSetting("admin-user-ids", "ADMIN_USERIDS", "admin telegram user ids", func(p *paramsType) *[]int64 { return &p.AdminUserIDs }),
+	paramsIDsSetting("allowed-group-ids", "ALLOWED_GROUPIDS", "allowed telegram group ids", func(p *paramsType) *[]int64 { return &p.AllowedGroupIDs }),
+	paramsBoolSetting("sd-start", "SD_START", "true", "start stable diffusion if needed", func(p *paramsType) *bool { return &p.SDStart }),
+	paramsBoolSetting("delayed-sd-start", "DELAYED_SD_START", "false", "start stable diffusion only when the first prompt arrives",
+		func(p *paramsType) *bool { return &p.DelayedSDStart }),
+	paramsStringSetting("default-model", "DEFAULT_MODEL", "", "default model name", func(p *paramsType) *string { return &p.DefaultModel }),
+	paramsStringSetting("default-sampler", "DEFAULT_SAMPLER", "", "default sampler name", func(p *paramsType) *string { return &p.DefaultSampler }),
+	paramsIntSetting("default-width", "DEFAULT_WIDTH", "512", "default image width", func(p *paramsType) *int { return &p.DefaultWidth }),
+	paramsIntSetting("default-height", "DEFAULT_HEIGHT", "512", "default image height", func(p *paramsType) *int { return &p.DefaultHeight }),
+	paramsIntSetting("default-width-sdxl", "DEFAULT_WIDTH_SDXL", "1024", "default image width for SDXL models",
+		func(p *paramsType) *int { return &p.DefaultWidthSDXL }),
+	paramsIntSetting("default-height-sdxl", "DEFAULT_HEIGHT_SDXL", "1024", "default image height for SDXL models",
+		func(p *paramsType) *int { return &p.DefaultHeightSDXL }),
+	paramsIntSetting("default-steps", "DEFAULT_STEPS", "35", "default number of steps", func(p *paramsType) *int { return &p.DefaultSteps }),
+	paramsIntSetting("default-outcnt", "DEFAULT_OUTCNT", "4", "default count of output images", func(p *paramsType) *int { return &p.DefaultNumOutputs }),
+	paramsFloatSetting("default-cfg", "DEFAULT_CFG", "7", "default CFG scale", func(p *paramsType) *float32 { return &p.DefaultCFGScale }),
+	paramsBoolSetting("default-grid", "DEFAULT_GRID", "false", "send multiple output images composed to one contact sheet by default",
+		func(p *paramsType) *bool { return &p.DefaultGrid }),
+	paramsStringSetting("default-lang", "DEFAULT_LANG", localeDefaultCode, "default language of the bot's replies",
+		func(p *paramsType) *string { return &p.DefaultLanguage }),
+	paramsStringSetting("lang-overrides-file", "LANG_OVERRIDES_FILE", "", "path of the JSON file where language overrides are saved, kept in memory only if empty",
+		func(p *paramsType) *string { return &p.LangOverridesFile }),
+	paramsIntSetting("model-batching-max-skips", "MODEL_BATCHING_MAX_SKIPS", "0",
+		"max. number of times a queued request can be skipped to avoid model swaps, 0 disables model batching",
+		func(p *paramsType) *int { return &p.ModelBatchingMaxSkips }),
+	paramsStringSetting("ffmpeg-path", "FFMPEG_PATH", "", "path of the ffmpeg binary used for encoding animations to MP4 and images to WebP and AVIF",
+		func(p *paramsType) *string { return &p.FFmpegPath }),
+	paramsStringListSetting("override-settings-allowlist", "OVERRIDE_SETTINGS_ALLOWLIST",
+		"CLIP_stop_at_last_layers,sd_vae,eta_ancestral,eta_noise_seed_delta,k_sched_type",
+		"comma separated WebUI settings which users can override", func(p *paramsType) *[]string { return &p.OverrideSettingsAllowlist }),
+	paramsStringSetting("output-defaults-file", "OUTPUT_DEFAULTS_FILE", "", "file for storing the default output formats of users, not persisted if empty",
+		func(p *paramsType) *string { return &p.OutputDefaultsFile }),
+	paramsStringSetting("metrics-addr", "METRICS_ADDR", "", "listen address of the prometheus metrics http server (for ex. :9090), disabled if empty",
+		func(p *paramsType) *string { return &p.MetricsAddr }),
+	paramsBoolSetting("metrics-per-user", "METRICS_PER_USER", "false", "count requests per telegram user id in the prometheus metrics",
+		func(p *paramsType) *bool { return &p.MetricsPerUser }),
+	{name: "inline-upload-chat-id", env: "INLINE_UPLOAD_CHAT_ID", usage: "chat id where images of inline queries are temporarily uploaded",
+		set: func(p *paramsType, v string) error {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid inline upload chat id")
+			}
+			p.InlineUploadChatID = id
+			return nil
+		}},
+	{name: "log-level", env: "LOG_LEVEL", def: "info", usage: "log level (debug, info, warn, error)", set: func(p *paramsType, v string) (err error) {
+		p.LogLevel, err = logParseLevel(v)
+		return
+	}},
+	paramsBoolSetting("log-json", "LOG_JSON", "false", "log in JSON format", func(p *paramsType) *bool { return &p.LogJSON }),
+	paramsBoolSetting("sd-mock", "SD_MOCK", "false", "use a mock stable diffusion backend which renders placeholder images",
+		func(p *paramsType) *bool { return &p.SDMock }),
+	paramsStringListSetting("sd-mock-models", "SD_MOCK_MODELS", "", "comma separated model names of the mock backend",
+		func(p *paramsType) *[]string { return &p.SDMockParams.Models }),
+	paramsStringListSetting("sd-mock-samplers", "SD_MOCK_SAMPLERS", "", "comma separated sampler names of the mock backend",
+		func(p *paramsType) *[]string { return &p.SDMockParams.Samplers }),
+	paramsStringListSetting("sd-mock-upscalers", "SD_MOCK_UPSCALERS", "", "comma separated upscaler names of the mock backend",
+		func(p *paramsType) *[]string { return &p.SDMockParams.Upscalers }),
+	{name: "sd-mock-step-duration", env: "SD_MOCK_STEP_DURATION", def: sdAPIMockDefaultStepDuration.String(),
+		usage: "simulated duration of one render step of the mock backend", set: func(p *paramsType, v string) error {
+			val, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid mock step duration")
+			}
+			p.SDMockParams.StepDuration = val
+			return nil
+		}},
+	{name: "sd-mock-error-rate", env: "SD_MOCK_ERROR_RATE", def: "0", usage: "probability (0..1) of a mock backend request failing",
+		set: func(p *paramsType, v string) error {
+			val, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("invalid mock error rate")
+			}
+			p.SDMockParams.ErrorRate = val
+			return nil
+		}},
+}
+
+// Stores the value of a command line argument and whether it has been set.
+type paramsFlagValue struct {
+	value  string
+	isSet  bool
+	isBool bool
+}
+
+func (v *paramsFlagValue) String() string {
+	return v.value
+}
+
+func (v *paramsFlagValue) Set(s string) error {
+	v.value = s
+	v.isSet = true
+	return nil
+}
+
+func (v *paramsFlagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+var paramsFlagValues = make(map[string]*paramsFlagValue)
+var paramsConfigFile string
+
+var paramsValue atomic.Pointer[paramsType]
+
+// Returns the currently active params. The returned value must not be modified, as it is
+// replaced with a new instance when the config file gets reloaded.
+func getParams() *paramsType {
+	return paramsValue.Load()
+}
+
+func (p *paramsType) loadConfigFile(fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return fmt.Errorf("can't open config file: %w", err)
 	}
+	defer f.Close()
 
-	if allowedGroupIDs == "" {
-		allowedGroupIDs = os.Getenv("ALLOWED_GROUPIDS")
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err = dec.Decode(p); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("can't parse config file: %w", err)
 	}
-	sa = strings.Split(allowedGroupIDs, ",")
-	for _, idStr := range sa {
-		if idStr == "" {
+	return nil
+}
+
+// Loads the params with the following precedence: command line arguments, environment variables,
+// config file, defaults.
+func paramsLoad() (*paramsType, error) {
+	p := &paramsType{}
+	for _, s := range paramsSettings {
+		if s.def == "" {
 			continue
 		}
-		id, err := strconv.ParseInt(idStr, 10, 64)
-		if err != nil {
-			return fmt.Errorf("allowed group ids contains invalid group ID: " + idStr)
+		if err := s.set(p, s.def); err != nil {
+			return nil, err
 		}
-		p.AllowedGroupIDs = append(p.AllowedGroupIDs, id)
 	}
 
-	s := os.Getenv("SD_START")
-	if s != "" {
-		if s == "0" {
-			p.SDStart = false
-		} else {
-			p.SDStart = true
+	if paramsConfigFile != "" {
+		if err := p.loadConfigFile(paramsConfigFile); err != nil {
+			return nil, err
 		}
 	}
 
-	s = os.Getenv("DELAYED_SD_START")
-	if s != "" {
-		if s == "0" {
-			p.DelayedSDStart = false
-		} else {
-			p.DelayedSDStart = true
+	for _, s := range paramsSettings {
+		if v := os.Getenv(s.env); v != "" {
+			if err := s.set(p, v); err != nil {
+				return nil, err
+			}
+		}
+		if f := paramsFlagValues[s.name]; f.isSet {
+			if err := s.set(p, f.value); err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	if p.DefaultModel == "" {
-		p.DefaultModel = os.Getenv("DEFAULT_MODEL")
+	if err := p.validate(); err != nil {
+		return nil, err
 	}
+	return p, nil
+}
 
-	if p.DefaultSampler == "" {
-		p.DefaultSampler = os.Getenv("DEFAULT_SAMPLER")
+func (p *paramsType) validate() error {
+	if p.BotToken == "" {
+		return fmt.Errorf("bot token not set")
 	}
 
-	s = os.Getenv("DEFAULT_WIDTH")
-	if s != "" {
-		val, err := strconv.Atoi(s)
-		if err != nil {
-			return fmt.Errorf("invalid default width")
+	if p.SDMock {
+		p.SDStart = false
+	} else {
+		if p.StableDiffusionPath == "" {
+			return fmt.Errorf("stable diffusion path not set")
+		}
+		if p.StableDiffusionWebUIPath == "" {
+			return fmt.Errorf("stable diffusion webui path not set")
 		}
-		p.DefaultWidth = val
 	}
-	s = os.Getenv("DEFAULT_HEIGHT")
-	if s != "" {
-		val, err := strconv.Atoi(s)
-		if err != nil {
-			return fmt.Errorf("invalid default height")
+
+	for _, id := range p.AdminUserIDs {
+		if !slices.Contains(p.AllowedUserIDs, id) {
+			p.AllowedUserIDs = append(p.AllowedUserIDs, id)
 		}
-		p.DefaultHeight = val
 	}
-	s = os.Getenv("DEFAULT_WIDTH_SDXL")
-	if s != "" {
-		val, err := strconv.Atoi(s)
-		if err != nil {
-			return fmt.Errorf("invalid default width for SDXL")
+
+	if p.DefaultWidth <= 0 || p.DefaultHeight <= 0 || p.DefaultWidthSDXL <= 0 || p.DefaultHeightSDXL <= 0 {
+		return fmt.Errorf("invalid default image size")
+	}
+	if p.DefaultSteps <= 0 {
+		return fmt.Errorf("invalid default steps")
+	}
+	if p.DefaultNumOutputs <= 0 {
+		return fmt.Errorf("invalid default output count")
+	}
+	if p.ModelBatchingMaxSkips < 0 {
+		return fmt.Errorf("invalid model batching max skips")
+	}
+	if localeFind(p.DefaultLanguage) == nil {
+		return fmt.Errorf("unknown default language: %s", p.DefaultLanguage)
+	}
+	for id, g := range p.Groups {
+		if g.DefaultWidth < 0 || g.DefaultHeight < 0 || g.DefaultSteps < 0 || g.DefaultNumOutputs < 0 || g.DefaultCFGScale < 0 {
+			return fmt.Errorf("invalid settings for group %d", id)
+		}
+		if g.Language != "" && localeFind(g.Language) == nil {
+			return fmt.Errorf("group %d: unknown language: %s", id, g.Language)
+		}
+		if err := g.Limits.validate(); err != nil {
+			return fmt.Errorf("group %d: %w", id, err)
 		}
-		p.DefaultWidthSDXL = val
+		if err := g.ContentPolicy.compile(); err != nil {
+			return fmt.Errorf("group %d: %w", id, err)
+		}
+		p.Groups[id] = g
 	}
-	s = os.Getenv("DEFAULT_HEIGHT_SDXL")
-	if s != "" {
-		val, err := strconv.Atoi(s)
-		if err != nil {
-			return fmt.Errorf("invalid default height for SDXL")
+	if err := p.ContentPolicy.compile(); err != nil {
+		return err
+	}
+	if err := p.Limits.validate(); err != nil {
+		return err
+	}
+	if err := p.AdminLimits.validate(); err != nil {
+		return fmt.Errorf("admin limits: %w", err)
+	}
+	for i := range p.ModelProfiles {
+		if err := p.ModelProfiles[i].validate(); err != nil {
+			return err
 		}
-		p.DefaultHeightSDXL = val
+	}
+	return nil
+}
+
+func (p *paramsType) IsAdmin(userID int64) bool {
+	return slices.Contains(p.AdminUserIDs, userID)
+}
+
+// Returns the default render settings for the given chat, with the group's overrides applied.
+func (p *paramsType) ChatDefaults(chatID int64) paramsGroupType {
+	res := paramsGroupType{
+		DefaultModel:      p.DefaultModel,
+		DefaultSampler:    p.DefaultSampler,
+		DefaultWidth:      p.DefaultWidth,
+		DefaultHeight:     p.DefaultHeight,
+		DefaultSteps:      p.DefaultSteps,
+		DefaultNumOutputs: p.DefaultNumOutputs,
+		DefaultCFGScale:   p.DefaultCFGScale,
+		DefaultGrid:       &p.DefaultGrid,
+	}
+
+	g, ok := p.Groups[chatID]
+	if !ok {
+		return res
+	}
+	if g.DefaultModel != "" {
+		res.DefaultModel = g.DefaultModel
+	}
+	if g.DefaultSampler != "" {
+		res.DefaultSampler = g.DefaultSampler
+	}
+	if g.DefaultWidth > 0 {
+		res.DefaultWidth = g.DefaultWidth
+	}
+	if g.DefaultHeight > 0 {
+		res.DefaultHeight = g.DefaultHeight
+	}
+	if g.DefaultSteps > 0 {
+		res.DefaultSteps = g.DefaultSteps
+	}
+	if g.DefaultNumOutputs > 0 {
+		res.DefaultNumOutputs = g.DefaultNumOutputs
+	}
+	if g.DefaultCFGScale > 0 {
+		res.DefaultCFGScale = g.DefaultCFGScale
+	}
+	if g.DefaultGrid != nil {
+		res.DefaultGrid = g.DefaultGrid
+	}
+	return res
+}
+
+// Returns the render attributes which have defaults set by the given chat's group.
+func (p *paramsType) ChatDefaultAttrs(chatID int64) map[string]bool {
+	attrs := make(map[string]bool)
+	g, ok := p.Groups[chatID]
+	if !ok {
+		return attrs
+	}
+	if g.DefaultSampler != "" {
+		attrs["sampler"] = true
+	}
+	if g.DefaultWidth > 0 {
+		attrs["width"] = true
+	}
+	if g.DefaultHeight > 0 {
+		attrs["height"] = true
+	}
+	if g.DefaultSteps > 0 {
+		attrs["steps"] = true
+	}
+	if g.DefaultCFGScale > 0 {
+		attrs["cfg"] = true
+	}
+	return attrs
+}
+
+func paramsInit() error {
+	for _, s := range paramsSettings {
+		v := &paramsFlagValue{value: s.def, isBool: s.isBool}
+		paramsFlagValues[s.name] = v
+		flag.Var(v, s.name, s.usage)
+	}
+	flag.StringVar(&paramsConfigFile, "config", "", "path of the YAML config file")
+	flag.Parse()
+
+	if paramsConfigFile == "" {
+		paramsConfigFile = os.Getenv("CONFIG_FILE")
 	}
 
+	p, err := paramsLoad()
+	if err != nil {
+		return err
+	}
+	paramsValue.Store(p)
 	return nil
 }
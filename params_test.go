@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestParamsParseBool(t *testing.T) {
+	tests := []struct {
+		s   string
+		r   bool
+		err bool
+	}{
+		{s: "true", r: true},
+		{s: "TRUE", r: true},
+		{s: "1", r: true},
+		{s: "yes", r: true},
+		{s: "false"},
+		{s: "0"},
+		{s: "No"},
+		{s: "", err: true},
+		{s: "flase", err: true},
+		{s: "2", err: true},
+	}
+
+	for _, test := range tests {
+		r, err := paramsParseBool(test.s)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.s, err)
+		} else if r != test.r {
+			t.Errorf("%q: got %v", test.s, r)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const paramsReloadDebounceInterval = 500 * time.Millisecond
+
+// Copies settings which can only be changed by restarting the bot from old to p.
+// Returns the names of the settings which would have been changed.
+func (p *paramsType) keepRestartRequiredSettings(old *paramsType) (changed []string) {
+	keep := func(name string, dst, src any) {
+		d := reflect.ValueOf(dst).Elem()
+		s := reflect.ValueOf(src).Elem()
+		if !reflect.DeepEqual(d.Interface(), s.Interface()) {
+			changed = append(changed, name)
+			d.Set(s)
+		}
+	}
+	keep("bot_token", &p.BotToken, &old.BotToken)
+	keep("sd_path", &p.StableDiffusionPath, &old.StableDiffusionPath)
+	keep("sd_webui_path", &p.StableDiffusionWebUIPath, &old.StableDiffusionWebUIPath)
+	keep("sd_start", &p.SDStart, &old.SDStart)
+	keep("delayed_sd_start", &p.DelayedSDStart, &old.DelayedSDStart)
+	keep("lang_overrides_file", &p.LangOverridesFile, &old.LangOverridesFile)
+	keep("output_defaults_file", &p.OutputDefaultsFile, &old.OutputDefaultsFile)
+	keep("metrics_addr", &p.MetricsAddr, &old.MetricsAddr)
+	keep("log_json", &p.LogJSON, &old.LogJSON)
+	keep("sd_mock", &p.SDMock, &old.SDMock)
+	keep("sd_mock_*", &p.SDMockParams, &old.SDMockParams)
+	return
+}
+
+// Reloads the config file. Invalid configs are rejected and the previous params are kept.
+func paramsReload(ctx context.Context) {
+	slog.Info("reloading config", "file", paramsConfigFile)
+
+	p, err := paramsLoad()
+	if err != nil {
+		slog.Error("config reload failed, keeping the previous config", "error", err)
+		sendTextToAdmins(ctx, errorStr+": config reload failed: "+err.Error())
+		return
+	}
+
+	for _, name := range p.keepRestartRequiredSettings(getParams()) {
+		slog.Warn("config setting change requires restart, ignoring", "setting", name)
+	}
+
+	paramsValue.Store(p)
+	logLevel.Set(p.LogLevel)
+	slog.Info("config reloaded")
+}
+
+// Reloads the config file on SIGHUP and when the config file changes.
+func paramsWatch(ctx context.Context) {
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
+	var fileEvents chan fsnotify.Event
+	var fileErrors chan error
+	if paramsConfigFile != "" {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			slog.Error("can't watch config file", "error", err)
+		} else {
+			defer watcher.Close()
+			// Watching the directory as editors usually replace the file on save.
+			if err = watcher.Add(filepath.Dir(paramsConfigFile)); err != nil {
+				slog.Error("can't watch config file", "error", err)
+			} else {
+				fileEvents = watcher.Events
+				fileErrors = watcher.Errors
+			}
+		}
+	}
+
+	debounceTimer := time.NewTimer(0)
+	<-debounceTimer.C
+
+	for {
+		select {
+		case <-ctx.Done():
+			signal.Stop(hupChan)
+			return
+		case <-hupChan:
+			paramsReload(ctx)
+		case event := <-fileEvents:
+			if filepath.Clean(event.Name) != filepath.Clean(paramsConfigFile) ||
+				!event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+			debounceTimer.Reset(paramsReloadDebounceInterval)
+		case <-debounceTimer.C:
+			paramsReload(ctx)
+		case err := <-fileErrors:
+			slog.Error("config file watch error", "error", err)
+		}
+	}
+}
@@ -3,37 +3,214 @@ package main
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/google/shlex"
+	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
 )
 
+// The default refiner switch point of the WebUI.
+const reqParamsRefinerDefaultSwitchAt = 0.8
+
+// WebUI settings of the setting shortcut params.
+var reqParamsSettingShortcuts = map[string]string{
+	"clipskip":  "CLIP_stop_at_last_layers",
+	"vae":       "sd_vae",
+	"eta":       "eta_ancestral",
+	"ensd":      "eta_noise_seed_delta",
+	"scheduler": "k_sched_type",
+}
+
+// Valid values of the scheduler setting.
+var reqParamsSchedulers = []string{"Automatic", "karras", "exponential", "polyexponential"}
+
+// Setting keys can only contain these characters.
+var reqParamsSettingKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Output image formats, the first one is the default.
+var reqParamsOutputFormats = []string{"jpg", "png", "webp", "webp-lossless", "avif"}
+
+// Supported chroma subsamplings of the JPEG and AVIF outputs, the first one is the default.
+var reqParamsOutputChromas = []string{"420", "444"}
+
+type ReqParamsOutput struct {
+	// One of reqParamsOutputFormats, JPEG if empty.
+	Format string
+	// Between 1 and 100, the format's default quality is used if 0.
+	Quality int
+	// One of reqParamsOutputChromas, 4:2:0 if empty.
+	Chroma string
+}
+
+func (o ReqParamsOutput) format() string {
+	if o.Format == "" {
+		return reqParamsOutputFormats[0]
+	}
+	return o.Format
+}
+
+func (o ReqParamsOutput) lossless() bool {
+	return o.format() == "png" || o.format() == "webp-lossless"
+}
+
+func (o ReqParamsOutput) quality() int {
+	if o.Quality > 0 {
+		return o.Quality
+	}
+	return outputDefaultQualities[o.format()]
+}
+
+// Returns an empty string for the default output.
+func (o ReqParamsOutput) String() string {
+	if o == (ReqParamsOutput{}) {
+		return ""
+	}
+	res := strings.ToUpper(o.format())
+	if !o.lossless() {
+		res += fmt.Sprint(":", o.quality())
+	}
+	if o.Chroma != "" {
+		res += "/" + o.Chroma
+	}
+	return res
+}
+
+func (o ReqParamsOutput) OrigPrompt() string {
+	return ""
+}
+
 type ReqParamsUpscale struct {
 	origPrompt string
 	Scale      float32
 	Upscaler   string
-	OutputPNG  bool
+	Output     ReqParamsOutput
+	// If set, then the lossless original is also sent as a document.
+	OutputFile bool
+
+	// If set, then the image is resized to this exact size instead of using the scale.
+	Width  int
+	Height int
+	Crop   bool
+
+	Upscaler2           string
+	Upscaler2Visibility float32
+
+	GFPGANVisibility     float32
+	CodeFormerVisibility float32
+	CodeFormerWeight     float32
+}
+
+// Returns true if the image gets resized. A scale of 1 means face restoration only.
+func (r ReqParamsUpscale) resizes() bool {
+	return (r.Scale > 0 && r.Scale != 1) || (r.Width > 0 && r.Height > 0)
+}
+
+// Returns true if any of the extras (resizing or face restoration) are enabled.
+func (r ReqParamsUpscale) enabled() bool {
+	return r.resizes() || r.GFPGANVisibility > 0 || r.CodeFormerVisibility > 0
 }
 
 func (r ReqParamsUpscale) String() string {
-	res := "🔎 " + r.Upscaler + "x" + fmt.Sprint(r.Scale)
-	if r.OutputPNG {
-		res += "/PNG"
+	var res []string
+	if r.Width > 0 && r.Height > 0 {
+		size := fmt.Sprintf("🔎 %s %dx%d", r.Upscaler, r.Width, r.Height)
+		if r.Crop {
+			size += "/crop"
+		}
+		res = append(res, size)
+	} else if r.resizes() {
+		res = append(res, "🔎 "+r.Upscaler+"x"+fmt.Sprint(r.Scale))
 	}
-	return res
+	if r.resizes() && r.Upscaler2 != "" {
+		res = append(res, "➕"+r.Upscaler2+":"+fmt.Sprint(r.Upscaler2Visibility))
+	}
+	if r.GFPGANVisibility > 0 {
+		res = append(res, "👤GFPGAN:"+fmt.Sprint(r.GFPGANVisibility))
+	}
+	if r.CodeFormerVisibility > 0 {
+		res = append(res, "👤CodeFormer:"+fmt.Sprint(r.CodeFormerVisibility, ":", r.CodeFormerWeight))
+	}
+	if output := r.Output.String(); output != "" {
+		res = append(res, output)
+	}
+	if r.OutputFile {
+		res = append(res, "📎")
+	}
+	return strings.Join(res, " ")
 }
 
 func (r ReqParamsUpscale) OrigPrompt() string {
 	return r.origPrompt
 }
 
+// Interrogation models supported by Stable Diffusion WebUI.
+var reqParamsInterrogateModels = []string{"clip", "deepdanbooru"}
+
+type ReqParamsInterrogate struct {
+	origPrompt string
+	Model      string
+}
+
+func (r ReqParamsInterrogate) String() string {
+	return "🔍 " + r.Model
+}
+
+func (r ReqParamsInterrogate) OrigPrompt() string {
+	return r.origPrompt
+}
+
 type ReqParamsRenderHR struct {
 	DenoisingStrength float32
 	Scale             float32
 	Upscaler          string
 	SecondPassSteps   int
+
+	// Target size of the second pass, overrides the scale. If only one of them is set, then the
+	// other one follows the aspect ratio of the image.
+	Width  int
+	Height int
+
+	// If not set, then the second pass uses the sampler, the CFG scale and the prompts of the first pass.
+	SamplerName    string
+	CFGScale       float32
+	Prompt         string
+	NegativePrompt string
+}
+
+func (h ReqParamsRenderHR) enabled() bool {
+	return h.Scale > 0 || h.Width > 0 || h.Height > 0
+}
+
+// Returns the output size of the second pass for the given first pass size.
+func (h ReqParamsRenderHR) size(width, height int) (int, int) {
+	if width <= 0 || height <= 0 {
+		return width, height
+	}
+	switch {
+	case h.Width > 0 && h.Height > 0:
+		return h.Width, h.Height
+	case h.Width > 0:
+		return h.Width, h.Width * height / width
+	case h.Height > 0:
+		return h.Height * width / height, h.Height
+	case h.Scale > 0:
+		return int(float32(width) * h.Scale), int(float32(height) * h.Scale)
+	}
+	return width, height
+}
+
+// Returns the upscale ratio of the second pass for the given first pass size.
+func (h ReqParamsRenderHR) scale(width, height int) float32 {
+	hrWidth, hrHeight := h.size(width, height)
+	return max(float32(hrWidth)/float32(width), float32(hrHeight)/float32(height))
+}
+
+type ReqParamsLoRA struct {
+	Name   string
+	Weight float32
 }
 
 type ReqParamsRender struct {
@@ -45,33 +222,99 @@ type ReqParamsRender struct {
 	Height         int
 	Steps          int
 	NumOutputs     int
-	OutputPNG      bool
+	Output         ReqParamsOutput
+	OutputFile     bool
 	CFGScale       float32
 	SamplerName    string
 	ModelName      string
+	AspectRatio    string
+
+	// The refiner model takes over the sampling at the given fraction (0-1) of the steps.
+	Refiner         string
+	RefinerSwitchAt float32
+
+	// WebUI settings overridden for the render, set with -set and the setting shortcuts.
+	Settings map[string]interface{}
+
+	// If set, then multiple output images are sent composed to one contact sheet.
+	Grid bool
+
+	// The output images are rendered in batches of this size, so big output counts fit in the
+	// VRAM. If 0, then all images are rendered in one batch.
+	BatchSize int
+
+	// Variation seed, it's mixed into the seed with the given strength (0-1).
+	Subseed         uint32
+	SubseedStrength float32
+
+	// Added to the prompt when rendering.
+	LoRAs      []ReqParamsLoRA
+	Embeddings []string
 
 	Upscale ReqParamsUpscale
 
 	HR ReqParamsRenderHR
+
+	// Attributes which have defaults set by the chat's group, these won't be overwritten by the model profile.
+	groupAttrs map[string]bool
+	// The model profile which was applied when parsing the params.
+	profile *paramsModelProfileType
 }
 
 func (r ReqParamsRender) String() string {
 	var numOutputs string
 	if r.NumOutputs > 1 {
 		numOutputs = fmt.Sprintf("x%d", r.NumOutputs)
+		if r.Grid {
+			numOutputs += "▦"
+		}
 	}
 
 	var outFormatText string
-	if r.OutputPNG {
-		outFormatText = "/PNG"
+	if output := r.Output.String(); output != "" {
+		outFormatText = "/" + output
+	}
+	if r.OutputFile {
+		outFormatText += "📎"
+	}
+
+	var aspectRatioText string
+	if r.AspectRatio != "" {
+		aspectRatioText = "(" + r.AspectRatio + ")"
 	}
 
-	res := fmt.Sprintf("🌱%d 👟%d 🕹%.1f 🖼%dx%d%s%s 🔭%s 🧩%s", r.Seed, r.Steps, r.CFGScale, r.Width, r.Height,
-		numOutputs, outFormatText, r.SamplerName, r.ModelName)
+	res := fmt.Sprintf("🌱%d 👟%d 🕹%.1f 🖼%dx%d%s%s%s 🔭%s 🧩%s", r.Seed, r.Steps, r.CFGScale, r.Width, r.Height,
+		aspectRatioText, numOutputs, outFormatText, r.SamplerName, r.ModelName)
 
-	if r.HR.Scale > 0 {
-		res += " 🔎 " + r.HR.Upscaler + "x" + fmt.Sprint(r.HR.Scale, "/", r.HR.DenoisingStrength)
-	} else if r.Upscale.Scale > 0 {
+	if r.Refiner != "" {
+		res += fmt.Sprintf(" 💎%s@%.2f", r.Refiner, r.RefinerSwitchAt)
+	}
+	settingKeys := maps.Keys(r.Settings)
+	slices.Sort(settingKeys)
+	for _, k := range settingKeys {
+		res += fmt.Sprintf(" ⚙%s=%v", k, r.Settings[k])
+	}
+	for _, l := range r.LoRAs {
+		res += " 🎚" + l.Name + ":" + fmt.Sprint(l.Weight)
+	}
+	for _, e := range r.Embeddings {
+		res += " 🔤" + e
+	}
+
+	if r.HR.enabled() {
+		if r.HR.Width > 0 || r.HR.Height > 0 {
+			hrWidth, hrHeight := r.HR.size(r.Width, r.Height)
+			res += fmt.Sprintf(" 🔎 %s→%dx%d/%v", r.HR.Upscaler, hrWidth, hrHeight, r.HR.DenoisingStrength)
+		} else {
+			res += " 🔎 " + r.HR.Upscaler + "x" + fmt.Sprint(r.HR.Scale, "/", r.HR.DenoisingStrength)
+		}
+		if r.HR.SamplerName != "" {
+			res += " 🔭" + r.HR.SamplerName
+		}
+		if r.HR.CFGScale > 0 {
+			res += fmt.Sprintf(" 🕹%.1f", r.HR.CFGScale)
+		}
+	} else if r.Upscale.enabled() {
 		res += " " + r.Upscale.String()
 	}
 
@@ -89,28 +332,263 @@ func (r ReqParamsRender) OrigPrompt() string {
 	return r.origPrompt
 }
 
+// Returns the prompt with the embeddings and the LoRAs set in the params.
+func (r ReqParamsRender) PromptWithExtraNetworks() string {
+	res := r.Prompt
+	for _, e := range r.Embeddings {
+		res += ", " + e
+	}
+	for _, l := range r.LoRAs {
+		res += fmt.Sprintf(" <lora:%s:%s>", l.Name, fmt.Sprint(l.Weight))
+	}
+	return res
+}
+
+// Returns the prompt of the highres mode second pass with the embeddings and the LoRAs set in the
+// params. It's the same as the first pass prompt if no separate prompt is set.
+func (r ReqParamsRender) HRPromptWithExtraNetworks() string {
+	if r.HR.Prompt == "" {
+		return r.PromptWithExtraNetworks()
+	}
+	p := r
+	p.Prompt = r.HR.Prompt
+	return p.PromptWithExtraNetworks()
+}
+
+// Returns the sampler of the highres mode second pass.
+func (r ReqParamsRender) HRSamplerName() string {
+	if r.HR.SamplerName == "" {
+		return r.SamplerName
+	}
+	return r.HR.SamplerName
+}
+
+// Returns the negative prompt of the highres mode second pass.
+func (r ReqParamsRender) HRNegativePrompt() string {
+	if r.HR.NegativePrompt == "" {
+		return r.NegativePrompt
+	}
+	return r.HR.NegativePrompt
+}
+
+// Fill modes of the area added to the canvas before outpainting.
+var reqParamsOutpaintFills = []string{"edge", "noise"}
+
+// The max. extension of a side of the image in pixels.
+const reqParamsOutpaintMaxExtension = 2048
+
+func (r *ReqParamsRender) setSetting(key string, val interface{}) {
+	if r.Settings == nil {
+		r.Settings = make(map[string]interface{})
+	}
+	r.Settings[key] = val
+}
+
+// Splits the render to batches. Seeds of the batches follow each other, so the output images are
+// the same as if they were rendered in one batch.
+func (r ReqParamsRender) batches() (res []ReqParamsRender) {
+	batchSize := r.BatchSize
+	if batchSize <= 0 {
+		batchSize = r.NumOutputs
+	}
+	for i := 0; i < r.NumOutputs; i += batchSize {
+		b := r
+		b.Seed = r.Seed + uint32(i)
+		b.NumOutputs = min(batchSize, r.NumOutputs-i)
+		res = append(res, b)
+	}
+	return
+}
+
+type ReqParamsOutpaint struct {
+	ReqParamsRender
+
+	// Extension of the sides of the image in pixels, rounded up to multiples of 8.
+	Left   int
+	Right  int
+	Top    int
+	Bottom int
+
+	Fill              string
+	DenoisingStrength float32
+
+	// The extension is split to this many inpainting passes. If 0, then it's determined by the
+	// size of the extension.
+	Passes int
+}
+
+func (r ReqParamsOutpaint) String() string {
+	var outFormatText string
+	if output := r.Output.String(); output != "" {
+		outFormatText = " " + output
+	}
+	if r.OutputFile {
+		outFormatText += " 📎"
+	}
+
+	res := fmt.Sprintf("⬅%d ➡%d ⬆%d ⬇%d 🪣%s 🌱%d 👟%d 🕹%.1f 🌀%.2f 🔭%s 🧩%s%s", r.Left, r.Right, r.Top, r.Bottom, r.Fill,
+		r.Seed, r.Steps, r.CFGScale, r.DenoisingStrength, r.SamplerName, r.ModelName, outFormatText)
+	if r.Passes > 0 {
+		res += fmt.Sprintf(" 🔁%d", r.Passes)
+	}
+	for _, l := range r.LoRAs {
+		res += " 🎚" + l.Name + ":" + fmt.Sprint(l.Weight)
+	}
+	for _, e := range r.Embeddings {
+		res += " 🔤" + e
+	}
+	return res
+}
+
+// The default frame count and frame rate of animations.
+const reqParamsAnimDefaultFrames = 8
+const reqParamsAnimDefaultFPS = 8
+const reqParamsAnimMaxFPS = 50
+
+type ReqParamsAnim struct {
+	ReqParamsRender
+
+	Frames int
+	FPS    int
+
+	// If enabled, then the variation strength of the second seed goes from 0 to 1 during the animation.
+	SeedWalk bool
+	Seed2    uint32
+
+	// If set, then the prompt gets blended into this prompt during the animation.
+	Prompt2 string
+}
+
+func (r ReqParamsAnim) String() string {
+	res := fmt.Sprintf("🎞%d@%dfps", r.Frames, r.FPS)
+	if r.SeedWalk {
+		res += fmt.Sprintf(" 🌱→%d", r.Seed2)
+	}
+	if r.Prompt2 != "" {
+		prompt2 := []rune(r.Prompt2)
+		if len(prompt2) > 10 {
+			prompt2 = append(prompt2[:10], []rune("...")...)
+		}
+		res += " 💬→" + string(prompt2)
+	}
+	return res + " " + r.ReqParamsRender.String()
+}
+
+// Returns true if at least one side of the image gets extended.
+func (r ReqParamsOutpaint) extends() bool {
+	return r.Left > 0 || r.Right > 0 || r.Top > 0 || r.Bottom > 0
+}
+
+// Parses an outpaint extension value and rounds it up to a multiple of 8.
+func reqParamsParseOutpaintExtension(attr, val string) (int, error) {
+	valInt, err := strconv.Atoi(val)
+	if err != nil || valInt < 0 || valInt > reqParamsOutpaintMaxExtension {
+		return 0, newLocaleError(localeMsgParamInvalidRange, attr, 0, reqParamsOutpaintMaxExtension)
+	}
+	return (valInt + outpaintSizeStep - 1) / outpaintSizeStep * outpaintSizeStep, nil
+}
+
 type ReqParams interface {
 	String() string
 	OrigPrompt() string
 }
 
+// Parses a float value which should be between 0 and 1.
+func reqParamsParseVisibility(attr, val string) (float32, error) {
+	valFloat, err := strconv.ParseFloat(val, 32)
+	if err != nil || valFloat < 0 || valFloat > 1 {
+		return 0, newLocaleError(localeMsgParamInvalidRange, attr, 0, 1)
+	}
+	return float32(valFloat), nil
+}
+
+func reqParamsCheckUpscaler(ctx context.Context, name string) error {
+	upscalers, err := sdAPI.GetUpscalers(ctx)
+	if err != nil {
+		return newLocaleError(localeMsgErrGetting, "upscalers", err)
+	}
+	if !slices.Contains(upscalers, name) {
+		return newLocaleError(localeMsgParamUnknownValue, "upscaler", name)
+	}
+	return nil
+}
+
+// Returns an error if users are not allowed to override the given WebUI setting.
+func reqParamsCheckSettingAllowed(key string) error {
+	// The model has its own param which is used for model batching.
+	if key == "sd_model_checkpoint" {
+		return newLocaleError(localeMsgParamSettingModel)
+	}
+	if !slices.Contains(getParams().OverrideSettingsAllowlist, key) {
+		return newLocaleError(localeMsgParamSettingNotAllowed, key)
+	}
+	return nil
+}
+
+// Parses a -set value to an int, a float, a bool or a string, as WebUI settings have typed values.
+// Only "true" and "false" are bools, so numeric settings like 0 and 1 stay numbers.
+func reqParamsParseSettingValue(val string) interface{} {
+	if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(val, 64); err == nil {
+		return f
+	}
+	switch strings.ToLower(val) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return val
+}
+
 // Returns -1 as firstCmdCharAt if no params have been found in the given string.
 func ReqParamsParse(ctx context.Context, s string, reqParams ReqParams) (firstCmdCharAt int, err error) {
 	lexer := shlex.NewLexer(strings.NewReader(s))
 
 	var reqParamsRender *ReqParamsRender
 	var reqParamsUpscale *ReqParamsUpscale
+	var reqParamsOutpaint *ReqParamsOutpaint
+	var reqParamsAnim *ReqParamsAnim
+	var outputParams *ReqParamsOutput
+	var outputOnly bool
 	switch v := reqParams.(type) {
 	case *ReqParamsRender:
 		reqParamsRender = v
+	case *ReqParamsOutpaint:
+		// Outpainting uses the render params for the inpainting passes.
+		reqParamsOutpaint = v
+		reqParamsRender = &v.ReqParamsRender
+	case *ReqParamsAnim:
+		// Frames of animations are renders.
+		reqParamsAnim = v
+		reqParamsRender = &v.ReqParamsRender
 	case *ReqParamsUpscale:
 		reqParamsUpscale = v
+	case *ReqParamsOutput:
+		// Only the output format params are parsed, used for setting the default output format.
+		outputParams = v
+		outputOnly = true
 	default:
 		return 0, fmt.Errorf("invalid reqParams type")
 	}
 
-	gotWidth := false
-	gotHeight := false
+	// Extras params are used by both render (as post-processing) and upscale requests.
+	upscaleParams := reqParamsUpscale
+	if reqParamsRender != nil {
+		upscaleParams = &reqParamsRender.Upscale
+	}
+
+	if reqParamsRender != nil {
+		outputParams = &reqParamsRender.Output
+	} else if reqParamsUpscale != nil {
+		outputParams = &reqParamsUpscale.Output
+	}
+
+	// Attributes explicitly set by the user, these won't be overwritten by the model profile.
+	gotAttrs := make(map[string]bool)
+	var aspectRatio float64
 
 	firstCmdCharAt = -1
 	for {
@@ -121,7 +599,7 @@ func ReqParamsParse(ctx context.Context, s string, reqParams ReqParams) (firstCm
 
 		if token[0] != '-' {
 			if firstCmdCharAt > -1 {
-				return 0, fmt.Errorf("params need to be after the prompt")
+				return 0, newLocaleError(localeMsgParamsAfterPrompt)
 			}
 			continue // Ignore tokens not starting with -
 		}
@@ -136,12 +614,12 @@ func ReqParamsParse(ctx context.Context, s string, reqParams ReqParams) (firstCm
 			}
 			val, lexErr := lexer.Next()
 			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
 			}
 			val = strings.TrimPrefix(val, "🌱")
 			valInt, err := strconv.ParseUint(val, 10, 32)
 			if err != nil {
-				return 0, fmt.Errorf("invalid seed")
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
 			}
 			reqParamsRender.Seed = uint32(valInt)
 			validAttr = true
@@ -151,123 +629,353 @@ func ReqParamsParse(ctx context.Context, s string, reqParams ReqParams) (firstCm
 			}
 			val, lexErr := lexer.Next()
 			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
 			}
 			valInt, err := strconv.Atoi(val)
-			if err != nil {
-				return 0, fmt.Errorf("invalid width")
+			if err != nil || valInt <= 0 {
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
 			}
 			reqParamsRender.Width = valInt
 			validAttr = true
-			gotWidth = true
+			gotAttrs["width"] = true
 		case "height", "h":
 			if reqParamsRender == nil {
 				break
 			}
 			val, lexErr := lexer.Next()
 			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
 			}
 			valInt, err := strconv.Atoi(val)
-			if err != nil {
-				return 0, fmt.Errorf("invalid height")
+			if err != nil || valInt <= 0 {
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
 			}
 			reqParamsRender.Height = valInt
 			validAttr = true
-			gotHeight = true
+			gotAttrs["height"] = true
 		case "steps", "t":
 			if reqParamsRender == nil {
 				break
 			}
 			val, lexErr := lexer.Next()
 			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
 			}
 			valInt, err := strconv.Atoi(val)
 			if err != nil {
-				return 0, fmt.Errorf("invalid steps")
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
 			}
 			reqParamsRender.Steps = valInt
 			validAttr = true
+			gotAttrs["steps"] = true
 		case "outcnt", "o":
 			if reqParamsRender == nil {
 				break
 			}
 			val, lexErr := lexer.Next()
 			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
 			}
 			valInt, err := strconv.Atoi(val)
 			if err != nil {
-				return 0, fmt.Errorf("invalid output count")
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
 			}
 			reqParamsRender.NumOutputs = valInt
 			validAttr = true
 		case "png", "p":
+			if outputParams == nil {
+				break
+			}
+			*outputParams = ReqParamsOutput{Format: "png"}
+			validAttr = true
+		case "fmt":
+			if outputParams == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			val = strings.ToLower(val)
+			if val == "jpeg" {
+				val = "jpg"
+			}
+			if !slices.Contains(reqParamsOutputFormats, val) {
+				return 0, newLocaleError(localeMsgParamInvalidChoice, attr, strings.Join(reqParamsOutputFormats, ", "))
+			}
+			outputParams.Format = val
+			validAttr = true
+		case "q":
+			if outputParams == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			valInt, err := strconv.Atoi(val)
+			if err != nil || valInt < 1 || valInt > 100 {
+				return 0, newLocaleError(localeMsgParamInvalidRange, attr, 1, 100)
+			}
+			outputParams.Quality = valInt
+			validAttr = true
+		case "chroma":
+			if outputParams == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			val = strings.ReplaceAll(val, ":", "")
+			if !slices.Contains(reqParamsOutputChromas, val) {
+				return 0, newLocaleError(localeMsgParamInvalidChoice, attr, strings.Join(reqParamsOutputChromas, ", "))
+			}
+			outputParams.Chroma = val
+			validAttr = true
+		case "file":
 			if reqParamsRender != nil {
-				reqParamsRender.OutputPNG = true
+				reqParamsRender.OutputFile = true
+				validAttr = true
 			} else if reqParamsUpscale != nil {
-				reqParamsUpscale.OutputPNG = true
+				reqParamsUpscale.OutputFile = true
+				validAttr = true
 			}
+		case "grid", "nogrid":
+			if reqParamsRender == nil {
+				break
+			}
+			reqParamsRender.Grid = attr == "grid"
+			validAttr = true
 		case "cfg", "c":
 			if reqParamsRender == nil {
 				break
 			}
 			val, lexErr := lexer.Next()
 			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
 			}
 			valFloat, err := strconv.ParseFloat(val, 32)
 			if err != nil {
-				return 0, fmt.Errorf("  invalid CFG scale")
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
 			}
 			reqParamsRender.CFGScale = float32(valFloat)
 			validAttr = true
+			gotAttrs["cfg"] = true
+		case "lora":
+			if reqParamsRender == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			lora := ReqParamsLoRA{Name: val, Weight: 1}
+			if i := strings.LastIndex(val, ":"); i >= 0 {
+				weight, err := strconv.ParseFloat(val[i+1:], 32)
+				if err != nil {
+					return 0, newLocaleError(localeMsgParamInvalid, attr)
+				}
+				lora.Name = val[:i]
+				lora.Weight = float32(weight)
+			}
+			loras, err := sdAPI.GetLoRAs(ctx)
+			if err != nil {
+				return 0, newLocaleError(localeMsgErrGetting, "loras", err)
+			}
+			// WebUI accepts both the name and the alias of the LoRA.
+			if !slices.ContainsFunc(loras, func(l sdAPILoRA) bool { return l.Name == lora.Name || (l.Alias != "" && l.Alias == lora.Name) }) {
+				return 0, newLocaleError(localeMsgParamUnknownValue, attr, lora.Name)
+			}
+			reqParamsRender.LoRAs = append(reqParamsRender.LoRAs, lora)
+			validAttr = true
+		case "emb":
+			if reqParamsRender == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			embs, err := sdAPI.GetEmbeddings(ctx)
+			if err != nil {
+				return 0, newLocaleError(localeMsgErrGetting, "embeddings", err)
+			}
+			if !slices.Contains(embs, val) {
+				return 0, newLocaleError(localeMsgParamUnknownValue, attr, val)
+			}
+			reqParamsRender.Embeddings = append(reqParamsRender.Embeddings, val)
+			validAttr = true
 		case "sampler", "r":
 			if reqParamsRender == nil {
 				break
 			}
 			val, lexErr := lexer.Next()
 			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
 			}
 			samplers, err := sdAPI.GetSamplers(ctx)
 			if err != nil {
-				return 0, fmt.Errorf("error getting samplers: %w", err)
+				return 0, newLocaleError(localeMsgErrGetting, "samplers", err)
 			}
 			if !slices.Contains(samplers, val) {
-				return 0, fmt.Errorf("invalid sampler")
+				return 0, newLocaleError(localeMsgParamUnknownValue, attr, val)
 			}
 			reqParamsRender.SamplerName = val
 			validAttr = true
+			gotAttrs["sampler"] = true
 		case "model", "m":
 			if reqParamsRender == nil {
 				break
 			}
 			val, lexErr := lexer.Next()
 			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
 			}
 			models, err := sdAPI.GetModels(ctx)
 			if err != nil {
-				return 0, fmt.Errorf("error getting models: %w", err)
+				return 0, newLocaleError(localeMsgErrGetting, "models", err)
 			}
 			if !slices.Contains(models, val) {
-				return 0, fmt.Errorf(" invalid model")
+				return 0, newLocaleError(localeMsgParamUnknownValue, attr, val)
 			}
 			reqParamsRender.ModelName = val
 			validAttr = true
+		case "refiner":
+			if reqParamsRender == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			// The refiner set in the model profile can be disabled with "none".
+			if strings.ToLower(val) == "none" {
+				val = ""
+			} else {
+				models, err := sdAPI.GetModels(ctx)
+				if err != nil {
+					return 0, newLocaleError(localeMsgErrGetting, "models", err)
+				}
+				if !slices.Contains(models, val) {
+					return 0, newLocaleError(localeMsgParamUnknownValue, attr, val)
+				}
+			}
+			reqParamsRender.Refiner = val
+			validAttr = true
+			gotAttrs["refiner"] = true
+		case "set":
+			if reqParamsRender == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			key, settingVal, ok := strings.Cut(val, "=")
+			if !ok || !reqParamsSettingKeyRegexp.MatchString(key) {
+				return 0, newLocaleError(localeMsgParamSettingFormat)
+			}
+			if err := reqParamsCheckSettingAllowed(key); err != nil {
+				return 0, err
+			}
+			reqParamsRender.setSetting(key, reqParamsParseSettingValue(settingVal))
+			validAttr = true
+		case "clipskip":
+			if reqParamsRender == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			if err := reqParamsCheckSettingAllowed(reqParamsSettingShortcuts[attr]); err != nil {
+				return 0, err
+			}
+			valInt, err := strconv.Atoi(val)
+			if err != nil || valInt < 1 || valInt > 12 {
+				return 0, newLocaleError(localeMsgParamInvalidRange, attr, 1, 12)
+			}
+			reqParamsRender.setSetting(reqParamsSettingShortcuts[attr], valInt)
+			validAttr = true
+		case "vae":
+			if reqParamsRender == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			if err := reqParamsCheckSettingAllowed(reqParamsSettingShortcuts[attr]); err != nil {
+				return 0, err
+			}
+			vaes, err := sdAPI.GetVAEs(ctx)
+			if err != nil {
+				return 0, newLocaleError(localeMsgErrGetting, "vaes", err)
+			}
+			// Automatic and None are special values of the WebUI.
+			if !slices.Contains(vaes, val) && val != "Automatic" && val != "None" {
+				return 0, newLocaleError(localeMsgParamUnknownValue, attr, val)
+			}
+			reqParamsRender.setSetting(reqParamsSettingShortcuts[attr], val)
+			validAttr = true
+		case "eta", "ensd", "scheduler":
+			if reqParamsRender == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			key := reqParamsSettingShortcuts[attr]
+			if err := reqParamsCheckSettingAllowed(key); err != nil {
+				return 0, err
+			}
+			var settingVal interface{}
+			switch attr {
+			case "eta":
+				if settingVal, err = reqParamsParseVisibility(attr, val); err != nil {
+					return 0, err
+				}
+			case "ensd":
+				valInt, err := strconv.Atoi(val)
+				if err != nil || valInt < 0 {
+					return 0, newLocaleError(localeMsgParamInvalid, attr)
+				}
+				settingVal = valInt
+			case "scheduler":
+				i := slices.IndexFunc(reqParamsSchedulers, func(s string) bool { return strings.EqualFold(s, val) })
+				if i < 0 {
+					return 0, newLocaleError(localeMsgParamInvalidChoice, attr, strings.Join(reqParamsSchedulers, ", "))
+				}
+				settingVal = reqParamsSchedulers[i]
+			}
+			reqParamsRender.setSetting(key, settingVal)
+			validAttr = true
+		case "refiner-at":
+			if reqParamsRender == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			if reqParamsRender.RefinerSwitchAt, err = reqParamsParseVisibility(attr, val); err != nil {
+				return 0, err
+			}
+			validAttr = true
+			gotAttrs["refiner-at"] = true
 		case "upscale", "u":
 			if reqParamsRender == nil && reqParamsUpscale == nil {
 				break
 			}
 			val, lexErr := lexer.Next()
 			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
 			}
 			valFloat, err := strconv.ParseFloat(val, 32)
 			if err != nil {
-				return 0, fmt.Errorf("invalid hr scale")
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
 			}
 			if reqParamsRender != nil {
 				reqParamsRender.Upscale.Scale = float32(valFloat)
@@ -281,14 +989,14 @@ func ReqParamsParse(ctx context.Context, s string, reqParams ReqParams) (firstCm
 			}
 			val, lexErr := lexer.Next()
 			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
 			}
 			upscalers, err := sdAPI.GetUpscalers(ctx)
 			if err != nil {
-				return 0, fmt.Errorf("error getting upscalers: %w", err)
+				return 0, newLocaleError(localeMsgErrGetting, "upscalers", err)
 			}
 			if !slices.Contains(upscalers, val) {
-				return 0, fmt.Errorf("invalid upscaler")
+				return 0, newLocaleError(localeMsgParamUnknownValue, attr, val)
 			}
 			if reqParamsRender != nil {
 				reqParamsRender.Upscale.Upscaler = val
@@ -296,65 +1004,322 @@ func ReqParamsParse(ctx context.Context, s string, reqParams ReqParams) (firstCm
 				reqParamsUpscale.Upscaler = val
 			}
 			validAttr = true
+		case "upscaler2", "u2":
+			if upscaleParams == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			// The visibility can be given after the upscaler's name, for ex. "R-ESRGAN 4x+:0.3".
+			upscaleParams.Upscaler2Visibility = 0.5
+			if i := strings.LastIndex(val, ":"); i >= 0 {
+				if visibility, err := reqParamsParseVisibility("upscaler2 visibility", val[i+1:]); err == nil {
+					upscaleParams.Upscaler2Visibility = visibility
+					val = val[:i]
+				}
+			}
+			if err := reqParamsCheckUpscaler(ctx, val); err != nil {
+				return 0, err
+			}
+			upscaleParams.Upscaler2 = val
+			validAttr = true
+		case "size":
+			if upscaleParams == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			widthStr, heightStr, _ := strings.Cut(strings.ToLower(val), "x")
+			width, err := strconv.Atoi(widthStr)
+			if err != nil || width <= 0 {
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
+			}
+			height, err := strconv.Atoi(heightStr)
+			if err != nil || height <= 0 {
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
+			}
+			upscaleParams.Width = width
+			upscaleParams.Height = height
+			validAttr = true
+		case "crop":
+			if upscaleParams == nil {
+				break
+			}
+			upscaleParams.Crop = true
+			validAttr = true
+		case "gfpgan":
+			if upscaleParams == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			if upscaleParams.GFPGANVisibility, err = reqParamsParseVisibility(attr, val); err != nil {
+				return 0, err
+			}
+			validAttr = true
+		case "codeformer":
+			if upscaleParams == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			visibility, weight, hasWeight := strings.Cut(val, ":")
+			if upscaleParams.CodeFormerVisibility, err = reqParamsParseVisibility(attr, visibility); err != nil {
+				return 0, err
+			}
+			if hasWeight {
+				if upscaleParams.CodeFormerWeight, err = reqParamsParseVisibility("codeformer weight", weight); err != nil {
+					return 0, err
+				}
+			}
+			validAttr = true
 		case "hr":
 			if reqParamsRender == nil {
 				break
 			}
 			val, lexErr := lexer.Next()
 			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
 			}
 			valFloat, err := strconv.ParseFloat(val, 32)
 			if err != nil {
-				return 0, fmt.Errorf("invalid hr scale")
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
 			}
 			reqParamsRender.HR.Scale = float32(valFloat)
 			validAttr = true
+			gotAttrs["hr"] = true
 		case "hr-denoisestrength", "hrd":
 			if reqParamsRender == nil {
 				break
 			}
 			val, lexErr := lexer.Next()
 			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
 			}
 			valFloat, err := strconv.ParseFloat(val, 32)
 			if err != nil {
-				return 0, fmt.Errorf("invalid hr denoise strength")
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
 			}
 			reqParamsRender.HR.DenoisingStrength = float32(valFloat)
 			validAttr = true
+			gotAttrs["hr-denoisestrength"] = true
 		case "hr-upscaler", "hru":
 			if reqParamsRender == nil {
 				break
 			}
 			val, lexErr := lexer.Next()
 			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
 			}
 			upscalers, err := sdAPI.GetUpscalers(ctx)
 			if err != nil {
-				return 0, fmt.Errorf("error getting upscalers: %w", err)
+				return 0, newLocaleError(localeMsgErrGetting, "upscalers", err)
 			}
 			if !slices.Contains(upscalers, val) {
-				return 0, fmt.Errorf("invalid upscaler")
+				return 0, newLocaleError(localeMsgParamUnknownValue, attr, val)
 			}
 			reqParamsRender.HR.Upscaler = val
 			validAttr = true
+			gotAttrs["hr-upscaler"] = true
+		case "ar":
+			if reqParamsRender == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			if aspectRatio, reqParamsRender.AspectRatio, err = aspectRatioParse(val); err != nil {
+				return 0, err
+			}
+			validAttr = true
+			gotAttrs["ar"] = true
+		case "left", "right", "top", "bottom":
+			if reqParamsOutpaint == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			valInt, err := reqParamsParseOutpaintExtension(attr, val)
+			if err != nil {
+				return 0, err
+			}
+			switch attr {
+			case "left":
+				reqParamsOutpaint.Left = valInt
+			case "right":
+				reqParamsOutpaint.Right = valInt
+			case "top":
+				reqParamsOutpaint.Top = valInt
+			case "bottom":
+				reqParamsOutpaint.Bottom = valInt
+			}
+			validAttr = true
+		case "fill":
+			if reqParamsOutpaint == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			val = strings.ToLower(val)
+			if !slices.Contains(reqParamsOutpaintFills, val) {
+				return 0, newLocaleError(localeMsgParamInvalidChoice, attr, strings.Join(reqParamsOutpaintFills, ", "))
+			}
+			reqParamsOutpaint.Fill = val
+			validAttr = true
+		case "passes":
+			if reqParamsOutpaint == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			valInt, err := strconv.Atoi(val)
+			if err != nil || valInt < 1 {
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
+			}
+			reqParamsOutpaint.Passes = valInt
+			validAttr = true
+		case "denoise":
+			if reqParamsOutpaint == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			if reqParamsOutpaint.DenoisingStrength, err = reqParamsParseVisibility("denoise", val); err != nil {
+				return 0, err
+			}
+			validAttr = true
+		case "frames", "fps":
+			if reqParamsAnim == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			valInt, err := strconv.Atoi(val)
+			if err != nil || valInt < 1 {
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
+			}
+			if attr == "frames" {
+				reqParamsAnim.Frames = valInt
+			} else {
+				// GIF frame delays are in 1/100 seconds, browsers slow down animations faster than 50 FPS.
+				if valInt > reqParamsAnimMaxFPS {
+					return 0, newLocaleError(localeMsgParamMax, attr, reqParamsAnimMaxFPS)
+				}
+				reqParamsAnim.FPS = valInt
+			}
+			validAttr = true
+		case "seed2":
+			if reqParamsAnim == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			valInt, err := strconv.ParseUint(strings.TrimPrefix(val, "🌱"), 10, 32)
+			if err != nil {
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
+			}
+			reqParamsAnim.Seed2 = uint32(valInt)
+			reqParamsAnim.SeedWalk = true
+			validAttr = true
+		case "to":
+			if reqParamsAnim == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			reqParamsAnim.Prompt2 = strings.TrimSpace(val)
+			validAttr = true
 		case "hr-steps", "hrt":
 			if reqParamsRender == nil {
 				break
 			}
 			val, lexErr := lexer.Next()
 			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
 			}
 			valInt, err := strconv.Atoi(val)
 			if err != nil {
-				return 0, fmt.Errorf("invalid hr second pass steps")
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
 			}
 			reqParamsRender.HR.SecondPassSteps = valInt
 			validAttr = true
+			gotAttrs["hr-steps"] = true
+		case "hr-w", "hrw", "hr-h", "hrh":
+			if reqParamsRender == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			valInt, err := strconv.Atoi(val)
+			if err != nil || valInt <= 0 {
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
+			}
+			if strings.HasSuffix(attr, "w") {
+				reqParamsRender.HR.Width = valInt
+			} else {
+				reqParamsRender.HR.Height = valInt
+			}
+			validAttr = true
+		case "hr-sampler", "hrr":
+			if reqParamsRender == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			samplers, err := sdAPI.GetSamplers(ctx)
+			if err != nil {
+				return 0, newLocaleError(localeMsgErrGetting, "samplers", err)
+			}
+			if !slices.Contains(samplers, val) {
+				return 0, newLocaleError(localeMsgParamUnknownValue, attr, val)
+			}
+			reqParamsRender.HR.SamplerName = val
+			validAttr = true
+		case "hr-cfg", "hrc":
+			if reqParamsRender == nil {
+				break
+			}
+			val, lexErr := lexer.Next()
+			if lexErr != nil {
+				return 0, newLocaleError(localeMsgParamMissingValue, attr)
+			}
+			valFloat, err := strconv.ParseFloat(val, 32)
+			if err != nil || valFloat <= 0 {
+				return 0, newLocaleError(localeMsgParamInvalid, attr)
+			}
+			reqParamsRender.HR.CFGScale = float32(valFloat)
+			validAttr = true
+		}
+
+		if outputOnly && !validAttr {
+			return 0, newLocaleError(localeMsgParamNotOutput, token)
 		}
 
 		if validAttr && firstCmdCharAt == -1 {
@@ -363,27 +1328,51 @@ func ReqParamsParse(ctx context.Context, s string, reqParams ReqParams) (firstCm
 	}
 
 	if reqParamsRender != nil {
-		if strings.HasSuffix(strings.ToLower(reqParamsRender.ModelName), "sdxl") {
-			if !gotWidth {
-				reqParamsRender.Width = params.DefaultWidthSDXL
+		// The caller has already set the chat's defaults. The model's profile only overrides the
+		// global defaults, the group's defaults and the user's params take precedence.
+		keepAttrs := maps.Clone(gotAttrs)
+		maps.Copy(keepAttrs, reqParamsRender.groupAttrs)
+		modelName := modelProfileRenderModel(ctx, reqParamsRender.ModelName)
+		if reqParamsRender.profile = getParams().ModelProfile(modelName); reqParamsRender.profile != nil {
+			reqParamsRender.profile.apply(reqParamsRender, keepAttrs)
+		} else if strings.HasSuffix(strings.ToLower(modelName), "sdxl") {
+			if !keepAttrs["width"] {
+				reqParamsRender.Width = getParams().DefaultWidthSDXL
 			}
-			if !gotHeight {
-				reqParamsRender.Height = params.DefaultHeightSDXL
+			if !keepAttrs["height"] {
+				reqParamsRender.Height = getParams().DefaultHeightSDXL
 			}
-		} else {
-			if !gotWidth {
-				reqParamsRender.Width = params.DefaultWidth
+		}
+
+		if gotAttrs["ar"] {
+			if gotAttrs["width"] || gotAttrs["height"] {
+				return 0, newLocaleError(localeMsgParamAspectRatioWithSize)
 			}
-			if !gotHeight {
-				reqParamsRender.Height = params.DefaultHeight
+			// The default resolution of the model is used as the pixel budget.
+			reqParamsRender.Width, reqParamsRender.Height = aspectRatioGetSize(aspectRatio,
+				reqParamsRender.Width*reqParamsRender.Height)
+		}
+
+		if reqParamsRender.HR.Width > 0 || reqParamsRender.HR.Height > 0 {
+			hrWidth, hrHeight := reqParamsRender.HR.size(reqParamsRender.Width, reqParamsRender.Height)
+			if hrWidth < reqParamsRender.Width || hrHeight < reqParamsRender.Height {
+				return 0, newLocaleError(localeMsgParamHRSizeTooSmall,
+					reqParamsRender.Width, reqParamsRender.Height)
 			}
 		}
 
-		// Don't allow upscaler while HR is enabled.
-		if reqParamsRender.HR.Scale > 0 {
+		// Don't allow upscaler while HR is enabled, face restoration is still possible.
+		if reqParamsRender.HR.enabled() {
 			reqParamsRender.Upscale.Scale = 0
+			reqParamsRender.Upscale.Width = 0
+			reqParamsRender.Upscale.Height = 0
 		}
 	}
 
+	if outputParams != nil {
+		if err = outputParams.check(); err != nil {
+			return 0, err
+		}
+	}
 	return
 }
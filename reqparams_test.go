@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func testDefaultRenderParams() ReqParamsRender {
+	return ReqParamsRender{
+		Seed:        1,
+		Width:       512,
+		Height:      512,
+		Steps:       20,
+		NumOutputs:  1,
+		CFGScale:    7,
+		SamplerName: "Euler a",
+		ModelName:   "mock-sd15",
+		HR: ReqParamsRenderHR{
+			DenoisingStrength: 0.4,
+			Upscaler:          "R-ESRGAN 4x+",
+			SecondPassSteps:   15,
+		},
+	}
+}
+
+func TestReqParamsParseRender(t *testing.T) {
+	testSetParams(t, nil)
+	testSetSDAPIMock(t, sdAPIMockParams{})
+
+	tests := []struct {
+		s              string
+		firstCmdCharAt int
+		err            bool
+		modify         func(r *ReqParamsRender)
+	}{
+		{s: "a cat", firstCmdCharAt: -1},
+		{s: "a cat -s 5 -o 2", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.Seed = 5; r.NumOutputs = 2 }},
+		{s: "a cat -w 768 -h 640 -t 30", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.Width = 768; r.Height = 640; r.Steps = 30 }},
+		{s: "a cat -w 0", err: true},
+		{s: "a cat -h -5", err: true},
+		{s: "a cat -w x", err: true},
+		{s: "a cat -w", err: true},
+		{s: "-s 1 a cat", err: true},
+		{s: "a cat -ar 16:9", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.Width = 704; r.Height = 384; r.AspectRatio = "16:9" }},
+		{s: "a cat -ar 16:9 -w 512", err: true},
+		{s: "a cat -r \"DPM++ 2M Karras\"", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.SamplerName = "DPM++ 2M Karras" }},
+		{s: "a cat -r nonexistent", err: true},
+		{s: "a cat -m nonexistent", err: true},
+		{s: "a cat -fmt jpeg -q 90", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.Output = ReqParamsOutput{Format: "jpg", Quality: 90} }},
+		{s: "a cat -q 101", err: true},
+		{s: "a cat -png", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.Output = ReqParamsOutput{Format: "png"} }},
+		{s: "a cat -gfpgan 0.5", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.Upscale.GFPGANVisibility = 0.5 }},
+		{s: "a cat -hr 2", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.HR.Scale = 2 }},
+		{s: "a cat -hr-w 1024", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.HR.Width = 1024 }},
+		{s: "a cat -hr-h 256", err: true},
+		{s: "a cat -hr-w 0", err: true},
+		{s: "a cat -hr-sampler \"Euler a\" -hr-cfg 5", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.HR.SamplerName = "Euler a"; r.HR.CFGScale = 5 }},
+		{s: "a cat -hr-sampler nonexistent", err: true},
+		{s: "a cat -hr 2 -u 2", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.HR.Scale = 2 }},
+		{s: "a cat -clipskip 2", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.setSetting("CLIP_stop_at_last_layers", 2) }},
+		{s: "a cat -clipskip 2 -set CLIP_stop_at_last_layers=3", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.setSetting("CLIP_stop_at_last_layers", int64(3)) }},
+		{s: "a cat -vae mock-vae", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.setSetting("sd_vae", "mock-vae") }},
+		{s: "a cat -vae nonexistent", err: true},
+		{s: "a cat -clipskip 13", err: true},
+		{s: "a cat -set eta_noise_seed_delta=1", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.setSetting("eta_noise_seed_delta", int64(1)) }},
+		{s: "a cat -set CLIP_stop_at_last_layers=0", firstCmdCharAt: 6, modify: func(r *ReqParamsRender) { r.setSetting("CLIP_stop_at_last_layers", int64(0)) }},
+		{s: "a cat -set sd_model_checkpoint=x", err: true},
+		{s: "a cat -set not_allowed=1", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			r := testDefaultRenderParams()
+			firstCmdCharAt, err := ReqParamsParse(context.Background(), test.s, &r)
+			if test.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if firstCmdCharAt != test.firstCmdCharAt {
+				t.Errorf("firstCmdCharAt is %d, expected %d", firstCmdCharAt, test.firstCmdCharAt)
+			}
+			expected := testDefaultRenderParams()
+			if test.modify != nil {
+				test.modify(&expected)
+			}
+			if !reflect.DeepEqual(r, expected) {
+				t.Errorf("got %+v, expected %+v", r, expected)
+			}
+		})
+	}
+}
+
+func TestReqParamsRenderHRSize(t *testing.T) {
+	tests := []struct {
+		hr                  ReqParamsRenderHR
+		width, height       int
+		expWidth, expHeight int
+	}{
+		{ReqParamsRenderHR{Scale: 2}, 512, 768, 1024, 1536},
+		{ReqParamsRenderHR{Width: 1024}, 512, 768, 1024, 1536},
+		{ReqParamsRenderHR{Height: 1536}, 512, 768, 1024, 1536},
+		{ReqParamsRenderHR{Width: 1000, Height: 600}, 512, 768, 1000, 600},
+		{ReqParamsRenderHR{}, 512, 768, 512, 768},
+		// A zero first pass size shouldn't cause a division by zero.
+		{ReqParamsRenderHR{Width: 1024}, 0, 0, 0, 0},
+		{ReqParamsRenderHR{Height: 1024}, 512, 0, 512, 0},
+	}
+	for _, test := range tests {
+		width, height := test.hr.size(test.width, test.height)
+		if width != test.expWidth || height != test.expHeight {
+			t.Errorf("%+v with %dx%d: got %dx%d, expected %dx%d", test.hr, test.width, test.height,
+				width, height, test.expWidth, test.expHeight)
+		}
+	}
+}
+
+func TestReqParamsParseOutput(t *testing.T) {
+	testSetParams(t, nil)
+	testSetSDAPIMock(t, sdAPIMockParams{})
+
+	tests := []struct {
+		s        string
+		err      bool
+		expected ReqParamsOutput
+	}{
+		{s: "-fmt jpeg -q 90", expected: ReqParamsOutput{Format: "jpg", Quality: 90}},
+		{s: "-fmt webp", err: true}, // ffmpeg_path is not set.
+		{s: "-png", expected: ReqParamsOutput{Format: "png"}},
+		{s: "-fmt bmp", err: true},
+		{s: "-chroma 422", err: true},
+		// Only the output params are accepted, the others shouldn't be ignored silently.
+		{s: "-crop", err: true},
+		{s: "-gfpgan 0.5", err: true},
+		{s: "-size 1024x1024", err: true},
+		{s: "-codeformer 0.5", err: true},
+		{s: "-u2 Lanczos", err: true},
+		{s: "-s 1", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			var output ReqParamsOutput
+			_, err := ReqParamsParse(context.Background(), test.s, &output)
+			if test.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if output != test.expected {
+				t.Errorf("got %+v, expected %+v", output, test.expected)
+			}
+		})
+	}
+}
+
+func TestReqParamsParseUpscale(t *testing.T) {
+	testSetParams(t, nil)
+	testSetSDAPIMock(t, sdAPIMockParams{})
+
+	var r ReqParamsUpscale
+	_, err := ReqParamsParse(context.Background(), "-u 2 -upscaler Lanczos -u2 LDSR:0.3 -codeformer 0.5:0.7 -size 1024x768 -crop", &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ReqParamsUpscale{
+		Scale:                2,
+		Upscaler:             "Lanczos",
+		Upscaler2:            "LDSR",
+		Upscaler2Visibility:  0.3,
+		CodeFormerVisibility: 0.5,
+		CodeFormerWeight:     0.7,
+		Width:                1024,
+		Height:               768,
+		Crop:                 true,
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("got %+v, expected %+v", r, expected)
+	}
+}
+
+func TestReqParamsParseSettingValue(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected interface{}
+	}{
+		{"1", int64(1)},
+		{"0", int64(0)},
+		{"-3", int64(-3)},
+		{"0.5", 0.5},
+		{"true", true},
+		{"False", false},
+		{"t", "t"},
+		{"karras", "karras"},
+	}
+	for _, test := range tests {
+		if v := reqParamsParseSettingValue(test.s); v != test.expected {
+			t.Errorf("%q parsed to %#v, expected %#v", test.s, v, test.expected)
+		}
+	}
+}
@@ -5,42 +5,68 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"image/jpeg"
-	"image/png"
+	"html"
+	"image"
+	"log/slog"
 	"math/rand"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
+	"golang.org/x/exp/slices"
 )
 
-const imageReqStr = "🩻 Please post the image file to process."
-const processStartStr = "🛎 Starting render..."
-const processStr = "🔨 Processing"
 const progressBarLength = 20
-const downloadingStr = "⬇ Downloading..."
-const uploadingStr = "☁ ️ Uploading..."
-const doneStr = "✅ Done"
+
+// Used for messages sent to the admins. Replies to users are localized.
 const errorStr = "❌ Error"
-const canceledStr = "❌ Canceled"
-const restartStr = "⚠️ Stable Diffusion is not running, starting, please wait..."
-const restartFailedStr = "☠️ Stable Diffusion start failed, please restart the bot"
 
+const reqQueueCancelCallbackPrefix = "sdcancel:"
+
+// Callback data of the button which renders the prompt of an interrogation result message.
+const reqQueueRenderCallbackData = "sdrender"
+
+// Processing timeout of one render call. Requests with multiple render calls (batches, outpaint passes
+// and animation frames) get this timeout for each call.
 const processTimeout = 10 * time.Minute
 const groupChatProgressUpdateInterval = 3 * time.Second
 const privateChatProgressUpdateInterval = 500 * time.Millisecond
 
+// Used for estimating the start time of queued requests until the first request of the type finishes.
+const reqQueueDefaultDurationEstimate = 30 * time.Second
+
 type ReqType int
 
 const (
 	ReqTypeRender ReqType = iota
 	ReqTypeUpscale
+	ReqTypeInterrogate
+	ReqTypeOutpaint
+	ReqTypeAnim
 )
 
+func (t ReqType) String() string {
+	switch t {
+	case ReqTypeRender:
+		return "render"
+	case ReqTypeUpscale:
+		return "upscale"
+	case ReqTypeInterrogate:
+		return "interrogate"
+	case ReqTypeOutpaint:
+		return "outpaint"
+	case ReqTypeAnim:
+		return "anim"
+	default:
+		return "unknown"
+	}
+}
+
 type ReqQueueEntry struct {
 	Type ReqType
 
@@ -50,6 +76,20 @@ type ReqQueueEntry struct {
 
 	ReplyMessage *models.Message
 	Message      *models.Message
+
+	// Set if the request message replies to an image, which is used instead of asking for an upload.
+	ImageFileID   string
+	ImageFilename string
+
+	// Set if the request came from an inline query. Replies are sent by editing this inline message.
+	InlineMessageID   string
+	inlineMessageText string
+
+	// If true, then the reply message won't have the cancel button anymore.
+	finished bool
+
+	// How many times other entries have been processed before this one to avoid model swaps.
+	skipped int
 }
 
 func (e *ReqQueueEntry) checkWaitError(err error) time.Duration {
@@ -63,73 +103,235 @@ func (e *ReqQueueEntry) checkWaitError(err error) time.Duration {
 	if err != nil {
 		return 0
 	}
-	return time.Duration(retryAfter) * time.Second
+	d := time.Duration(retryAfter) * time.Second
+	metrics.AddTelegramRateLimitWait(d)
+	return d
+}
+
+// Returns the locale used for the replies of the entry.
+func (e *ReqQueueEntry) loc() *localeType {
+	return localeFor(e.Message)
+}
+
+func (e *ReqQueueEntry) canBeCanceledBy(userID int64) bool {
+	return e.Message.From.ID == userID || getParams().IsAdmin(userID)
+}
+
+// Returns the cancel button for the reply message, or nil if the entry is finished.
+func (e *ReqQueueEntry) cancelMarkup() models.ReplyMarkup {
+	if e.finished {
+		return nil
+	}
+	return models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{{
+			{Text: e.loc().T(localeMsgCancelButton), CallbackData: reqQueueCancelCallbackPrefix + strconv.FormatUint(e.TaskID, 10)},
+		}},
+	}
+}
+
+// Returns the model used by the entry, or an empty string if it doesn't need a specific model.
+func (e *ReqQueueEntry) modelName() string {
+	switch p := e.Params.(type) {
+	case ReqParamsRender:
+		return p.ModelName
+	case ReqParamsOutpaint:
+		return p.ModelName
+	case ReqParamsAnim:
+		return p.ModelName
+	default:
+		return ""
+	}
+}
+
+// Returns the number of render and upscale calls needed for processing the entry.
+func (e *ReqQueueEntry) renderCallCount() int {
+	switch p := e.Params.(type) {
+	case ReqParamsRender:
+		n := max(len(p.batches()), 1)
+		if p.Upscale.enabled() { // Output images are post-processed one by one.
+			n += p.NumOutputs
+		}
+		return n
+	case ReqParamsOutpaint:
+		return p.passCount()
+	case ReqParamsAnim:
+		return max(p.Frames, 1)
+	default:
+		return 1
+	}
+}
+
+// Returns the amount of work needed for processing the entry. It's used for estimating the
+// processing time based on previous requests.
+func (e *ReqQueueEntry) workUnits() float64 {
+	switch p := e.Params.(type) {
+	case ReqParamsRender:
+		steps := p.Steps
+		if p.HR.enabled() {
+			steps += p.HR.SecondPassSteps
+		}
+		return float64(steps*p.NumOutputs*p.Width*p.Height) / 1000000
+	case ReqParamsOutpaint:
+		return float64(p.Steps * p.passCount())
+	case ReqParamsAnim:
+		return float64(p.Steps*p.Frames*p.Width*p.Height) / 1000000
+	default:
+		return 1
+	}
+}
+
+// Returns a logger with the task, user and chat ID attributes of the entry.
+func (e *ReqQueueEntry) log() *slog.Logger {
+	return logWithMsg(e.Message).With("task_id", e.TaskID)
 }
 
 func (e *ReqQueueEntry) sendReply(ctx context.Context, s string) {
+	if e.InlineMessageID != "" {
+		e.editInlineMessage(ctx, s)
+		return
+	}
+
 	if e.ReplyMessage == nil {
-		e.ReplyMessage = sendReplyToMessage(ctx, e.Message, s)
+		e.ReplyMessage = sendReplyToMessageWithMarkup(ctx, e.Message, s, e.cancelMarkup())
 	} else if e.ReplyMessage.Text != s {
 		e.ReplyMessage.Text = s
 		_, err := telegramBot.EditMessageText(ctx, &bot.EditMessageTextParams{
-			MessageID: e.ReplyMessage.ID,
-			ChatID:    e.ReplyMessage.Chat.ID,
-			Text:      s,
+			MessageID:   e.ReplyMessage.ID,
+			ChatID:      e.ReplyMessage.Chat.ID,
+			Text:        s,
+			ReplyMarkup: e.cancelMarkup(),
 		})
 		if err != nil {
-			fmt.Println("  reply edit error:", err)
+			metrics.IncTelegramAPIError("editMessageText")
 
 			waitNeeded := e.checkWaitError(err)
-			fmt.Println("  waiting", waitNeeded, "...")
+			e.log().Error("reply edit error", "error", err, "wait", waitNeeded)
 			time.Sleep(waitNeeded)
 		}
 	}
 }
 
-func (e *ReqQueueEntry) convertImagesFromPNGToJPG(ctx context.Context, imgs [][]byte) error {
+func (e *ReqQueueEntry) editInlineMessage(ctx context.Context, s string) {
+	if e.inlineMessageText == s {
+		return
+	}
+	e.inlineMessageText = s
+	_, err := telegramBot.EditMessageText(ctx, &bot.EditMessageTextParams{
+		InlineMessageID: e.InlineMessageID,
+		Text:            s,
+		ReplyMarkup:     e.cancelMarkup(),
+	})
+	if err != nil {
+		metrics.IncTelegramAPIError("editMessageText")
+
+		waitNeeded := e.checkWaitError(err)
+		e.log().Error("inline message edit error", "error", err, "wait", waitNeeded)
+		time.Sleep(waitNeeded)
+	}
+}
+
+// Encodes the rendered PNG images to the given output format in place.
+func (e *ReqQueueEntry) encodeImages(ctx context.Context, imgs [][]byte, output ReqParamsOutput) error {
 	for i := range imgs {
-		p, err := png.Decode(bytes.NewReader(imgs[i]))
+		encoded, err := outputEncode(ctx, imgs[i], output)
 		if err != nil {
-			fmt.Println("  png decode error:", err)
-			return fmt.Errorf("png decode error: %w", err)
+			e.log().Error("image encode error", "error", err, "format", output.format())
+			return fmt.Errorf("image encode error: %w", err)
 		}
-		buf := new(bytes.Buffer)
-		err = jpeg.Encode(buf, p, &jpeg.Options{Quality: 80})
-		if err != nil {
-			fmt.Println("  jpg decode error:", err)
-			return fmt.Errorf("jpg decode error: %w", err)
-		}
-		imgs[i] = buf.Bytes()
+		imgs[i] = encoded
 	}
 	return nil
 }
 
-// If filename is empty then a filename will be automatically generated.
-func (e *ReqQueueEntry) uploadImages(ctx context.Context, firstImageID uint32, description string, imgs [][]byte, filename string, retryAllowed bool) error {
+// If filename is empty then a filename will be automatically generated. Images which exceed
+// Telegram's photo limits are sent as documents. If originals are given, then they are sent as
+// documents after the photos, and the oversized images are only sent as originals.
+func (e *ReqQueueEntry) uploadImages(ctx context.Context, firstImageID uint32, description string, imgs, originals [][]byte, filename string, retryAllowed bool) error {
 	if len(imgs) == 0 {
-		fmt.Println("  error: nothing to upload")
+		e.log().Error("nothing to upload")
 		return fmt.Errorf("nothing to upload")
 	}
 
 	generateFilename := (filename == "")
+	imgFilename := func(i int, img []byte) string {
+		if generateFilename {
+			return fmt.Sprintf("sd-image-%d-%d-%d.%s", firstImageID, e.TaskID, i, imageFileExt(img))
+		}
+		return fileNameWithoutExt(filename) + "." + imageFileExt(img)
+	}
+	spoiler := getParams().ContentPolicyFor(e.Message.Chat.ID).Spoiler
+
+	if e.InlineMessageID != "" {
+		img := imgs[0]
+		if len(originals) > 0 {
+			img = originals[0]
+		}
+		return e.uploadInlineImage(ctx, description, img, imgFilename(0, img), spoiler, len(originals) > 0, retryAllowed)
+	}
+
+	var photos, docs [][]byte
+	var photoFilenames, docFilenames []string
+	for i := range imgs {
+		if imageFitsPhotoLimits(imgs[i]) {
+			photos = append(photos, imgs[i])
+			photoFilenames = append(photoFilenames, imgFilename(i, imgs[i]))
+		} else if originals == nil {
+			e.log().Info("image can't be sent as a photo, sending as document", "index", i, "size", len(imgs[i]))
+			docs = append(docs, imgs[i])
+			docFilenames = append(docFilenames, imgFilename(i, imgs[i]))
+		}
+	}
+	for i := range originals {
+		docs = append(docs, originals[i])
+		docFilenames = append(docFilenames, imgFilename(i, originals[i]))
+	}
+
+	if len(photos) > 0 {
+		if err := e.sendMediaGroup(ctx, description, photos, photoFilenames, false, spoiler, retryAllowed); err != nil {
+			return err
+		}
+		description = ""
+	}
+	if len(docs) > 0 {
+		return e.sendMediaGroup(ctx, description, docs, docFilenames, true, spoiler, retryAllowed)
+	}
+	return nil
+}
+
+// Sends the images as media groups with the description as the caption of the first image.
+// Documents can't be grouped with photos, so they are sent in separate groups.
+func (e *ReqQueueEntry) sendMediaGroup(ctx context.Context, description string, imgs [][]byte, filenames []string, asDocuments, spoiler, retryAllowed bool) error {
+	if len(imgs) > telegramMediaGroupMaxSize {
+		if err := e.sendMediaGroup(ctx, description, imgs[:telegramMediaGroupMaxSize], filenames[:telegramMediaGroupMaxSize], asDocuments, spoiler, retryAllowed); err != nil {
+			return err
+		}
+		return e.sendMediaGroup(ctx, "", imgs[telegramMediaGroupMaxSize:], filenames[telegramMediaGroupMaxSize:], asDocuments, spoiler, retryAllowed)
+	}
+
+	if len(description) > 1024 {
+		description = description[:1021] + "..."
+	}
 
 	var media []models.InputMedia
 	for i := range imgs {
 		var c string
 		if i == 0 {
 			c = description
-			if len(c) > 1024 {
-				c = c[:1021] + "..."
-			}
 		}
-		if generateFilename {
-			filename = fmt.Sprintf("sd-image-%d-%d-%d.jpg", firstImageID, e.TaskID, i)
+		if asDocuments {
+			media = append(media, &models.InputMediaDocument{
+				Media:           "attach://" + filenames[i],
+				MediaAttachment: bytes.NewReader(imgs[i]),
+				Caption:         c,
+			})
+		} else {
+			media = append(media, &models.InputMediaPhoto{
+				Media:           "attach://" + filenames[i],
+				MediaAttachment: bytes.NewReader(imgs[i]),
+				Caption:         c,
+				HasSpoiler:      spoiler,
+			})
 		}
-		media = append(media, &models.InputMediaPhoto{
-			Media:           "attach://" + filename,
-			MediaAttachment: bytes.NewReader(imgs[i]),
-			Caption:         c,
-		})
 	}
 	params := &bot.SendMediaGroupParams{
 		ChatID:           e.Message.Chat.ID,
@@ -138,19 +340,205 @@ func (e *ReqQueueEntry) uploadImages(ctx context.Context, firstImageID uint32, d
 	}
 	_, err := telegramBot.SendMediaGroup(ctx, params)
 	if err != nil {
-		fmt.Println("  send images error:", err)
+		e.log().Error("send images error", "error", err, "documents", asDocuments)
+		metrics.IncTelegramAPIError("sendMediaGroup")
+
+		if retryAllowed {
+			if retryAfter := e.checkWaitError(err); retryAfter > 0 {
+				e.log().Info("retrying image send", "after", retryAfter)
+				time.Sleep(retryAfter)
+				return e.sendMediaGroup(ctx, description, imgs, filenames, asDocuments, spoiler, false)
+			}
+		}
+		return fmt.Errorf("send images error: %w", err)
+	}
+	return nil
+}
+
+// Inline messages can only be edited to contain files which have already been uploaded to
+// Telegram, so the image is first sent to the inline upload chat, the inline message gets
+// edited to show it, and then the uploaded message is deleted. Images which exceed Telegram's
+// photo limits are sent as documents.
+func (e *ReqQueueEntry) uploadInlineImage(ctx context.Context, description string, img []byte, filename string, spoiler, asDocument, retryAllowed bool) error {
+	if len(description) > 1024 {
+		description = description[:1021] + "..."
+	}
+
+	uploadChatID := getParams().InlineUploadChatID
+	if uploadChatID == 0 {
+		uploadChatID = e.Message.From.ID
+	}
+
+	asDocument = asDocument || !imageFitsPhotoLimits(img)
+	var uploadMsg *models.Message
+	var err error
+	method := "sendPhoto"
+	if asDocument {
+		method = "sendDocument"
+		uploadMsg, err = telegramBot.SendDocument(ctx, &bot.SendDocumentParams{
+			ChatID:              uploadChatID,
+			Document:            &models.InputFileUpload{Filename: filename, Data: bytes.NewReader(img)},
+			DisableNotification: true,
+		})
+	} else {
+		uploadMsg, err = telegramBot.SendPhoto(ctx, &bot.SendPhotoParams{
+			ChatID:              uploadChatID,
+			Photo:               &models.InputFileUpload{Filename: filename, Data: bytes.NewReader(img)},
+			DisableNotification: true,
+		})
+	}
+	if err != nil {
+		e.log().Error("send inline image error", "error", err, "document", asDocument)
+		metrics.IncTelegramAPIError(method)
+
+		if retryAllowed {
+			if retryAfter := e.checkWaitError(err); retryAfter > 0 {
+				e.log().Info("retrying image send", "after", retryAfter)
+				time.Sleep(retryAfter)
+				return e.uploadInlineImage(ctx, description, img, filename, spoiler, asDocument, false)
+			}
+		}
+		return fmt.Errorf("send images error: %w", err)
+	}
+	defer func() {
+		_, _ = telegramBot.DeleteMessage(ctx, &bot.DeleteMessageParams{
+			MessageID: uploadMsg.ID,
+			ChatID:    uploadMsg.Chat.ID,
+		})
+	}()
 
-		if !retryAllowed {
-			return fmt.Errorf("send images error: %w", err)
+	var media models.InputMedia
+	if asDocument {
+		if uploadMsg.Document == nil {
+			return fmt.Errorf("uploaded message has no document")
+		}
+		media = &models.InputMediaDocument{
+			Media:   uploadMsg.Document.FileID,
+			Caption: description,
+		}
+	} else {
+		if len(uploadMsg.Photo) == 0 {
+			return fmt.Errorf("uploaded message has no photo")
+		}
+		media = &models.InputMediaPhoto{
+			Media:      uploadMsg.Photo[len(uploadMsg.Photo)-1].FileID,
+			Caption:    description,
+			HasSpoiler: spoiler,
+		}
+	}
+	_, err = telegramBot.EditMessageMedia(ctx, &bot.EditMessageMediaParams{
+		InlineMessageID: e.InlineMessageID,
+		Media:           media,
+	})
+	if err != nil {
+		e.log().Error("inline message media edit error", "error", err)
+		metrics.IncTelegramAPIError("editMessageMedia")
+		return fmt.Errorf("inline message edit error: %w", err)
+	}
+	return nil
+}
+
+// Sends the interrogated prompt in a code block so it can be easily copied, with a button for
+// rendering it.
+func (e *ReqQueueEntry) sendInterrogateResult(ctx context.Context, model, caption string, retryAllowed bool) error {
+	loc := e.loc()
+	_, err := telegramBot.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:           e.Message.Chat.ID,
+		ReplyToMessageID: e.Message.ID,
+		Text:             html.EscapeString(loc.T(localeMsgInterrogateResult, model)) + "\n<code>" + html.EscapeString(caption) + "</code>",
+		ParseMode:        models.ParseModeHTML,
+		ReplyMarkup: models.InlineKeyboardMarkup{
+			InlineKeyboard: [][]models.InlineKeyboardButton{{
+				{Text: loc.T(localeMsgInterrogateRender), CallbackData: reqQueueRenderCallbackData},
+			}},
+		},
+	})
+	if err != nil {
+		e.log().Error("send interrogate result error", "error", err)
+		metrics.IncTelegramAPIError("sendMessage")
+
+		if retryAllowed {
+			if retryAfter := e.checkWaitError(err); retryAfter > 0 {
+				e.log().Info("retrying interrogate result send", "after", retryAfter)
+				time.Sleep(retryAfter)
+				return e.sendInterrogateResult(ctx, model, caption, false)
+			}
+		}
+		return fmt.Errorf("send interrogate result error: %w", err)
+	}
+	return nil
+}
+
+// Sends the animation as a reply to the request message. The filename's extension should be .gif
+// or .mp4.
+func (e *ReqQueueEntry) uploadAnimation(ctx context.Context, description string, anim []byte, filename string, retryAllowed bool) error {
+	if len(description) > 1024 {
+		description = description[:1021] + "..."
+	}
+
+	_, err := telegramBot.SendAnimation(ctx, &bot.SendAnimationParams{
+		ChatID:           e.Message.Chat.ID,
+		ReplyToMessageID: e.Message.ID,
+		Animation:        &models.InputFileUpload{Filename: filename, Data: bytes.NewReader(anim)},
+		Caption:          description,
+		HasSpoiler:       getParams().ContentPolicyFor(e.Message.Chat.ID).Spoiler,
+	})
+	if err != nil {
+		e.log().Error("send animation error", "error", err)
+		metrics.IncTelegramAPIError("sendAnimation")
+
+		if retryAllowed {
+			if retryAfter := e.checkWaitError(err); retryAfter > 0 {
+				e.log().Info("retrying animation send", "after", retryAfter)
+				time.Sleep(retryAfter)
+				return e.uploadAnimation(ctx, description, anim, filename, false)
+			}
 		}
+		return fmt.Errorf("send animation error: %w", err)
+	}
+	return nil
+}
 
-		retryAfter := e.checkWaitError(err)
-		if retryAfter > 0 {
-			fmt.Println("  retrying after", retryAfter, "...")
-			time.Sleep(retryAfter)
-			return e.uploadImages(ctx, firstImageID, description, imgs, filename, false)
+// Sends the contact sheet of the images with pick buttons under it, and keeps the full resolution
+// images in the grid cache so they can be sent on request.
+func (e *ReqQueueEntry) uploadGrid(ctx context.Context, firstImageID uint32, description string, sheet []byte, imgs, originals [][]byte, retryAllowed bool) error {
+	if len(description) > 1024 {
+		description = description[:1021] + "..."
+	}
+
+	gridMsg, err := telegramBot.SendPhoto(ctx, &bot.SendPhotoParams{
+		ChatID:           e.Message.Chat.ID,
+		ReplyToMessageID: e.Message.ID,
+		Photo:            &models.InputFileUpload{Filename: fmt.Sprintf("sd-grid-%d-%d.jpg", firstImageID, e.TaskID), Data: bytes.NewReader(sheet)},
+		Caption:          description,
+		HasSpoiler:       getParams().ContentPolicyFor(e.Message.Chat.ID).Spoiler,
+		ReplyMarkup:      gridPickMarkup(len(imgs)),
+	})
+	if err != nil {
+		e.log().Error("send grid error", "error", err)
+		metrics.IncTelegramAPIError("sendPhoto")
+
+		if retryAllowed {
+			if retryAfter := e.checkWaitError(err); retryAfter > 0 {
+				e.log().Info("retrying grid send", "after", retryAfter)
+				time.Sleep(retryAfter)
+				return e.uploadGrid(ctx, firstImageID, description, sheet, imgs, originals, false)
+			}
 		}
+		return fmt.Errorf("send grid error: %w", err)
+	}
+
+	c := &gridCacheEntry{
+		chatID:    gridMsg.Chat.ID,
+		msgID:     gridMsg.ID,
+		imgs:      imgs,
+		originals: originals,
+	}
+	for i := range imgs {
+		c.filenames = append(c.filenames, fmt.Sprintf("sd-image-%d-%d-%d.%s", firstImageID, e.TaskID, i, imageFileExt(imgs[i])))
+		c.captions = append(c.captions, fmt.Sprintf("%d/%d 🌱%d", i+1, len(imgs), firstImageID+uint32(i)))
 	}
+	gridCache.add(c)
 	return nil
 }
 
@@ -170,6 +558,10 @@ type ReqQueueCurrentEntry struct {
 	canceled  bool
 	ctxCancel context.CancelFunc
 
+	// Processing start time and the last ETA reported by Stable Diffusion.
+	startedAt time.Time
+	eta       time.Duration
+
 	imgsChan    chan [][]byte
 	errChan     chan error
 	stoppedChan chan bool
@@ -180,34 +572,50 @@ type ReqQueueCurrentEntry struct {
 type ReqQueue struct {
 	mutex          sync.Mutex
 	ctx            context.Context
-	entries        []ReqQueueEntry
+	entries        []*ReqQueueEntry
 	processReqChan chan bool
 
 	currentEntry ReqQueueCurrentEntry
+
+	// Average processing time of a work unit by request type.
+	durationPerWorkUnit map[ReqType]time.Duration
+
+	// The model currently loaded in Stable Diffusion, empty if unknown.
+	loadedModel string
 }
 
 type ReqQueueReq struct {
-	Type    ReqType
-	Message *models.Message
-	Params  ReqParams
+	Type            ReqType
+	Message         *models.Message
+	InlineMessageID string
+	Params          ReqParams
+	ImageFileID     string
+	ImageFilename   string
 }
 
 func (q *ReqQueue) Add(req ReqQueueReq) {
 	q.mutex.Lock()
 
-	newEntry := ReqQueueEntry{
-		Type:    req.Type,
-		Message: req.Message,
-		Params:  req.Params,
-		TaskID:  rand.Uint64(),
+	newEntry := &ReqQueueEntry{
+		Type:            req.Type,
+		Message:         req.Message,
+		InlineMessageID: req.InlineMessageID,
+		Params:          req.Params,
+		ImageFileID:     req.ImageFileID,
+		ImageFilename:   req.ImageFilename,
+		TaskID:          rand.Uint64(),
 	}
 
 	if len(q.entries) > 0 {
-		fmt.Println("  queueing request at position #", len(q.entries))
-		newEntry.sendReply(q.ctx, q.getQueuePositionString(len(q.entries)))
+		newEntry.log().Info("queueing request", "position", len(q.entries))
+		newEntry.sendReply(q.ctx, newEntry.loc().T(localeMsgQueuePosition, len(q.entries)))
 	}
 
 	q.entries = append(q.entries, newEntry)
+	metrics.SetQueueLength(len(q.entries))
+	if getParams().MetricsPerUser {
+		metrics.IncUserRequest(req.Message.From.ID)
+	}
 	q.mutex.Unlock()
 
 	select {
@@ -216,21 +624,201 @@ func (q *ReqQueue) Add(req ReqQueueReq) {
 	}
 }
 
-func (q *ReqQueue) CancelCurrentEntry(ctx context.Context) (err error) {
+// Cancels the currently processed entry if the given user is allowed to cancel it.
+func (q *ReqQueue) CancelCurrentEntry(ctx context.Context, userID int64) error {
 	q.mutex.Lock()
-	if len(q.entries) > 0 {
+	if len(q.entries) == 0 {
+		q.mutex.Unlock()
+		slog.Info("no active request to cancel")
+		return newLocaleError(localeMsgNoActiveRequest)
+	}
+	taskID := q.entries[0].TaskID
+	q.mutex.Unlock()
+	return q.CancelEntry(ctx, taskID, userID)
+}
+
+// Cancels the entry with the given task ID if the given user is allowed to cancel it. If the entry
+// is being processed then processing gets interrupted, otherwise the entry is removed from the queue.
+func (q *ReqQueue) CancelEntry(ctx context.Context, taskID uint64, userID int64) error {
+	q.mutex.Lock()
+	i := slices.IndexFunc(q.entries, func(e *ReqQueueEntry) bool { return e.TaskID == taskID })
+	if i < 0 {
+		q.mutex.Unlock()
+		return newLocaleError(localeMsgRequestNotFound)
+	}
+	entry := q.entries[i]
+	if !entry.canBeCanceledBy(userID) {
+		q.mutex.Unlock()
+		entry.log().Info("cancel not allowed", "by_user_id", userID)
+		return newLocaleError(localeMsgCancelNotAllowed)
+	}
+
+	if entry == q.currentEntry.entry {
+		entry.log().Info("canceling", "by_user_id", userID)
 		q.currentEntry.canceled = true
 		q.currentEntry.ctxCancel()
-	} else {
-		fmt.Println("  no active request to cancel")
-		err = fmt.Errorf("no active request to cancel")
+		q.mutex.Unlock()
+		return nil
 	}
+
+	q.entries = slices.Delete(q.entries, i, i+1)
+	metrics.SetQueueLength(len(q.entries))
 	q.mutex.Unlock()
-	return
+
+	entry.log().Info("canceled while queued", "by_user_id", userID)
+	metrics.IncRequest(entry.Type, metricsOutcomeCanceled)
+	entry.finished = true
+	entry.sendReply(ctx, entry.loc().T(localeMsgCanceled))
+	return nil
+}
+
+// Returns the task ID of the entry which belongs to the given request or reply message.
+func (q *ReqQueue) FindEntryByMessage(chatID int64, msgID int) (taskID uint64, found bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, e := range q.entries {
+		if e.Message.Chat.ID != chatID {
+			continue
+		}
+		if e.Message.ID == msgID || (e.ReplyMessage != nil && e.ReplyMessage.ID == msgID) {
+			return e.TaskID, true
+		}
+	}
+	return 0, false
 }
 
-func (q *ReqQueue) getQueuePositionString(pos int) string {
-	return "👨‍👦‍👦 Request queued at position #" + fmt.Sprint(pos)
+// Returns the model which was last loaded by the queue, or an empty string if it's not known yet.
+func (q *ReqQueue) LoadedModel() string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.loadedModel
+}
+
+// Should be called when the loaded model gets changed outside of the queue.
+func (q *ReqQueue) SetLoadedModel(model string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.loadedModel = model
+}
+
+// Moves the first waiting render which uses the loaded model to the front of the queue, so the
+// model doesn't have to be swapped. To avoid starvation, an entry can only be skipped the configured
+// number of times. The front entry is always skipped the most, so it's enough to check only that.
+// The mutex must be locked when calling this function.
+func (q *ReqQueue) batchByModel() {
+	maxSkips := getParams().ModelBatchingMaxSkips
+	if maxSkips <= 0 || q.loadedModel == "" {
+		return
+	}
+
+	first := q.entries[0]
+	if model := first.modelName(); model == "" || model == q.loadedModel || first.skipped >= maxSkips {
+		return
+	}
+
+	i := slices.IndexFunc(q.entries, func(e *ReqQueueEntry) bool { return e.modelName() == q.loadedModel })
+	if i < 0 {
+		return
+	}
+	for _, e := range q.entries[:i] {
+		e.skipped++
+	}
+	entry := q.entries[i]
+	q.entries = slices.Delete(q.entries, i, i+1)
+	q.entries = slices.Insert(q.entries, 0, entry)
+	entry.log().Info("moved to the front of the queue to avoid model swap", "model", q.loadedModel, "from_position", i)
+}
+
+// Loads the model of the render if it's not loaded yet, so the user can be notified about the
+// model swap, which can take a long time. If the model can't be loaded, then the render will
+// load it using the override settings.
+func (q *ReqQueue) loadModel(ctx context.Context, model string) {
+	if model == "" {
+		return
+	}
+
+	q.mutex.Lock()
+	loaded := q.loadedModel
+	q.mutex.Unlock()
+
+	if loaded == "" {
+		var err error
+		if loaded, err = sdAPI.GetLoadedModel(ctx); err != nil {
+			q.currentEntry.entry.log().Warn("can't get loaded model", "error", err)
+			return
+		}
+	}
+
+	if loaded != model {
+		q.currentEntry.entry.log().Info("loading model", "model", model, "prev_model", loaded)
+		q.currentEntry.entry.sendReply(q.ctx, q.currentEntry.entry.loc().T(localeMsgLoadingModel, model))
+		startedAt := time.Now()
+		if err := sdAPI.SetModel(ctx, model); err != nil {
+			q.currentEntry.entry.log().Warn("can't load model", "model", model, "error", err)
+			return
+		}
+		q.currentEntry.entry.log().Info("model loaded", "model", model, "duration", time.Since(startedAt).Round(time.Millisecond))
+	}
+
+	q.SetLoadedModel(model)
+}
+
+func (q *ReqQueue) durationEstimate(e *ReqQueueEntry) time.Duration {
+	d, ok := q.durationPerWorkUnit[e.Type]
+	if !ok {
+		return reqQueueDefaultDurationEstimate
+	}
+	return time.Duration(float64(d) * e.workUnits())
+}
+
+// Updates the average processing time of a work unit with the processing time of the given entry.
+func (q *ReqQueue) updateDurationEstimate(e *ReqQueueEntry, d time.Duration) {
+	units := e.workUnits()
+	if units <= 0 {
+		return
+	}
+	perUnit := time.Duration(float64(d) / units)
+	if prev, ok := q.durationPerWorkUnit[e.Type]; ok {
+		perUnit = (prev*7 + perUnit*3) / 10
+	}
+	q.durationPerWorkUnit[e.Type] = perUnit
+}
+
+// Returns the list of queued requests with their estimated start times.
+func (q *ReqQueue) Status(loc *localeType) string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.entries) == 0 {
+		return loc.T(localeMsgQueueEmpty)
+	}
+
+	res := []string{loc.T(localeMsgQueueHeader)}
+	var startsIn time.Duration
+	for i, e := range q.entries {
+		requester := e.Message.From.FirstName
+		if e.Message.From.Username != "" {
+			requester = "@" + e.Message.From.Username
+		}
+		prompt := []rune(e.Params.OrigPrompt())
+		if len(prompt) > 30 {
+			prompt = append(prompt[:29], '…')
+		}
+
+		if e == q.currentEntry.entry {
+			eta := q.currentEntry.eta
+			if eta <= 0 {
+				eta = q.durationEstimate(e) - time.Since(q.currentEntry.startedAt)
+			}
+			eta = max(eta, 0)
+			res = append(res, loc.T(localeMsgQueueRunning, requester, e.Type.String(), string(prompt), eta.Round(time.Second)))
+			startsIn = eta
+			continue
+		}
+		res = append(res, loc.T(localeMsgQueueWaiting, i, requester, e.Type.String(), string(prompt), startsIn.Round(time.Second)))
+		startsIn += q.durationEstimate(e)
+	}
+	return strings.Join(res, "\n")
 }
 
 func (q *ReqQueue) queryProgress(ctx context.Context, prevProgressPercent int) (progressPercent int, eta time.Duration, err error) {
@@ -245,7 +833,11 @@ func (q *ReqQueue) queryProgress(ctx context.Context, prevProgressPercent int) (
 		} else if progressPercent < 0 {
 			progressPercent = 0
 		}
-		fmt.Print("    progress: ", progressPercent, "% eta: ", eta.Round(time.Second), "\n")
+		q.currentEntry.entry.log().Debug("progress", "percent", progressPercent, "eta", eta.Round(time.Second))
+
+		q.mutex.Lock()
+		q.currentEntry.eta = eta
+		q.mutex.Unlock()
 	}
 	return
 }
@@ -263,12 +855,12 @@ func (q *ReqQueue) runProcessThread(processCtx context.Context, processFn ReqQue
 	}
 
 	if errors.Is(err, syscall.ECONNREFUSED) { // Can't connect to Stable Diffusion?
-		if params.SDStart {
-			q.currentEntry.entry.sendReply(processCtx, restartStr)
+		if getParams().SDStart {
+			q.currentEntry.entry.sendReply(processCtx, q.currentEntry.entry.loc().T(localeMsgRestart))
 			err = startStableDiffusionIfNeeded(processCtx)
 			if err != nil {
-				fmt.Println("  error:", err)
-				q.currentEntry.entry.sendReply(processCtx, restartFailedStr+": "+err.Error())
+				q.currentEntry.entry.log().Error("can't start stable diffusion", "error", err)
+				q.currentEntry.entry.sendReply(processCtx, q.currentEntry.entry.loc().T(localeMsgRestartFailed)+": "+err.Error())
 				panic(err.Error())
 			}
 			if retryAllowed {
@@ -276,7 +868,7 @@ func (q *ReqQueue) runProcessThread(processCtx context.Context, processFn ReqQue
 				return
 			}
 		} else {
-			fmt.Println("  error: Stable Diffusion is not running and start is disabled, waiting...")
+			q.currentEntry.entry.log().Warn("stable diffusion is not running and start is disabled, waiting...")
 			time.Sleep(30 * time.Second)
 			if retryAllowed {
 				q.runProcessThread(processCtx, processFn, reqParams, imageData, false, imgsChan, errChan, stoppedChan)
@@ -284,7 +876,6 @@ func (q *ReqQueue) runProcessThread(processCtx context.Context, processFn ReqQue
 			}
 
 			err = fmt.Errorf("Stable Diffusion is not running and start is disabled.")
-			fmt.Println("  error:", err)
 		}
 	}
 
@@ -293,17 +884,18 @@ func (q *ReqQueue) runProcessThread(processCtx context.Context, processFn ReqQue
 }
 
 func (q *ReqQueue) runProcess(processCtx context.Context, processFn ReqQueueEntryProcessFn, reqParams ReqParams, imageData ImageFileData, reqParamsText string) (imgs [][]byte, err error) {
-	q.currentEntry.entry.sendReply(q.ctx, processStartStr+"\n"+reqParamsText)
+	loc := q.currentEntry.entry.loc()
+	q.currentEntry.entry.sendReply(q.ctx, loc.T(localeMsgProcessStart)+"\n"+reqParamsText)
 
 	q.currentEntry.imgsChan = make(chan [][]byte)
 	q.currentEntry.errChan = make(chan error, 1)
 	q.currentEntry.stoppedChan = make(chan bool, 1)
 
 	go q.runProcessThread(processCtx, processFn, reqParams, imageData, true, q.currentEntry.imgsChan, q.currentEntry.errChan, q.currentEntry.stoppedChan)
-	fmt.Println("  render started")
+	q.currentEntry.entry.log().Info("process started")
 
 	progressUpdateInterval := groupChatProgressUpdateInterval
-	if q.currentEntry.entry.Message.Chat.ID >= 0 {
+	if q.currentEntry.entry.Message.Chat.ID >= 0 && q.currentEntry.entry.InlineMessageID == "" {
 		progressUpdateInterval = privateChatProgressUpdateInterval
 	}
 	progressPercentUpdateTicker := time.NewTicker(progressUpdateInterval)
@@ -330,7 +922,8 @@ func (q *ReqQueue) runProcess(processCtx context.Context, processFn ReqQueueEntr
 		case <-processCtx.Done():
 			return nil, fmt.Errorf("timeout")
 		case <-progressPercentUpdateTicker.C:
-			q.currentEntry.entry.sendReply(q.ctx, processStr+" "+getProgressbar(progressPercent, progressBarLength)+" ETA: "+fmt.Sprint(eta.Round(time.Second))+"\n"+reqParamsText)
+			q.currentEntry.entry.sendReply(q.ctx, loc.T(localeMsgProcess)+" "+getProgressbar(progressPercent, progressBarLength)+" "+
+				loc.T(localeMsgETA)+": "+fmt.Sprint(eta.Round(time.Second))+"\n"+reqParamsText)
 		case <-progressCheckTicker.C:
 			progressPercent, eta, _ = q.queryProgress(processCtx, progressPercent)
 		case err = <-q.currentEntry.errChan:
@@ -344,80 +937,314 @@ func (q *ReqQueue) runProcess(processCtx context.Context, processFn ReqQueueEntr
 func (q *ReqQueue) upscale(processCtx context.Context, reqParams ReqParamsUpscale, imageData ImageFileData) error {
 	reqParamsText := reqParams.String()
 
+	startedAt := time.Now()
 	imgs, err := q.runProcess(processCtx, sdAPI.Upscale, reqParams, imageData, reqParamsText)
 	if err != nil {
 		return err
 	}
+	metrics.ObserveUpscale(reqParams, time.Since(startedAt))
 
-	fn := fileNameWithoutExt(imageData.filename) + "-upscaled"
-	if !reqParams.OutputPNG {
-		err = q.currentEntry.entry.convertImagesFromPNGToJPG(q.ctx, imgs)
-		if err != nil {
-			return err
-		}
-		fn += ".jpg"
-	} else {
-		fn += ".png"
+	var originals [][]byte
+	if reqParams.Output.format() == "png" || reqParams.OutputFile {
+		originals = slices.Clone(imgs)
 	}
+	err = q.currentEntry.entry.encodeImages(q.ctx, imgs, reqParams.Output)
+	if err != nil {
+		return err
+	}
+	fn := fileNameWithoutExt(imageData.filename) + "-upscaled." + imageFileExt(imgs[0])
 
-	fmt.Println("  uploading...")
-	q.currentEntry.entry.sendReply(q.ctx, uploadingStr+"\n"+reqParamsText)
+	q.currentEntry.entry.log().Info("uploading...")
+	q.currentEntry.entry.sendReply(q.ctx, q.currentEntry.entry.loc().T(localeMsgUploading)+"\n"+reqParamsText)
 
-	err = q.currentEntry.entry.uploadImages(q.ctx, 0, "", imgs, fn, true)
+	err = q.currentEntry.entry.uploadImages(q.ctx, 0, "", imgs, originals, fn, true)
 	if err == nil {
 		q.currentEntry.entry.deleteReply(q.ctx)
 	}
 	return err
 }
 
-func (q *ReqQueue) render(processCtx context.Context, reqParams ReqParamsRender) error {
-	reqParamsText := reqParams.String()
+func (q *ReqQueue) interrogate(processCtx context.Context, reqParams ReqParamsInterrogate, imageData ImageFileData) error {
+	// The caption is returned by the process function, as runProcess only handles images.
+	var caption string
+	interrogateFn := func(ctx context.Context, p ReqParams, imageData ImageFileData) (imgs [][]byte, err error) {
+		caption, err = sdAPI.Interrogate(ctx, p, imageData)
+		return nil, err
+	}
+	_, err := q.runProcess(processCtx, interrogateFn, reqParams, imageData, reqParams.String())
+	if err != nil {
+		return err
+	}
+	q.currentEntry.entry.log().Info("got interrogate result", "caption", caption)
 
-	imgs, err := q.runProcess(processCtx, sdAPI.Render, reqParams, ImageFileData{}, reqParamsText)
+	err = q.currentEntry.entry.sendInterrogateResult(q.ctx, reqParams.Model, caption, true)
+	if err == nil {
+		q.currentEntry.entry.deleteReply(q.ctx)
+	}
+	return err
+}
+
+func (q *ReqQueue) outpaint(processCtx context.Context, reqParams ReqParamsOutpaint, imageData ImageFileData) error {
+	src, _, err := image.Decode(bytes.NewReader(imageData.data))
+	if err != nil {
+		return fmt.Errorf("image decode error: %w", err)
+	}
+
+	b := src.Bounds()
+	limits := getParams().LimitsFor(q.currentEntry.entry.Message.Chat.ID, q.currentEntry.entry.Message.From.ID)
+	err = limits.checkOutpaintSize(b.Dx()+reqParams.Left+reqParams.Right, b.Dy()+reqParams.Top+reqParams.Bottom)
 	if err != nil {
 		return err
 	}
 
-	// Now we have the output images.
-	if reqParams.Upscale.Scale > 0 {
-		reqParamsUpscale := ReqParamsUpscale{
-			origPrompt: reqParams.OrigPrompt(),
-			Scale:      reqParams.Upscale.Scale,
-			Upscaler:   reqParams.Upscale.Upscaler,
-			OutputPNG:  reqParams.OutputPNG,
+	q.loadModel(processCtx, reqParams.ModelName)
+
+	reqParamsText := reqParams.String()
+	passes := reqParams.passExtensions()
+	var imgs [][]byte
+	for i, e := range passes {
+		if i > 0 { // Continuing with the result of the previous pass.
+			if src, _, err = image.Decode(bytes.NewReader(imgs[0])); err != nil {
+				return fmt.Errorf("image decode error: %w", err)
+			}
+		}
+
+		canvas, mask, err := outpaintPrepare(src, e, reqParams.Fill, int64(reqParams.Seed)+int64(i))
+		if err != nil {
+			return err
+		}
+		canvasData, err := outpaintEncodePNG(canvas)
+		if err != nil {
+			return err
+		}
+		maskData, err := outpaintEncodePNG(mask)
+		if err != nil {
+			return err
+		}
+
+		passParams := reqParams
+		passParams.Width = canvas.Bounds().Dx()
+		passParams.Height = canvas.Bounds().Dy()
+		inpaintFn := func(ctx context.Context, p ReqParams, imageData ImageFileData) ([][]byte, error) {
+			return sdAPI.Inpaint(ctx, p, imageData, maskData)
 		}
-		imgs, err = q.runProcess(processCtx, sdAPI.Upscale, reqParamsUpscale, ImageFileData{data: imgs[0], filename: ""}, reqParamsUpscale.String())
+
+		passText := reqParamsText
+		if len(passes) > 1 {
+			passText += fmt.Sprintf("\n🔁 %d/%d", i+1, len(passes))
+		}
+		imgs, err = q.runProcess(processCtx, inpaintFn, passParams, ImageFileData{data: canvasData}, passText)
 		if err != nil {
 			return err
 		}
 	}
+	if reqParams.ModelName != "" {
+		q.SetLoadedModel(reqParams.ModelName)
+	}
+
+	var originals [][]byte
+	if reqParams.Output.format() == "png" || reqParams.OutputFile {
+		originals = slices.Clone(imgs)
+	}
+	err = q.currentEntry.entry.encodeImages(q.ctx, imgs, reqParams.Output)
+	if err != nil {
+		return err
+	}
+	fn := fileNameWithoutExt(imageData.filename) + "-outpainted." + imageFileExt(imgs[0])
 
-	if !reqParams.OutputPNG {
-		err = q.currentEntry.entry.convertImagesFromPNGToJPG(q.ctx, imgs)
+	q.currentEntry.entry.log().Info("uploading...")
+	q.currentEntry.entry.sendReply(q.ctx, q.currentEntry.entry.loc().T(localeMsgUploading)+"\n"+reqParamsText)
+
+	err = q.currentEntry.entry.uploadImages(q.ctx, 0, reqParams.OrigPrompt()+"\n"+reqParamsText, imgs, originals, fn, true)
+	if err == nil {
+		q.currentEntry.entry.deleteReply(q.ctx)
+	}
+	return err
+}
+
+func (q *ReqQueue) anim(processCtx context.Context, reqParams ReqParamsAnim) error {
+	reqParamsText := reqParams.String()
+
+	q.loadModel(processCtx, reqParams.ModelName)
+
+	var frames [][]byte
+	for i := 0; i < reqParams.Frames; i++ {
+		frameText := reqParamsText + fmt.Sprintf("\n🎞 %d/%d", i+1, reqParams.Frames)
+		frameParams := reqParams.frameParams(i)
+		startedAt := time.Now()
+		imgs, err := q.runProcess(processCtx, sdAPI.Render, frameParams, ImageFileData{}, frameText)
 		if err != nil {
 			return err
 		}
+		metrics.ObserveRender(frameParams, time.Since(startedAt))
+		frames = append(frames, imgs[0])
+	}
+	if reqParams.ModelName != "" {
+		q.SetLoadedModel(reqParams.ModelName)
 	}
 
-	fmt.Println("  uploading...")
-	q.currentEntry.entry.sendReply(q.ctx, uploadingStr+"\n"+reqParamsText)
+	var anim []byte
+	var err error
+	fn := fmt.Sprintf("sd-anim-%d-%d", reqParams.Seed, q.currentEntry.entry.TaskID)
+	if ffmpegPath := getParams().FFmpegPath; ffmpegPath != "" {
+		if anim, err = animEncodeMP4(processCtx, ffmpegPath, frames, reqParams.FPS); err != nil {
+			q.currentEntry.entry.log().Warn("can't encode mp4, sending gif", "error", err)
+		} else {
+			fn += ".mp4"
+		}
+	}
+	if anim == nil {
+		if anim, err = animEncodeGIF(frames, reqParams.FPS); err != nil {
+			return err
+		}
+		fn += ".gif"
+	}
 
-	err = q.currentEntry.entry.uploadImages(q.ctx, reqParams.Seed, reqParams.OrigPrompt()+"\n"+reqParamsText, imgs, "", true)
+	q.currentEntry.entry.log().Info("uploading...")
+	q.currentEntry.entry.sendReply(q.ctx, q.currentEntry.entry.loc().T(localeMsgUploading)+"\n"+reqParamsText)
+
+	err = q.currentEntry.entry.uploadAnimation(q.ctx, reqParams.OrigPrompt()+"\n"+reqParamsText, anim, fn, true)
 	if err == nil {
 		q.currentEntry.entry.deleteReply(q.ctx)
 	}
 	return err
 }
 
+func (q *ReqQueue) render(processCtx context.Context, reqParams ReqParamsRender) error {
+	reqParamsText := reqParams.String()
+	description := reqParams.OrigPrompt() + "\n" + reqParamsText
+	entry := q.currentEntry.entry
+
+	q.loadModel(processCtx, reqParams.ModelName)
+
+	// Batches are uploaded as soon as they are ready, except for contact sheets which need all
+	// the images.
+	useGrid := reqParams.Grid && reqParams.NumOutputs > 1 && entry.InlineMessageID == ""
+	batches := reqParams.batches()
+	var gridImgs [][]byte
+	for i, batch := range batches {
+		batchText := reqParamsText
+		if len(batches) > 1 {
+			batchText += fmt.Sprintf("\n📦 %d/%d", i+1, len(batches))
+		}
+		imgs, err := q.renderBatch(processCtx, batch, batchText)
+		if err != nil {
+			return err
+		}
+		if useGrid {
+			gridImgs = append(gridImgs, imgs...)
+			continue
+		}
+
+		var originals [][]byte
+		if reqParams.Output.format() == "png" || reqParams.OutputFile {
+			originals = slices.Clone(imgs)
+		}
+		if err = entry.encodeImages(q.ctx, imgs, reqParams.Output); err != nil {
+			return err
+		}
+
+		entry.log().Info("uploading...", "batch", i+1, "batches", len(batches))
+		entry.sendReply(q.ctx, entry.loc().T(localeMsgUploading)+"\n"+batchText)
+
+		caption := description
+		if i > 0 {
+			caption = fmt.Sprintf("📦 %d/%d 🌱%d", i+1, len(batches), batch.Seed)
+		}
+		if err = entry.uploadImages(q.ctx, batch.Seed, caption, imgs, originals, "", true); err != nil {
+			return err
+		}
+	}
+
+	if useGrid {
+		var originals [][]byte
+		if reqParams.Output.format() == "png" || reqParams.OutputFile {
+			originals = slices.Clone(gridImgs)
+		}
+		// The contact sheet is composed from the rendered PNGs, as not all output formats can be decoded.
+		sheet, err := gridCompose(gridImgs)
+		if err != nil {
+			return err
+		}
+		if err = entry.encodeImages(q.ctx, gridImgs, reqParams.Output); err != nil {
+			return err
+		}
+
+		entry.log().Info("uploading...")
+		entry.sendReply(q.ctx, entry.loc().T(localeMsgUploading)+"\n"+reqParamsText)
+
+		if err = entry.uploadGrid(q.ctx, reqParams.Seed, description, sheet, gridImgs, originals, true); err != nil {
+			return err
+		}
+	}
+	entry.deleteReply(q.ctx)
+	return nil
+}
+
+// Renders one batch and post-processes the output images with the extras params. The returned
+// images are PNGs.
+func (q *ReqQueue) renderBatch(processCtx context.Context, reqParams ReqParamsRender, reqParamsText string) ([][]byte, error) {
+	startedAt := time.Now()
+	imgs, err := q.runProcess(processCtx, sdAPI.Render, reqParams, ImageFileData{}, reqParamsText)
+	if err != nil {
+		return nil, err
+	}
+	metrics.ObserveRender(reqParams, time.Since(startedAt))
+	if reqParams.ModelName != "" {
+		q.SetLoadedModel(reqParams.ModelName)
+	}
+
+	if reqParams.Upscale.enabled() {
+		reqParamsUpscale := reqParams.Upscale
+		reqParamsUpscale.origPrompt = reqParams.OrigPrompt()
+		reqParamsUpscale.Output = reqParams.Output
+		for i := range imgs {
+			startedAt = time.Now()
+			upscaledImgs, err := q.runProcess(processCtx, sdAPI.Upscale, reqParamsUpscale, ImageFileData{data: imgs[i], filename: ""}, reqParamsUpscale.String())
+			if err != nil {
+				return nil, err
+			}
+			metrics.ObserveUpscale(reqParamsUpscale, time.Since(startedAt))
+			imgs[i] = upscaledImgs[0]
+		}
+	}
+	return imgs, nil
+}
+
+// Downloads the image which the request message replies to.
+func (q *ReqQueue) downloadImage(ctx context.Context) (ImageFileData, error) {
+	entry := q.currentEntry.entry
+	loc := entry.loc()
+	entry.sendReply(ctx, loc.T(localeMsgDownloading))
+
+	var g GetFile
+	d, err := g.GetFile(ctx, entry.ImageFileID)
+	if err != nil {
+		entry.log().Error("can't get file", "error", err)
+		return ImageFileData{}, fmt.Errorf("%s: %w", loc.T(localeMsgCantGetFile), err)
+	}
+	entry.sendReply(ctx, loc.T(localeMsgDownloadDone)+"\n"+entry.Params.String())
+	return ImageFileData{
+		data:     d,
+		filename: entry.ImageFilename,
+	}, nil
+}
+
 func (q *ReqQueue) processQueueEntry(processCtx context.Context, imageData ImageFileData) error {
-	fmt.Print("processing request from ", q.currentEntry.entry.Message.From.Username, "#",
-		q.currentEntry.entry.Message.From.ID, ": ", q.currentEntry.entry.Params.OrigPrompt(), "\n")
+	q.currentEntry.entry.log().Info("processing request", "type", q.currentEntry.entry.Type.String(),
+		"prompt", q.currentEntry.entry.Params.OrigPrompt())
 
 	switch q.currentEntry.entry.Type {
 	case ReqTypeRender:
 		return q.render(processCtx, q.currentEntry.entry.Params.(ReqParamsRender))
 	case ReqTypeUpscale:
 		return q.upscale(processCtx, q.currentEntry.entry.Params.(ReqParamsUpscale), imageData)
+	case ReqTypeInterrogate:
+		return q.interrogate(processCtx, q.currentEntry.entry.Params.(ReqParamsInterrogate), imageData)
+	case ReqTypeOutpaint:
+		return q.outpaint(processCtx, q.currentEntry.entry.Params.(ReqParamsOutpaint), imageData)
+	case ReqTypeAnim:
+		return q.anim(processCtx, q.currentEntry.entry.Params.(ReqParamsAnim))
 	default:
 		return fmt.Errorf("unknown request")
 	}
@@ -432,35 +1259,40 @@ func (q *ReqQueue) processor() {
 			continue
 		}
 
+		q.batchByModel()
+
 		// Updating queue positions for all waiting entries.
 		for i := 1; i < len(q.entries); i++ {
-			sendReplyToMessage(q.ctx, q.entries[i].Message, q.getQueuePositionString(i))
+			q.entries[i].sendReply(q.ctx, q.entries[i].loc().T(localeMsgQueuePosition, i))
 		}
 
 		q.currentEntry = ReqQueueCurrentEntry{
-			entry: &q.entries[0],
+			entry: q.entries[0],
 		}
 		var processCtx context.Context
-		processCtx, q.currentEntry.ctxCancel = context.WithTimeout(q.ctx, processTimeout)
+		processCtx, q.currentEntry.ctxCancel = context.WithTimeout(q.ctx,
+			processTimeout*time.Duration(q.currentEntry.entry.renderCallCount()))
 		q.mutex.Unlock()
 
 		var err error
 		var imageData ImageFileData
 		imageNeededFirst := false
 		switch q.currentEntry.entry.Type {
-		case ReqTypeUpscale:
+		case ReqTypeUpscale, ReqTypeInterrogate, ReqTypeOutpaint:
 			imageNeededFirst = true
 		}
-		if imageNeededFirst {
-			fmt.Println("  waiting for image file...")
-			q.currentEntry.entry.sendReply(q.ctx, imageReqStr)
+		if imageNeededFirst && q.currentEntry.entry.ImageFileID != "" {
+			imageData, err = q.downloadImage(processCtx)
+		} else if imageNeededFirst {
+			q.currentEntry.entry.log().Info("waiting for image file...")
+			q.currentEntry.entry.sendReply(q.ctx, q.currentEntry.entry.loc().T(localeMsgImageReq))
 			q.currentEntry.gotImageChan = make(chan ImageFileData)
 			select {
 			case imageData = <-q.currentEntry.gotImageChan:
 			case <-processCtx.Done():
 				q.currentEntry.canceled = true
 			case <-time.NewTimer(3 * time.Minute).C:
-				fmt.Println("  waiting for image file timeout")
+				q.currentEntry.entry.log().Info("waiting for image file timeout")
 				err = fmt.Errorf("waiting for image data timeout")
 			}
 			close(q.currentEntry.gotImageChan)
@@ -472,20 +1304,34 @@ func (q *ReqQueue) processor() {
 		}
 
 		if err == nil {
+			q.mutex.Lock()
+			q.currentEntry.startedAt = time.Now()
+			q.mutex.Unlock()
+
 			err = q.processQueueEntry(processCtx, imageData)
 		}
 
 		q.mutex.Lock()
+		q.currentEntry.entry.finished = true
 		if q.currentEntry.canceled {
-			fmt.Print("  canceled\n")
+			q.currentEntry.entry.log().Info("canceled")
+			metrics.IncRequest(q.currentEntry.entry.Type, metricsOutcomeCanceled)
 			err = sdAPI.Interrupt(q.ctx)
 			if err != nil {
-				fmt.Println("  can't interrupt:", err)
+				q.currentEntry.entry.log().Error("can't interrupt", "error", err)
 			}
-			q.currentEntry.entry.sendReply(q.ctx, canceledStr)
+			q.currentEntry.entry.sendReply(q.ctx, q.currentEntry.entry.loc().T(localeMsgCanceled))
 		} else if err != nil {
-			fmt.Println("  error:", err)
-			q.currentEntry.entry.sendReply(q.ctx, errorStr+": "+err.Error())
+			q.currentEntry.entry.log().Error("request failed", "error", err)
+			if errors.Is(processCtx.Err(), context.DeadlineExceeded) {
+				metrics.IncRequest(q.currentEntry.entry.Type, metricsOutcomeTimeout)
+			} else {
+				metrics.IncRequest(q.currentEntry.entry.Type, metricsOutcomeError)
+			}
+			q.currentEntry.entry.sendReply(q.ctx, q.currentEntry.entry.loc().T(localeMsgError)+": "+redactSecrets(q.currentEntry.entry.loc().Err(err)))
+		} else {
+			metrics.IncRequest(q.currentEntry.entry.Type, metricsOutcomeDone)
+			q.updateDurationEstimate(q.currentEntry.entry, time.Since(q.currentEntry.startedAt))
 		}
 
 		q.currentEntry.ctxCancel()
@@ -499,8 +1345,9 @@ func (q *ReqQueue) processor() {
 		}
 
 		q.entries = q.entries[1:]
+		metrics.SetQueueLength(len(q.entries))
 		if len(q.entries) == 0 {
-			fmt.Print("finished queue processing\n")
+			slog.Info("finished queue processing")
 		}
 		q.mutex.Unlock()
 	}
@@ -509,5 +1356,6 @@ func (q *ReqQueue) processor() {
 func (q *ReqQueue) Init(ctx context.Context) {
 	q.ctx = ctx
 	q.processReqChan = make(chan bool)
+	q.durationPerWorkUnit = make(map[ReqType]time.Duration)
 	go q.processor()
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+const testUserID = 10
+
+// Starts a request queue with the mock backend and the fake Telegram server. One sampling step
+// takes the given duration.
+func testStartQueue(t *testing.T, stepDuration time.Duration) (*ReqQueue, *testTelegramServer) {
+	t.Helper()
+	testSetParams(t, nil)
+	testSetSDAPIMock(t, sdAPIMockParams{StepDuration: stepDuration})
+	tg := testStartTelegram(t)
+
+	q := &ReqQueue{}
+	q.Init(testContext(t))
+	return q, tg
+}
+
+func testRenderReq(msgID int, prompt string, steps int) ReqQueueReq {
+	return ReqQueueReq{
+		Type: ReqTypeRender,
+		Message: &models.Message{
+			ID:   msgID,
+			Chat: models.Chat{ID: testUserID, Type: "private"},
+			From: &models.User{ID: testUserID, FirstName: "Test"},
+			Text: prompt,
+		},
+		Params: ReqParamsRender{
+			origPrompt:  prompt,
+			Prompt:      prompt,
+			Seed:        1,
+			Width:       64,
+			Height:      64,
+			Steps:       steps,
+			NumOutputs:  1,
+			SamplerName: "Euler a",
+			ModelName:   "mock-sd15",
+		},
+	}
+}
+
+// Returns the task ID of the entry at the given position, or 0 if there's no such entry.
+func (q *ReqQueue) testTaskID(pos int) uint64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if pos >= len(q.entries) {
+		return 0
+	}
+	return q.entries[pos].TaskID
+}
+
+func (q *ReqQueue) testLen() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.entries)
+}
+
+// Returns true if the current entry has started processing.
+func (q *ReqQueue) testProcessing() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.currentEntry.entry != nil && !q.currentEntry.startedAt.IsZero() && !q.currentEntry.entry.finished
+}
+
+func TestReqQueueRender(t *testing.T) {
+	q, tg := testStartQueue(t, 75*time.Millisecond)
+	q.Add(testRenderReq(1, "a cat", 20))
+
+	testWaitFor(t, 10*time.Second, "image upload", func() bool { return len(tg.callsOf("sendMediaGroup")) > 0 })
+	testWaitFor(t, time.Second, "empty queue", func() bool { return q.testLen() == 0 })
+
+	upload := tg.callsOf("sendMediaGroup")[0]
+	if !strings.Contains(upload.Get("media"), "a cat") {
+		t.Errorf("the caption doesn't contain the prompt: %s", upload.Get("media"))
+	}
+	if !tg.gotText(localeEN.msgs[localeMsgProcess]) {
+		t.Error("no progress update has been sent")
+	}
+	if len(tg.callsOf("deleteMessage")) == 0 {
+		t.Error("the progress message hasn't been deleted")
+	}
+}
+
+func TestReqQueueEntryRenderCallCount(t *testing.T) {
+	tests := []struct {
+		params ReqParams
+		n      int
+	}{
+		{params: ReqParamsRender{NumOutputs: 4}, n: 1},
+		{params: ReqParamsRender{NumOutputs: 30, BatchSize: 10}, n: 3},
+		// Each output image is post-processed with a separate call.
+		{params: ReqParamsRender{NumOutputs: 30, BatchSize: 10, Upscale: ReqParamsUpscale{GFPGANVisibility: 1}}, n: 33},
+		{params: ReqParamsOutpaint{Left: 512}, n: 2},
+		{params: ReqParamsAnim{Frames: 16}, n: 16},
+	}
+	for _, test := range tests {
+		e := ReqQueueEntry{Params: test.params}
+		if n := e.renderCallCount(); n != test.n {
+			t.Errorf("%+v: got %d, expected %d", test.params, n, test.n)
+		}
+	}
+}
+
+func TestReqQueuePosition(t *testing.T) {
+	q, tg := testStartQueue(t, 20*time.Millisecond)
+	q.Add(testRenderReq(1, "first", 20))
+	q.Add(testRenderReq(2, "second", 20))
+
+	if !tg.gotText(localeEN.T(localeMsgQueuePosition, 1)) {
+		t.Error("the queue position of the second request hasn't been sent")
+	}
+	testWaitFor(t, 10*time.Second, "both uploads", func() bool { return len(tg.callsOf("sendMediaGroup")) == 2 })
+	testWaitFor(t, time.Second, "empty queue", func() bool { return q.testLen() == 0 })
+}
+
+func TestReqQueueCancelCurrent(t *testing.T) {
+	q, tg := testStartQueue(t, 100*time.Millisecond)
+	q.Add(testRenderReq(1, "a slow cat", 200))
+	taskID := q.testTaskID(0)
+
+	testWaitFor(t, 5*time.Second, "processing", q.testProcessing)
+	if err := q.CancelEntry(testContext(t), taskID, testUserID+1); err == nil {
+		t.Error("other users shouldn't be able to cancel the request")
+	}
+	if err := q.CancelEntry(testContext(t), taskID, testUserID); err != nil {
+		t.Fatal(err)
+	}
+
+	testWaitFor(t, 5*time.Second, "cancel reply", func() bool { return tg.gotText(localeEN.msgs[localeMsgCanceled]) })
+	testWaitFor(t, time.Second, "empty queue", func() bool { return q.testLen() == 0 })
+	if len(tg.callsOf("sendMediaGroup")) > 0 {
+		t.Error("the canceled request has been uploaded")
+	}
+}
+
+func TestReqQueueCancelQueued(t *testing.T) {
+	q, tg := testStartQueue(t, 100*time.Millisecond)
+	q.Add(testRenderReq(1, "first", 200))
+	q.Add(testRenderReq(2, "second", 200))
+	testWaitFor(t, 5*time.Second, "processing", q.testProcessing)
+
+	if err := q.CancelEntry(testContext(t), q.testTaskID(1), testUserID); err != nil {
+		t.Fatal(err)
+	}
+	if q.testLen() != 1 {
+		t.Errorf("queue length is %d after canceling the queued request, expected 1", q.testLen())
+	}
+	if !tg.gotText(localeEN.msgs[localeMsgCanceled]) {
+		t.Error("no cancel reply has been sent")
+	}
+	if err := q.CancelEntry(testContext(t), q.testTaskID(1), testUserID); err == nil {
+		t.Error("canceling a missing request should fail")
+	}
+
+	if err := q.CancelCurrentEntry(testContext(t), testUserID); err != nil {
+		t.Fatal(err)
+	}
+	testWaitFor(t, 5*time.Second, "empty queue", func() bool { return q.testLen() == 0 })
+}
@@ -21,4 +21,26 @@ DEFAULT_WIDTH=$DEFAULT_WIDTH \
 DEFAULT_HEIGHT=$DEFAULT_HEIGHT \
 DEFAULT_WIDTH_SDXL=$DEFAULT_WIDTH_SDXL \
 DEFAULT_HEIGHT_SDXL=$DEFAULT_HEIGHT_SDXL \
+DEFAULT_STEPS=$DEFAULT_STEPS \
+DEFAULT_OUTCNT=$DEFAULT_OUTCNT \
+DEFAULT_CFG=$DEFAULT_CFG \
+DEFAULT_GRID=$DEFAULT_GRID \
+CONFIG_FILE=$CONFIG_FILE \
+SD_MOCK=$SD_MOCK \
+SD_MOCK_MODELS=$SD_MOCK_MODELS \
+SD_MOCK_SAMPLERS=$SD_MOCK_SAMPLERS \
+SD_MOCK_UPSCALERS=$SD_MOCK_UPSCALERS \
+SD_MOCK_STEP_DURATION=$SD_MOCK_STEP_DURATION \
+SD_MOCK_ERROR_RATE=$SD_MOCK_ERROR_RATE \
+METRICS_ADDR=$METRICS_ADDR \
+METRICS_PER_USER=$METRICS_PER_USER \
+LOG_LEVEL=$LOG_LEVEL \
+LOG_JSON=$LOG_JSON \
+INLINE_UPLOAD_CHAT_ID=$INLINE_UPLOAD_CHAT_ID \
+DEFAULT_LANG=$DEFAULT_LANG \
+LANG_OVERRIDES_FILE=$LANG_OVERRIDES_FILE \
+MODEL_BATCHING_MAX_SKIPS=$MODEL_BATCHING_MAX_SKIPS \
+FFMPEG_PATH=$FFMPEG_PATH \
+OUTPUT_DEFAULTS_FILE=$OUTPUT_DEFAULTS_FILE \
+OVERRIDE_SETTINGS_ALLOWLIST=$OVERRIDE_SETTINGS_ALLOWLIST \
 $bin $*
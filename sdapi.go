@@ -9,13 +9,54 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
 const sdAPIURL = "http://localhost:7860/sdapi/v1/"
 
+// Backend used for rendering. Implemented by sdAPIType which talks to the Stable Diffusion WebUI API
+// and by sdAPIMockType which renders placeholder images without a GPU.
+type sdAPIInterface interface {
+	Render(ctx context.Context, p ReqParams, imageData ImageFileData) (imgs [][]byte, err error)
+	Upscale(ctx context.Context, p ReqParams, imageData ImageFileData) (imgs [][]byte, err error)
+	Interrogate(ctx context.Context, p ReqParams, imageData ImageFileData) (caption string, err error)
+	// Repaints the white area of the mask on the image.
+	Inpaint(ctx context.Context, p ReqParams, imageData ImageFileData, mask []byte) (imgs [][]byte, err error)
+	Interrupt(ctx context.Context) error
+	GetProgress(ctx context.Context) (progressPercent int, eta time.Duration, err error)
+	GetModels(ctx context.Context) (models []string, err error)
+	GetModelInfos(ctx context.Context) (models []sdAPIModelInfo, err error)
+	GetLoadedModel(ctx context.Context) (model string, err error)
+	SetModel(ctx context.Context, model string) error
+	RefreshModels(ctx context.Context) error
+	GetSamplers(ctx context.Context) (samplers []string, err error)
+	GetEmbeddings(ctx context.Context) (embs []string, err error)
+	GetLoRAs(ctx context.Context) (loras []sdAPILoRA, err error)
+	GetUpscalers(ctx context.Context) (upscalers []string, err error)
+	GetVAEs(ctx context.Context) (vaes []string, err error)
+}
+
 type sdAPIType struct{}
 
+type sdAPIModelInfo struct {
+	Name     string
+	Hash     string
+	Filename string
+}
+
+type sdAPILoRA struct {
+	Name         string
+	Alias        string // Empty if it's the same as the name.
+	TriggerWords []string
+	Path         string
+}
+
 func (a *sdAPIType) req(ctx context.Context, path, service string, postData []byte) (string, error) {
 	path, err := url.JoinPath(sdAPIURL, path)
 	if err != nil {
@@ -60,11 +101,16 @@ type RenderReq struct {
 	HRScale           float32                `json:"hr_scale"`
 	HRUpscaler        string                 `json:"hr_upscaler"`
 	HRSecondPassSteps int                    `json:"hr_second_pass_steps"`
+	HRResizeX         int                    `json:"hr_resize_x,omitempty"`
+	HRResizeY         int                    `json:"hr_resize_y,omitempty"`
 	HRSamplerName     string                 `json:"hr_sampler_name"`
+	HRCFGScale        float32                `json:"hr_cfg,omitempty"`
 	HRPrompt          string                 `json:"hr_prompt"`
 	HRNegativePrompt  string                 `json:"hr_negative_prompt"`
 	Prompt            string                 `json:"prompt"`
 	Seed              uint32                 `json:"seed"`
+	Subseed           uint32                 `json:"subseed,omitempty"`
+	SubseedStrength   float32                `json:"subseed_strength,omitempty"`
 	SamplerName       string                 `json:"sampler_name"`
 	BatchSize         int                    `json:"batch_size"`
 	NIter             int                    `json:"n_iter"`
@@ -73,36 +119,81 @@ type RenderReq struct {
 	Width             int                    `json:"width"`
 	Height            int                    `json:"height"`
 	NegativePrompt    string                 `json:"negative_prompt"`
+	RefinerCheckpoint string                 `json:"refiner_checkpoint,omitempty"`
+	RefinerSwitchAt   float32                `json:"refiner_switch_at,omitempty"`
 	OverrideSettings  map[string]interface{} `json:"override_settings"`
 	SendImages        bool                   `json:"send_images"`
 }
 
+// Returns the WebUI settings which should be overridden for the request.
+func sdAPIOverrideSettings(params ReqParamsRender) map[string]interface{} {
+	// If no model is given, then the currently loaded model is used.
+	overrideSettings := map[string]interface{}{}
+	if params.ModelName != "" {
+		overrideSettings["sd_model_checkpoint"] = params.ModelName
+	}
+	for k, v := range params.Settings {
+		overrideSettings[k] = v
+	}
+	return overrideSettings
+}
+
+// Decodes the base64 encoded images of a txt2img or img2img response.
+func sdAPIDecodeImages(res string) (imgs [][]byte, err error) {
+	var renderResp struct {
+		Images []string `json:"images"`
+	}
+	err = json.Unmarshal([]byte(res), &renderResp)
+	if err != nil {
+		return nil, err
+	}
+	if len(renderResp.Images) == 0 {
+		return nil, fmt.Errorf("unknown error")
+	}
+
+	for _, img := range renderResp.Images {
+		var unbased []byte
+		if unbased, err = base64.StdEncoding.DecodeString(img); err != nil {
+			return nil, fmt.Errorf("image base64 decode error")
+		}
+		imgs = append(imgs, unbased)
+	}
+
+	return imgs, nil
+}
+
 func (a *sdAPIType) Render(ctx context.Context, p ReqParams, imageData ImageFileData) (imgs [][]byte, err error) {
 	params := p.(ReqParamsRender)
 
 	postData, err := json.Marshal(RenderReq{
-		EnableHR:          params.HR.Scale > 0,
+		EnableHR:          params.HR.enabled(),
 		DenoisingStrength: params.HR.DenoisingStrength,
 		HRScale:           params.HR.Scale,
 		HRUpscaler:        params.HR.Upscaler,
 		HRSecondPassSteps: params.HR.SecondPassSteps,
-		HRSamplerName:     params.SamplerName,
-		HRPrompt:          params.Prompt,
-		HRNegativePrompt:  params.NegativePrompt,
-		Prompt:            params.Prompt,
+		HRResizeX:         params.HR.Width,
+		HRResizeY:         params.HR.Height,
+		HRSamplerName:     params.HRSamplerName(),
+		HRCFGScale:        params.HR.CFGScale,
+		HRPrompt:          params.HRPromptWithExtraNetworks(),
+		HRNegativePrompt:  params.HRNegativePrompt(),
+		Prompt:            params.PromptWithExtraNetworks(),
 		Seed:              params.Seed,
+		Subseed:           params.Subseed,
+		SubseedStrength:   params.SubseedStrength,
 		SamplerName:       params.SamplerName,
 		BatchSize:         params.NumOutputs,
+		// Batches are rendered with separate calls, so their images can be sent as soon as they are ready.
 		NIter:             1,
 		Steps:             params.Steps,
 		CFGScale:          params.CFGScale,
 		Width:             params.Width,
 		Height:            params.Height,
 		NegativePrompt:    params.NegativePrompt,
-		OverrideSettings: map[string]interface{}{
-			"sd_model_checkpoint": params.ModelName,
-		},
-		SendImages: true,
+		RefinerCheckpoint: params.Refiner,
+		RefinerSwitchAt:   params.RefinerSwitchAt,
+		OverrideSettings:  sdAPIOverrideSettings(params),
+		SendImages:        true,
 	})
 	if err != nil {
 		return nil, err
@@ -112,27 +203,69 @@ func (a *sdAPIType) Render(ctx context.Context, p ReqParams, imageData ImageFile
 	if err != nil {
 		return nil, err
 	}
+	return sdAPIDecodeImages(res)
+}
 
-	var renderResp struct {
-		Images []string `json:"images"`
-	}
-	err = json.Unmarshal([]byte(res), &renderResp)
+type InpaintReq struct {
+	InitImages        []string               `json:"init_images"`
+	Mask              string                 `json:"mask"`
+	MaskBlur          int                    `json:"mask_blur"`
+	InpaintingFill    int                    `json:"inpainting_fill"`
+	InpaintFullRes    bool                   `json:"inpaint_full_res"`
+	DenoisingStrength float32                `json:"denoising_strength"`
+	Prompt            string                 `json:"prompt"`
+	Seed              uint32                 `json:"seed"`
+	SamplerName       string                 `json:"sampler_name"`
+	BatchSize         int                    `json:"batch_size"`
+	NIter             int                    `json:"n_iter"`
+	Steps             int                    `json:"steps"`
+	CFGScale          float32                `json:"cfg_scale"`
+	Width             int                    `json:"width"`
+	Height            int                    `json:"height"`
+	NegativePrompt    string                 `json:"negative_prompt"`
+	RefinerCheckpoint string                 `json:"refiner_checkpoint,omitempty"`
+	RefinerSwitchAt   float32                `json:"refiner_switch_at,omitempty"`
+	OverrideSettings  map[string]interface{} `json:"override_settings"`
+	SendImages        bool                   `json:"send_images"`
+}
+
+// The mask blur used for inpainting, so the repainted area blends in.
+const sdAPIInpaintMaskBlur = 8
+
+func (a *sdAPIType) Inpaint(ctx context.Context, p ReqParams, imageData ImageFileData, mask []byte) (imgs [][]byte, err error) {
+	params := p.(ReqParamsOutpaint)
+
+	postData, err := json.Marshal(InpaintReq{
+		InitImages: []string{base64.StdEncoding.EncodeToString(imageData.data)},
+		Mask:       base64.StdEncoding.EncodeToString(mask),
+		MaskBlur:   sdAPIInpaintMaskBlur,
+		// The masked content starts from the original image, which is already filled by the caller.
+		InpaintingFill:    1,
+		DenoisingStrength: params.DenoisingStrength,
+		Prompt:            params.PromptWithExtraNetworks(),
+		Seed:              params.Seed,
+		SamplerName:       params.SamplerName,
+		BatchSize:         1,
+		NIter:             1,
+		Steps:             params.Steps,
+		CFGScale:          params.CFGScale,
+		Width:             params.Width,
+		Height:            params.Height,
+		NegativePrompt:    params.NegativePrompt,
+		RefinerCheckpoint: params.Refiner,
+		RefinerSwitchAt:   params.RefinerSwitchAt,
+		OverrideSettings:  sdAPIOverrideSettings(params.ReqParamsRender),
+		SendImages:        true,
+	})
 	if err != nil {
 		return nil, err
 	}
-	if len(renderResp.Images) == 0 {
-		return nil, fmt.Errorf("unknown error")
-	}
 
-	for _, img := range renderResp.Images {
-		var unbased []byte
-		if unbased, err = base64.StdEncoding.DecodeString(img); err != nil {
-			return nil, fmt.Errorf("image base64 decode error")
-		}
-		imgs = append(imgs, unbased)
+	res, err := a.req(ctx, "/img2img", "", postData)
+	if err != nil {
+		return nil, err
 	}
-
-	return imgs, nil
+	return sdAPIDecodeImages(res)
 }
 
 type UpscaleReq struct {
@@ -144,7 +277,7 @@ type UpscaleReq struct {
 	UpscalingResize                float32 `json:"upscaling_resize,omitempty"`
 	UpscalingResizeWidth           int     `json:"upscaling_resize_w,omitempty"`
 	UpscalingResizeHeight          int     `json:"upscaling_resize_h,omitempty"`
-	UpscalingResizeWidthHeightCrop bool    `json:"upscaling_crop,omitempty"`
+	UpscalingResizeWidthHeightCrop bool    `json:"upscaling_crop"` // The WebUI crops if it's missing.
 	Upscaler1                      string  `json:"upscaler_1,omitempty"`
 	Upscaler2                      string  `json:"upscaler_2,omitempty"`
 	Upscaler2Visibility            float32 `json:"extras_upscaler_2_visibility,omitempty"`
@@ -152,14 +285,34 @@ type UpscaleReq struct {
 	Image                          string  `json:"image"`
 }
 
-func (a *sdAPIType) Upscale(ctx context.Context, p ReqParams, imageData ImageFileData) (imgs [][]byte, err error) {
-	params := p.(ReqParamsUpscale)
+// Returns the WebUI extras request for the given upscale params.
+func sdAPIUpscaleReq(params ReqParamsUpscale, imageData []byte) UpscaleReq {
+	req := UpscaleReq{
+		GFPGANVisibility:     params.GFPGANVisibility,
+		CodeFormerVisibility: params.CodeFormerVisibility,
+		CodeFormerWeight:     params.CodeFormerWeight,
+		UpscalingResize:      params.Scale,
+		Upscaler1:            params.Upscaler,
+		Image:                base64.StdEncoding.EncodeToString(imageData),
+	}
+	if params.Width > 0 && params.Height > 0 {
+		req.ResizeMode = 1
+		req.UpscalingResizeWidth = params.Width
+		req.UpscalingResizeHeight = params.Height
+		req.UpscalingResizeWidthHeightCrop = params.Crop
+	}
+	if !params.resizes() { // Face restoration only.
+		req.UpscalingResize = 1
+		req.Upscaler1 = "None"
+	} else if params.Upscaler2 != "" {
+		req.Upscaler2 = params.Upscaler2
+		req.Upscaler2Visibility = params.Upscaler2Visibility
+	}
+	return req
+}
 
-	postData, err := json.Marshal(UpscaleReq{
-		UpscalingResize: params.Scale,
-		Upscaler1:       params.Upscaler,
-		Image:           base64.StdEncoding.EncodeToString(imageData.data),
-	})
+func (a *sdAPIType) Upscale(ctx context.Context, p ReqParams, imageData ImageFileData) (imgs [][]byte, err error) {
+	postData, err := json.Marshal(sdAPIUpscaleReq(p.(ReqParamsUpscale), imageData.data))
 	if err != nil {
 		return nil, err
 	}
@@ -188,6 +341,42 @@ func (a *sdAPIType) Upscale(ctx context.Context, p ReqParams, imageData ImageFil
 	return [][]byte{unbased}, nil
 }
 
+func (a *sdAPIType) Interrogate(ctx context.Context, p ReqParams, imageData ImageFileData) (caption string, err error) {
+	params := p.(ReqParamsInterrogate)
+
+	postData, err := json.Marshal(struct {
+		Image string `json:"image"`
+		Model string `json:"model"`
+	}{
+		Image: base64.StdEncoding.EncodeToString(imageData.data),
+		Model: params.Model,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	res, err := a.req(ctx, "/interrogate", "", postData)
+	if err != nil {
+		return "", err
+	}
+
+	var interrogateResp struct {
+		Caption string `json:"caption"`
+		Detail  string `json:"detail"`
+	}
+	err = json.Unmarshal([]byte(res), &interrogateResp)
+	if err != nil {
+		return "", err
+	}
+	if interrogateResp.Detail != "" {
+		return "", fmt.Errorf(interrogateResp.Detail)
+	}
+	if interrogateResp.Caption == "" {
+		return "", fmt.Errorf("got empty caption")
+	}
+	return interrogateResp.Caption, nil
+}
+
 func (a *sdAPIType) Interrupt(ctx context.Context) error {
 	_, err := a.req(ctx, "/interrupt", "", []byte{})
 	if err != nil {
@@ -220,13 +409,27 @@ func (a *sdAPIType) GetProgress(ctx context.Context) (progressPercent int, eta t
 }
 
 func (a *sdAPIType) GetModels(ctx context.Context) (models []string, err error) {
+	infos, err := a.GetModelInfos(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range infos {
+		models = append(models, m.Name)
+	}
+	return
+}
+
+func (a *sdAPIType) GetModelInfos(ctx context.Context) (models []sdAPIModelInfo, err error) {
 	res, err := a.req(ctx, "/sd-models", "", nil)
 	if err != nil {
 		return nil, err
 	}
 
 	var modelsRes []struct {
-		Name string `json:"model_name"`
+		Name     string `json:"model_name"`
+		Hash     string `json:"hash"`
+		Filename string `json:"filename"`
 	}
 	err = json.Unmarshal([]byte(res), &modelsRes)
 	if err != nil {
@@ -234,11 +437,59 @@ func (a *sdAPIType) GetModels(ctx context.Context) (models []string, err error)
 	}
 
 	for _, m := range modelsRes {
-		models = append(models, m.Name)
+		models = append(models, sdAPIModelInfo{
+			Name:     m.Name,
+			Hash:     m.Hash,
+			Filename: m.Filename,
+		})
 	}
 	return
 }
 
+// Returns the model name of a checkpoint title, for ex. "sdxl_base" for "sdxl/base.safetensors [31e35c80fc]".
+func sdAPICheckpointModelName(title string) string {
+	if i := strings.LastIndex(title, " ["); i >= 0 && strings.HasSuffix(title, "]") {
+		title = title[:i]
+	}
+	title = strings.NewReplacer("/", "_", "\\", "_").Replace(title)
+	return strings.TrimSuffix(title, filepath.Ext(title))
+}
+
+func (a *sdAPIType) GetLoadedModel(ctx context.Context) (model string, err error) {
+	res, err := a.req(ctx, "/options", "", nil)
+	if err != nil {
+		return "", err
+	}
+
+	var optionsRes struct {
+		Checkpoint string `json:"sd_model_checkpoint"`
+	}
+	err = json.Unmarshal([]byte(res), &optionsRes)
+	if err != nil {
+		return "", err
+	}
+	return sdAPICheckpointModelName(optionsRes.Checkpoint), nil
+}
+
+// Loads the given model. Stable Diffusion WebUI waits for the currently running job to finish first.
+func (a *sdAPIType) SetModel(ctx context.Context, model string) error {
+	postData, err := json.Marshal(map[string]interface{}{
+		"sd_model_checkpoint": model,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = a.req(ctx, "/options", "", postData)
+	return err
+}
+
+// Rescans the model directory for new models.
+func (a *sdAPIType) RefreshModels(ctx context.Context) error {
+	_, err := a.req(ctx, "/refresh-checkpoints", "", []byte{})
+	return err
+}
+
 func (a *sdAPIType) GetSamplers(ctx context.Context) (samplers []string, err error) {
 	res, err := a.req(ctx, "/samplers", "", nil)
 	if err != nil {
@@ -273,28 +524,78 @@ func (a *sdAPIType) GetEmbeddings(ctx context.Context) (embs []string, err error
 		return nil, err
 	}
 
-	for i := range embList.Loaded {
-		embs = append(embs, i)
-	}
+	embs = maps.Keys(embList.Loaded)
+	slices.Sort(embs)
 	return
 }
 
-func (a *sdAPIType) GetLoRAs(ctx context.Context) (loras []string, err error) {
+const sdAPILoRAMaxTriggerWords = 5
+
+// Returns the trigger words from the metadata of a LoRA. If the trigger phrase is not set, then the
+// most frequent tags of the training dataset are returned.
+func sdAPILoRATriggerWords(metadata map[string]interface{}) []string {
+	if phrase, ok := metadata["modelspec.trigger_phrase"].(string); ok && phrase != "" {
+		return splitNonEmpty(phrase)
+	}
+
+	// WebUI decodes the JSON metadata values, but older versions return them as strings.
+	var datasets map[string]map[string]float64
+	switch v := metadata["ss_tag_frequency"].(type) {
+	case string:
+		_ = json.Unmarshal([]byte(v), &datasets)
+	case map[string]interface{}:
+		data, _ := json.Marshal(v)
+		_ = json.Unmarshal(data, &datasets)
+	}
+
+	tagFreqs := make(map[string]float64)
+	for _, tags := range datasets {
+		for tag, freq := range tags {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tagFreqs[tag] += freq
+			}
+		}
+	}
+	tags := maps.Keys(tagFreqs)
+	sort.Slice(tags, func(i, j int) bool {
+		if tagFreqs[tags[i]] != tagFreqs[tags[j]] {
+			return tagFreqs[tags[i]] > tagFreqs[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+	if len(tags) > sdAPILoRAMaxTriggerWords {
+		tags = tags[:sdAPILoRAMaxTriggerWords]
+	}
+	return tags
+}
+
+func (a *sdAPIType) GetLoRAs(ctx context.Context) (loras []sdAPILoRA, err error) {
 	res, err := a.req(ctx, "/loras", "", nil)
 	if err != nil {
 		return nil, err
 	}
 
 	var lorasRes []struct {
-		Name string `json:"name"`
+		Name     string                 `json:"name"`
+		Alias    string                 `json:"alias"`
+		Path     string                 `json:"path"`
+		Metadata map[string]interface{} `json:"metadata"`
 	}
 	err = json.Unmarshal([]byte(res), &lorasRes)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, lora := range lorasRes {
-		loras = append(loras, lora.Name)
+	for _, l := range lorasRes {
+		lora := sdAPILoRA{
+			Name:         l.Name,
+			TriggerWords: sdAPILoRATriggerWords(l.Metadata),
+			Path:         l.Path,
+		}
+		if l.Alias != l.Name {
+			lora.Alias = l.Alias
+		}
+		loras = append(loras, lora)
 	}
 	return
 }
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const sdAPIMockDefaultStepDuration = 100 * time.Millisecond
+const sdAPIMockUpscaleDuration = 2 * time.Second
+const sdAPIMockInterrogateDuration = time.Second
+const sdAPIMockModelLoadDuration = 3 * time.Second
+
+type sdAPIMockParams struct {
+	Models    []string `yaml:"sd_mock_models"`
+	Samplers  []string `yaml:"sd_mock_samplers"`
+	Upscalers []string `yaml:"sd_mock_upscalers"`
+
+	// Simulated duration of one sampling step of one image.
+	StepDuration time.Duration `yaml:"sd_mock_step_duration"`
+	// Probability (0..1) of a render or upscale failing with an error.
+	ErrorRate float64 `yaml:"sd_mock_error_rate"`
+}
+
+// sdAPIMockType is a fake Stable Diffusion backend which renders deterministic placeholder
+// images and simulates progress, so the bot can be developed and demoed without a GPU.
+type sdAPIMockType struct {
+	params sdAPIMockParams
+
+	mutex        sync.Mutex
+	loadedModel  string
+	jobStartedAt time.Time
+	jobDuration  time.Duration
+	jobInterrupt chan bool
+}
+
+func newSDAPIMock(p sdAPIMockParams) *sdAPIMockType {
+	if len(p.Models) == 0 {
+		p.Models = []string{"mock-sd15", "mock-sdxl"}
+	}
+	if len(p.Samplers) == 0 {
+		p.Samplers = []string{"Euler a", "DPM++ 2M Karras", "DPM++ 3M SDE"}
+	}
+	if len(p.Upscalers) == 0 {
+		p.Upscalers = []string{"None", "Lanczos", "LDSR", "R-ESRGAN 4x+"}
+	}
+	if p.StepDuration <= 0 {
+		p.StepDuration = sdAPIMockDefaultStepDuration
+	}
+	return &sdAPIMockType{
+		params:      p,
+		loadedModel: p.Models[0],
+	}
+}
+
+func (a *sdAPIMockType) injectError() error {
+	if rand.Float64() < a.params.ErrorRate {
+		return fmt.Errorf("mock error")
+	}
+	return nil
+}
+
+// Simulates a job which takes the given duration. Returns early if the job gets interrupted
+// or the context is done.
+func (a *sdAPIMockType) runJob(ctx context.Context, d time.Duration) error {
+	a.mutex.Lock()
+	if a.jobInterrupt != nil {
+		a.mutex.Unlock()
+		return fmt.Errorf("mock backend is busy")
+	}
+	a.jobStartedAt = time.Now()
+	a.jobDuration = d
+	a.jobInterrupt = make(chan bool, 1)
+	interrupt := a.jobInterrupt
+	a.mutex.Unlock()
+
+	defer func() {
+		a.mutex.Lock()
+		a.jobInterrupt = nil
+		a.mutex.Unlock()
+	}()
+
+	select {
+	case <-time.After(d):
+		return a.injectError()
+	case <-interrupt:
+		return fmt.Errorf("interrupted")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a *sdAPIMockType) placeholderColor(seed int64) color.RGBA {
+	r := rand.New(rand.NewSource(seed))
+	return color.RGBA{R: uint8(64 + r.Intn(128)), G: uint8(64 + r.Intn(128)), B: uint8(64 + r.Intn(128)), A: 255}
+}
+
+// Splits the text to lines which fit in the given number of characters.
+func (a *sdAPIMockType) wrapText(text string, maxChars int) (lines []string) {
+	var line string
+	for _, word := range strings.Fields(text) {
+		for len(word) > maxChars {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, word[:maxChars])
+			word = word[maxChars:]
+		}
+		if line == "" {
+			line = word
+		} else if len(line)+1+len(word) <= maxChars {
+			line += " " + word
+		} else {
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return
+}
+
+// Returns the colour between c1 and c2 at t (0-1).
+func (a *sdAPIMockType) blendColor(c1, c2 color.RGBA, t float64) color.RGBA {
+	return color.RGBA{
+		R: uint8(float64(c1.R)*(1-t) + float64(c2.R)*t),
+		G: uint8(float64(c1.G)*(1-t) + float64(c2.G)*t),
+		B: uint8(float64(c1.B)*(1-t) + float64(c2.B)*t),
+		A: 255,
+	}
+}
+
+// Returns a PNG image with a vertical gradient derived from the seed and the given text lines drawn
+// on it. The gradient of the subseed is mixed in with the given strength, like variation seeds do.
+func (a *sdAPIMockType) renderPlaceholder(seed, subseed int64, subseedStrength float64, width, height int, text []string) ([]byte, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid image size")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	c1 := a.blendColor(a.placeholderColor(seed), a.placeholderColor(subseed), subseedStrength)
+	c2 := a.blendColor(a.placeholderColor(seed+1), a.placeholderColor(subseed+1), subseedStrength)
+	for y := 0; y < height; y++ {
+		c := a.blendColor(c1, c2, float64(y)/float64(height))
+		draw.Draw(img, image.Rect(0, y, width, y+1), &image.Uniform{c}, image.Point{}, draw.Src)
+	}
+
+	face := basicfont.Face7x13
+	const margin = 8
+	lineHeight := face.Metrics().Height.Ceil() + 2
+	maxChars := (width - 2*margin) / face.Advance
+	var lines []string
+	for _, t := range text {
+		lines = append(lines, a.wrapText(t, maxChars)...)
+	}
+
+	d := font.Drawer{
+		Dst:  img,
+		Src:  image.White,
+		Face: face,
+	}
+	for i, l := range lines {
+		y := margin + (i+1)*lineHeight
+		if y > height-margin {
+			break
+		}
+		d.Dot = fixed.P(margin, y)
+		d.DrawString(l)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Simulates the model swap like the WebUI does when the model is overridden. Returns the time
+// needed for loading the model.
+func (a *sdAPIMockType) overrideModel(model string) (time.Duration, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if model == "" || model == a.loadedModel {
+		return 0, nil
+	}
+	if !slices.Contains(a.params.Models, model) {
+		return 0, fmt.Errorf("unknown model")
+	}
+	a.loadedModel = model
+	return sdAPIMockModelLoadDuration, nil
+}
+
+func (a *sdAPIMockType) Render(ctx context.Context, p ReqParams, imageData ImageFileData) (imgs [][]byte, err error) {
+	params := p.(ReqParamsRender)
+
+	if !slices.Contains(a.params.Samplers, params.SamplerName) && params.SamplerName != "" {
+		return nil, fmt.Errorf("unknown sampler")
+	}
+
+	if !slices.Contains(a.params.Samplers, params.HR.SamplerName) && params.HR.SamplerName != "" {
+		return nil, fmt.Errorf("unknown hr sampler")
+	}
+
+	steps := params.Steps
+	if params.HR.enabled() {
+		steps += params.HR.SecondPassSteps
+	}
+	d := time.Duration(steps*params.NumOutputs) * a.params.StepDuration
+
+	loadDuration, err := a.overrideModel(params.ModelName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = a.runJob(ctx, d+loadDuration); err != nil {
+		return nil, err
+	}
+
+	width, height := params.HR.size(params.Width, params.Height)
+
+	for i := 0; i < params.NumOutputs; i++ {
+		text := []string{
+			params.PromptWithExtraNetworks(),
+			fmt.Sprintf("seed %d, %dx%d", params.Seed+uint32(i), width, height),
+		}
+		if params.SubseedStrength > 0 {
+			text = append(text, fmt.Sprintf("subseed %d, strength %.2f", params.Subseed, params.SubseedStrength))
+		}
+		if params.Refiner != "" {
+			text = append(text, fmt.Sprintf("refiner %s at %.2f", params.Refiner, params.RefinerSwitchAt))
+		}
+		if params.HR.enabled() {
+			text = append(text, fmt.Sprintf("hr %s, %s", params.HRPromptWithExtraNetworks(), params.HRSamplerName()))
+			if params.HR.CFGScale > 0 {
+				text = append(text, fmt.Sprintf("hr cfg %.1f", params.HR.CFGScale))
+			}
+		}
+		for k, v := range sdAPIOverrideSettings(params) {
+			if k != "sd_model_checkpoint" {
+				text = append(text, fmt.Sprintf("%s: %v", k, v))
+			}
+		}
+		img, err := a.renderPlaceholder(int64(params.Seed)+int64(i), int64(params.Subseed), float64(params.SubseedStrength), width, height, text)
+		if err != nil {
+			return nil, err
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs, nil
+}
+
+// Returns if the WebUI would crop the image with the request sent for the given upscale params.
+// The request is decoded with the WebUI's default, which is cropping if the field is missing.
+func sdAPIMockUpscaleCrop(params ReqParamsUpscale) (bool, error) {
+	postData, err := json.Marshal(sdAPIUpscaleReq(params, nil))
+	if err != nil {
+		return false, err
+	}
+	webUIReq := struct {
+		Crop bool `json:"upscaling_crop"`
+	}{Crop: true}
+	err = json.Unmarshal(postData, &webUIReq)
+	return webUIReq.Crop, err
+}
+
+func (a *sdAPIMockType) Upscale(ctx context.Context, p ReqParams, imageData ImageFileData) (imgs [][]byte, err error) {
+	params := p.(ReqParamsUpscale)
+
+	src, _, err := image.Decode(bytes.NewReader(imageData.data))
+	if err != nil {
+		return nil, fmt.Errorf("image decode error: %w", err)
+	}
+
+	if err = a.runJob(ctx, sdAPIMockUpscaleDuration); err != nil {
+		return nil, err
+	}
+
+	crop, err := sdAPIMockUpscaleCrop(params)
+	if err != nil {
+		return nil, err
+	}
+
+	b := src.Bounds()
+	srcRect := b
+	var dst *image.RGBA
+	switch {
+	case params.Width > 0 && params.Height > 0:
+		dst = image.NewRGBA(image.Rect(0, 0, params.Width, params.Height))
+		if crop { // Cropping the center of the source image to the destination's aspect ratio.
+			ratio := float64(params.Width) / float64(params.Height)
+			w, h := b.Dx(), b.Dy()
+			if float64(w)/float64(h) > ratio {
+				w = int(float64(h) * ratio)
+			} else {
+				h = int(float64(w) / ratio)
+			}
+			srcRect = image.Rect(b.Min.X+(b.Dx()-w)/2, b.Min.Y+(b.Dy()-h)/2, b.Min.X+(b.Dx()+w)/2, b.Min.Y+(b.Dy()+h)/2)
+		}
+	case params.resizes():
+		dst = image.NewRGBA(image.Rect(0, 0, int(float32(b.Dx())*params.Scale), int(float32(b.Dy())*params.Scale)))
+	default: // Face restoration only, it's not simulated.
+		dst = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	}
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, srcRect, draw.Src, nil)
+
+	buf := new(bytes.Buffer)
+	if err = png.Encode(buf, dst); err != nil {
+		return nil, err
+	}
+	return [][]byte{buf.Bytes()}, nil
+}
+
+// Returns a caption describing the size of the image, in the style of the given interrogation model.
+func (a *sdAPIMockType) Interrogate(ctx context.Context, p ReqParams, imageData ImageFileData) (caption string, err error) {
+	params := p.(ReqParamsInterrogate)
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(imageData.data))
+	if err != nil {
+		return "", fmt.Errorf("image decode error: %w", err)
+	}
+
+	if err = a.runJob(ctx, sdAPIMockInterrogateDuration); err != nil {
+		return "", err
+	}
+
+	if params.Model == "deepdanbooru" {
+		return fmt.Sprintf("placeholder, mock, %dx%d, no humans", cfg.Width, cfg.Height), nil
+	}
+	return fmt.Sprintf("a mock placeholder image of %dx%d pixels", cfg.Width, cfg.Height), nil
+}
+
+// Tints the masked area of the image with a colour derived from the seed.
+func (a *sdAPIMockType) Inpaint(ctx context.Context, p ReqParams, imageData ImageFileData, mask []byte) (imgs [][]byte, err error) {
+	params := p.(ReqParamsOutpaint)
+
+	src, _, err := image.Decode(bytes.NewReader(imageData.data))
+	if err != nil {
+		return nil, fmt.Errorf("image decode error: %w", err)
+	}
+	maskImg, _, err := image.Decode(bytes.NewReader(mask))
+	if err != nil {
+		return nil, fmt.Errorf("mask decode error: %w", err)
+	}
+	if src.Bounds().Size() != maskImg.Bounds().Size() {
+		return nil, fmt.Errorf("mask size doesn't match the image size")
+	}
+
+	loadDuration, err := a.overrideModel(params.ModelName)
+	if err != nil {
+		return nil, err
+	}
+	if err = a.runJob(ctx, time.Duration(params.Steps)*a.params.StepDuration+loadDuration); err != nil {
+		return nil, err
+	}
+
+	b := src.Bounds()
+	mb := maskImg.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	tint := a.placeholderColor(int64(params.Seed))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			if color.GrayModel.Convert(maskImg.At(mb.Min.X+x, mb.Min.Y+y)).(color.Gray).Y < 128 {
+				continue
+			}
+			c := dst.RGBAAt(x, y)
+			c.R = uint8((int(c.R) + int(tint.R)) / 2)
+			c.G = uint8((int(c.G) + int(tint.G)) / 2)
+			c.B = uint8((int(c.B) + int(tint.B)) / 2)
+			dst.SetRGBA(x, y, c)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err = png.Encode(buf, dst); err != nil {
+		return nil, err
+	}
+	return [][]byte{buf.Bytes()}, nil
+}
+
+func (a *sdAPIMockType) Interrupt(ctx context.Context) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.jobInterrupt != nil {
+		select {
+		case a.jobInterrupt <- true:
+		default:
+		}
+	}
+	return nil
+}
+
+func (a *sdAPIMockType) GetProgress(ctx context.Context) (progressPercent int, eta time.Duration, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.jobInterrupt == nil || a.jobDuration == 0 {
+		return 0, 0, nil
+	}
+
+	elapsed := time.Since(a.jobStartedAt)
+	if elapsed > a.jobDuration {
+		return 100, 0, nil
+	}
+	return int(elapsed * 100 / a.jobDuration), a.jobDuration - elapsed, nil
+}
+
+func (a *sdAPIMockType) GetModels(ctx context.Context) (models []string, err error) {
+	return a.params.Models, nil
+}
+
+func (a *sdAPIMockType) GetModelInfos(ctx context.Context) (models []sdAPIModelInfo, err error) {
+	for _, m := range a.params.Models {
+		models = append(models, sdAPIModelInfo{
+			Name: m,
+			Hash: fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(m))),
+		})
+	}
+	return
+}
+
+func (a *sdAPIMockType) GetLoadedModel(ctx context.Context) (model string, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.loadedModel, nil
+}
+
+func (a *sdAPIMockType) SetModel(ctx context.Context, model string) error {
+	if !slices.Contains(a.params.Models, model) {
+		return fmt.Errorf("unknown model")
+	}
+
+	a.mutex.Lock()
+	loaded := a.loadedModel == model
+	a.mutex.Unlock()
+	if loaded {
+		return nil
+	}
+
+	select {
+	case <-time.After(sdAPIMockModelLoadDuration):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	a.mutex.Lock()
+	a.loadedModel = model
+	a.mutex.Unlock()
+	return nil
+}
+
+func (a *sdAPIMockType) RefreshModels(ctx context.Context) error {
+	return nil
+}
+
+func (a *sdAPIMockType) GetSamplers(ctx context.Context) (samplers []string, err error) {
+	return a.params.Samplers, nil
+}
+
+func (a *sdAPIMockType) GetEmbeddings(ctx context.Context) (embs []string, err error) {
+	return []string{"mock-embedding"}, nil
+}
+
+func (a *sdAPIMockType) GetLoRAs(ctx context.Context) (loras []sdAPILoRA, err error) {
+	return []sdAPILoRA{
+		{Name: "mock-lora"},
+		{Name: "mock-style-lora-v2", Alias: "mock-style", TriggerWords: []string{"mockstyle", "placeholder art"}},
+	}, nil
+}
+
+func (a *sdAPIMockType) GetUpscalers(ctx context.Context) (upscalers []string, err error) {
+	return a.params.Upscalers, nil
+}
+
+func (a *sdAPIMockType) GetVAEs(ctx context.Context) (vaes []string, err error) {
+	return []string{"mock-vae"}, nil
+}
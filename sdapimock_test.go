@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+func TestSDAPIMockUpscaleCrop(t *testing.T) {
+	for _, crop := range []bool{false, true} {
+		got, err := sdAPIMockUpscaleCrop(ReqParamsUpscale{Upscaler: "LDSR", Width: 1024, Height: 512, Crop: crop})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != crop {
+			t.Errorf("crop %v: got %v", crop, got)
+		}
+	}
+}
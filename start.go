@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -33,27 +34,34 @@ func isStableDiffusionRunning() (bool, error) {
 	return false, nil
 }
 
-func startStableDiffusionIfNeeded(ctx context.Context) error {
+func startStableDiffusionIfNeeded(ctx context.Context) (err error) {
 	isRunning, err := isStableDiffusionRunning()
 	if err != nil {
 		return err
 	}
 
 	if isRunning {
-		fmt.Println("stable diffusion is already running")
+		slog.Info("stable diffusion is already running")
 	} else {
-		fmt.Println("starting stable diffusion... ")
+		slog.Info("starting stable diffusion...")
+		metrics.IncSDStartAttempt()
+		defer func() {
+			if err != nil {
+				metrics.IncSDStartFailure()
+			}
+		}()
+		webUIPath := getParams().StableDiffusionWebUIPath
 		cmd := exec.Cmd{
-			Path: params.StableDiffusionWebUIPath,
-			Args: []string{params.StableDiffusionWebUIPath, "--api"},
-			Dir:  filepath.Dir(params.StableDiffusionWebUIPath),
+			Path: webUIPath,
+			Args: []string{webUIPath, "--api"},
+			Dir:  filepath.Dir(webUIPath),
 		}
 		if err := cmd.Start(); err != nil {
 			return fmt.Errorf("can't start stable diffusion: %s", err.Error())
 		}
 	}
 
-	fmt.Println("checking stable diffusion api...")
+	slog.Info("checking stable diffusion api...")
 	startedAt := time.Now()
 	var lastPingAt time.Time
 	for {
@@ -78,8 +86,8 @@ func startStableDiffusionIfNeeded(ctx context.Context) error {
 		}
 
 		lastPingAt = time.Now()
-		fmt.Println("  ping...")
+		slog.Debug("ping...")
 	}
-	fmt.Println("  ok")
+	slog.Info("stable diffusion api ok")
 	return nil
 }
@@ -61,7 +61,7 @@ func versionCheck(ctx context.Context) (latestVersion, currentVersion string, er
 	}
 	latestVersion = release.GetTagName()
 
-	repo, err := git.PlainOpen(params.StableDiffusionPath)
+	repo, err := git.PlainOpen(getParams().StableDiffusionPath)
 	if err != nil {
 		return "", "", fmt.Errorf("getting current stable diffusion version: %w", err)
 	}